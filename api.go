@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"google.golang.org/api/testing/v1"
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+)
+
+/*
+apiClient calls the virtual device testing API.
+
+Every call is scoped to the build's slug, a build runs a single test matrix: starting another one for the same
+build slug fails with "Build already exists".
+*/
+type apiClient struct {
+	baseURL   string
+	appSlug   string
+	buildSlug string
+	token     string
+	client    *http.Client
+}
+
+func newAPIClient(configs ConfigsModel) apiClient {
+	return apiClient{
+		baseURL:   configs.APIBaseURL,
+		appSlug:   configs.AppSlug,
+		buildSlug: configs.BuildSlug,
+		token:     string(configs.APIToken),
+		client:    &http.Client{},
+	}
+}
+
+func (c apiClient) testURL() string {
+	return c.baseURL + "/" + c.appSlug + "/" + c.buildSlug + "/" + c.token
+}
+
+func (c apiClient) assetsURL() string {
+	return c.baseURL + "/assets/" + c.appSlug + "/" + c.buildSlug + "/" + c.token
+}
+
+// getUploadURL requests the test bundle's upload url.
+func (c apiClient) getUploadURL() (UploadURLRequest, error) {
+	req, err := http.NewRequest("POST", c.assetsURL(), nil)
+	if err != nil {
+		return UploadURLRequest{}, fmt.Errorf("failed to create http request: %w", err)
+	}
+
+	body, err := c.do(req)
+	if err != nil {
+		return UploadURLRequest{}, err
+	}
+
+	var responseModel UploadURLRequest
+	if err := json.Unmarshal(body, &responseModel); err != nil {
+		return UploadURLRequest{}, fmt.Errorf("failed to unmarshal response body: %w", err)
+	}
+
+	return responseModel, nil
+}
+
+func (c apiClient) startTest(testMatrix *testing.TestMatrix) error {
+	jsonByte, err := json.Marshal(testMatrix)
+	if err != nil {
+		return fmt.Errorf("failed to marshal test model: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.testURL(), bytes.NewBuffer(jsonByte))
+	if err != nil {
+		return fmt.Errorf("failed to create http request: %w", err)
+	}
+
+	_, err = c.do(req)
+	return err
+}
+
+// getSteps returns the test matrix steps, a failed request is retried once.
+func (c apiClient) getSteps() (*toolresults.ListStepsResponse, error) {
+	req, err := http.NewRequest("GET", c.testURL(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create http request: %w", err)
+	}
+
+	body, err := c.do(req)
+	if err != nil {
+		body, err = c.do(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	responseModel := &toolresults.ListStepsResponse{}
+	if err := json.Unmarshal(body, responseModel); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response body: %w, body: %s", err, string(body))
+	}
+
+	return responseModel, nil
+}
+
+// getAssets returns the test matrix's test assets (file name -> download url).
+func (c apiClient) getAssets() (map[string]string, error) {
+	req, err := http.NewRequest("GET", c.assetsURL(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create http request: %w", err)
+	}
+
+	body, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	responseModel := map[string]string{}
+	if err := json.Unmarshal(body, &responseModel); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response body: %w", err)
+	}
+
+	return responseModel, nil
+}
+
+// do sends the request and returns the response body, non 200 responses are errors.
+func (c apiClient) do(req *http.Request) ([]byte, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get http response: %w", err)
+	}
+	defer closeResponse(resp)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non success response code: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	return body, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_apiClient_getUploadURL(t *testing.T) {
+	var gotMethod, gotPath string
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod, gotPath = r.Method, r.URL.Path
+		gotBody, _ = io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(`{"appUrl":"https://storage/app","testAppUrl":"https://storage/test-app"}`))
+	}))
+	defer server.Close()
+
+	client := apiClient{baseURL: server.URL, appSlug: "app-slug", buildSlug: "build-slug", token: "token", client: server.Client()}
+	got, err := client.getUploadURL()
+	require.NoError(t, err)
+
+	require.Equal(t, UploadURLRequest{AppURL: "https://storage/app", TestAppURL: "https://storage/test-app"}, got)
+	require.Equal(t, http.MethodPost, gotMethod)
+	require.Equal(t, "/assets/app-slug/build-slug/token", gotPath)
+	require.Empty(t, gotBody)
+}
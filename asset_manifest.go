@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const assetManifestFileName = "manifest.json"
+
+// assetManifest describes the test assets downloaded into the download dir.
+type assetManifest struct {
+	Assets []assetManifestEntry `json:"assets"`
+}
+
+type assetManifestEntry struct {
+	Index int `json:"index"`
+	// Name is the asset's original name, LocalPath can differ if the name had to be shortened.
+	Name      string          `json:"name"`
+	LocalPath string          `json:"local_path,omitempty"`
+	Size      int64           `json:"size"`
+	MD5       string          `json:"md5,omitempty"`
+	Kind      string          `json:"kind"`
+	Dimension *assetDimension `json:"dimension,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+/*
+createAssetManifest lists the downloaded (file name -> local path) and failed assets ordered by
+device, attempt and name, so the order does not depend on the API's response.
+*/
+func createAssetManifest(downloadedPths map[string]string, failures []assetDownloadError) (assetManifest, error) {
+	var entries []assetManifestEntry
+	for fileName, pth := range downloadedPths {
+		fileInfo, err := os.Stat(pth)
+		if err != nil {
+			return assetManifest{}, fmt.Errorf("failed to get file info of %s: %w", pth, err)
+		}
+
+		md5Hash, err := fileMD5(pth)
+		if err != nil {
+			return assetManifest{}, fmt.Errorf("failed to calculate checksum of %s: %w", pth, err)
+		}
+
+		entry := newAssetManifestEntry(fileName)
+		entry.LocalPath = pth
+		entry.Size = fileInfo.Size()
+		entry.MD5 = md5Hash
+		entries = append(entries, entry)
+	}
+
+	for _, failure := range failures {
+		entry := newAssetManifestEntry(failure.fileName)
+		entry.Error = failure.err.Error()
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return assetManifestEntryLess(entries[i], entries[j])
+	})
+
+	for i := range entries {
+		entries[i].Index = i
+	}
+
+	return assetManifest{Assets: entries}, nil
+}
+
+func newAssetManifestEntry(fileName string) assetManifestEntry {
+	entry := assetManifestEntry{
+		Name: fileName,
+		Kind: assetKind(fileName),
+	}
+	if dimension, ok := parseAssetFileName(fileName); ok {
+		entry.Dimension = &dimension
+	}
+	return entry
+}
+
+// assetManifestEntryLess orders the device independent assets first, then by device, attempt and name.
+func assetManifestEntryLess(a, b assetManifestEntry) bool {
+	if (a.Dimension == nil) != (b.Dimension == nil) {
+		return a.Dimension == nil
+	}
+
+	if a.Dimension != nil && b.Dimension != nil {
+		if idA, idB := a.Dimension.dimensionID(), b.Dimension.dimensionID(); idA != idB {
+			return idA < idB
+		}
+		if a.Dimension.Attempt != b.Dimension.Attempt {
+			return a.Dimension.Attempt < b.Dimension.Attempt
+		}
+	}
+
+	return a.Name < b.Name
+}
+
+func writeAssetManifest(dir string, manifest assetManifest) (string, error) {
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal asset manifest: %w", err)
+	}
+
+	pth := filepath.Join(dir, assetManifestFileName)
+	if err := os.WriteFile(pth, content, 0644); err != nil {
+		return "", fmt.Errorf("failed to write asset manifest: %w", err)
+	}
+
+	return pth, nil
+}
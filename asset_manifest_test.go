@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_createAssetManifest(t *testing.T) {
+	// Given
+	dir := t.TempDir()
+	downloadedPths := map[string]string{}
+	for _, name := range []string{
+		"iphone8-16.6-en-portrait_test_result_0.xml",
+		"iphone8-16.6-en-portrait-rerun_1_test_result_0.xml",
+		"iphone13pro-16.6-en-portrait_video.mp4",
+		"summary.json",
+	} {
+		pth := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(pth, []byte("content"), 0644))
+		downloadedPths[name] = pth
+	}
+	failures := []assetDownloadError{{fileName: "iphone8-16.6-en-portrait_syslog.txt", err: errors.New("non success response code: 404")}}
+
+	// When
+	manifest, err := createAssetManifest(downloadedPths, failures)
+
+	// Then
+	require.NoError(t, err)
+
+	var names, kinds []string
+	for i, entry := range manifest.Assets {
+		require.Equal(t, i, entry.Index)
+		names = append(names, entry.Name)
+		kinds = append(kinds, entry.Kind)
+	}
+	require.Equal(t, []string{
+		"summary.json",
+		"iphone13pro-16.6-en-portrait_video.mp4",
+		"iphone8-16.6-en-portrait_syslog.txt",
+		"iphone8-16.6-en-portrait_test_result_0.xml",
+		"iphone8-16.6-en-portrait-rerun_1_test_result_0.xml",
+	}, names)
+	require.Equal(t, []string{assetKindOther, assetKindVideo, assetKindLog, assetKindJUnit, assetKindJUnit}, kinds)
+
+	rerun := manifest.Assets[4]
+	require.Equal(t, &assetDimension{Model: "iphone8", Version: "16.6", Locale: "en", Orientation: "portrait", Attempt: 1}, rerun.Dimension)
+	require.Equal(t, int64(7), rerun.Size)
+	require.Equal(t, "9a0364b9e99bb480dd25e1f0284c8555", rerun.MD5)
+
+	failed := manifest.Assets[2]
+	require.Empty(t, failed.LocalPath)
+	require.Equal(t, "non success response code: 404", failed.Error)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	downloadConcurrency    = 5
+	downloadMaxRetries     = 3
+	downloadRetryWait      = 5 * time.Second
+	downloadRequestTimeout = 10 * time.Minute
+)
+
+// assetDownloadError is the failure of a single asset download.
+type assetDownloadError struct {
+	fileName string
+	err      error
+}
+
+func (e assetDownloadError) Error() string {
+	return fmt.Sprintf("%s: %s", e.fileName, e.err)
+}
+
+type assetDownloader struct {
+	client      *http.Client
+	concurrency int
+	maxRetries  int
+	retryWait   time.Duration
+}
+
+func newAssetDownloader() assetDownloader {
+	return assetDownloader{
+		client:      &http.Client{Timeout: downloadRequestTimeout},
+		concurrency: downloadConcurrency,
+		maxRetries:  downloadMaxRetries,
+		retryWait:   downloadRetryWait,
+	}
+}
+
+/*
+downloadAll downloads the assets (file name -> url) into dir, with at most concurrency downloads at a time.
+
+A failed download does not stop the others: the downloaded files' paths (file name -> local path)
+and the failures are both returned, so the caller can work with the partial result.
+*/
+func (d assetDownloader) downloadAll(assets map[string]string, dir string) (map[string]string, []assetDownloadError) {
+	var fileNames []string
+	for fileName := range assets {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	var (
+		mu          sync.Mutex
+		wg          sync.WaitGroup
+		downloaded  = map[string]string{}
+		failures    []assetDownloadError
+		concurrency = make(chan struct{}, d.concurrency)
+	)
+
+	for _, fileName := range fileNames {
+		fileName := fileName
+
+		wg.Add(1)
+		concurrency <- struct{}{}
+		go func() {
+			defer func() {
+				<-concurrency
+				wg.Done()
+			}()
+
+			pth := localAssetPath(dir, fileName)
+			err := d.download(assets[fileName], pth)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures = append(failures, assetDownloadError{fileName: fileName, err: err})
+			} else {
+				downloaded[fileName] = pth
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].fileName < failures[j].fileName
+	})
+
+	return downloaded, failures
+}
+
+// download downloads the url to localPath, retrying failed attempts from the already downloaded offset.
+func (d assetDownloader) download(url string, localPath string) error {
+	var lastErr error
+	for attempt := 0; attempt <= d.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(d.retryWait)
+		}
+
+		expectedMD5, err := d.downloadAttempt(url, localPath)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if expectedMD5 != "" {
+			if err := verifyMD5(localPath, expectedMD5); err != nil {
+				// The partial content can't be trusted, start over
+				if err := os.Remove(localPath); err != nil {
+					log.Printf("Failed to remove corrupted download (%s): %s", localPath, err)
+				}
+				lastErr = err
+				continue
+			}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("failed after %d retries: %s", d.maxRetries, lastErr)
+}
+
+// downloadAttempt continues the download of url into localPath and returns the expected MD5 (hex) if the server provided one.
+func (d assetDownloader) downloadAttempt(url string, localPath string) (string, error) {
+	out, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to open the local file for write: %s", err)
+	}
+	defer func() {
+		if err := out.Close(); err != nil {
+			log.Printf("Failed to close download file (%s): %s", localPath, err)
+		}
+	}()
+
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", fmt.Errorf("failed to seek the local file: %s", err)
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create download request: %s", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download: %s", err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Failed to close download response body: %s", err)
+		}
+	}()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The server sent the whole content
+		if err := out.Truncate(0); err != nil {
+			return "", fmt.Errorf("failed to truncate the local file: %s", err)
+		}
+		if _, err := out.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to seek the local file: %s", err)
+		}
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The previous attempt already downloaded the whole content
+		return expectedMD5FromHeaders(resp.Header), nil
+	default:
+		return "", fmt.Errorf("non success response code: %d", resp.StatusCode)
+	}
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return "", fmt.Errorf("failed to save content into file: %s", err)
+	}
+
+	return expectedMD5FromHeaders(resp.Header), nil
+}
+
+/*
+expectedMD5FromHeaders returns the content MD5 (hex) from:
+- the x-goog-hash header (md5=<base64>), set by GCS
+- the ETag header, if it looks like an MD5 (multipart upload and weak ETags are not content hashes)
+*/
+func expectedMD5FromHeaders(header http.Header) string {
+	for _, value := range header.Values("x-goog-hash") {
+		for _, hash := range strings.Split(value, ",") {
+			algorithm, encoded, found := strings.Cut(strings.TrimSpace(hash), "=")
+			if !found || algorithm != "md5" {
+				continue
+			}
+
+			decoded, err := base64.StdEncoding.DecodeString(encoded)
+			if err == nil {
+				return hex.EncodeToString(decoded)
+			}
+		}
+	}
+
+	etag := strings.Trim(header.Get("ETag"), `"`)
+	if len(etag) == 32 {
+		if _, err := hex.DecodeString(etag); err == nil {
+			return strings.ToLower(etag)
+		}
+	}
+
+	return ""
+}
+
+func verifyMD5(pth, expected string) error {
+	actual, err := fileMD5(pth)
+	if err != nil {
+		return err
+	}
+
+	if actual != expected {
+		return fmt.Errorf("md5 mismatch: downloaded file has %s, server reported %s", actual, expected)
+	}
+
+	return nil
+}
+
+func fileMD5(pth string) (string, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %s", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Printf("Failed to close file (%s): %s", pth, err)
+		}
+	}()
+
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to read file: %s", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+const maxFileNameLength = 255
+
+/*
+localAssetPath returns the download path of the asset.
+
+On HFS file system the max file name length is 255 UTF-16 encoding units. Longer names keep their
+end (the asset name and extension), prefixed with a short hash of the full name, so that assets
+sharing a long suffix do not overwrite each other.
+*/
+func localAssetPath(dir, fileName string) string {
+	base := fileName
+	if len(base) > maxFileNameLength {
+		hash := sha256.Sum256([]byte(fileName))
+		prefix := hex.EncodeToString(hash[:])[:8] + "-"
+		base = prefix + base[len(base)-(maxFileNameLength-len(prefix)):]
+		log.Warnf("too long filename: %s, trimming to: %s", fileName, base)
+	}
+
+	return filepath.Join(dir, base)
+}
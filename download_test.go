@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAssetServer serves assets with Range support, the first request of an interrupted asset only returns half of the content.
+type fakeAssetServer struct {
+	mu          sync.Mutex
+	assets      map[string]string
+	interrupted map[string]bool
+	wrongETag   map[string]bool
+}
+
+func (s *fakeAssetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	content, ok := s.assets[name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	md5Sum := md5.Sum([]byte(content))
+	etag := hex.EncodeToString(md5Sum[:])
+	if s.wrongETag[name] {
+		etag = strings.Repeat("0", 32)
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, etag))
+
+	if s.interrupted[name] {
+		s.interrupted[name] = false
+
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			panic(err)
+		}
+		_, _ = fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\nETag: \"%s\"\r\n\r\n%s", len(content), etag, content[:len(content)/2])
+		_ = buf.Flush()
+		_ = conn.Close()
+		return
+	}
+
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		start, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rangeHeader, "bytes="), "-"))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write([]byte(content[start:]))
+		return
+	}
+
+	_, _ = w.Write([]byte(content))
+}
+
+func Test_assetDownloader_downloadAll(t *testing.T) {
+	// Given
+	server := &fakeAssetServer{
+		assets: map[string]string{
+			"iphone8-16.6-en-portrait-test_results_merged.xml":     strings.Repeat("<testsuite/>", 100),
+			"iphone8-16.6-en-portrait_video.mp4":                   strings.Repeat("video", 1000),
+			"iphone13pro-16.6-en-portrait-test_results_merged.xml": strings.Repeat("<testsuite/>", 50),
+		},
+		interrupted: map[string]bool{"iphone8-16.6-en-portrait_video.mp4": true},
+		wrongETag:   map[string]bool{"iphone13pro-16.6-en-portrait-test_results_merged.xml": true},
+	}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	assets := map[string]string{}
+	for name := range server.assets {
+		assets[name] = httpServer.URL + "/" + name
+	}
+	assets["iphone8-16.6-en-portrait_syslog.txt"] = httpServer.URL + "/missing"
+
+	downloader := assetDownloader{
+		client:      httpServer.Client(),
+		concurrency: 2,
+		maxRetries:  2,
+		retryWait:   time.Millisecond,
+	}
+	dir := t.TempDir()
+
+	// When
+	downloaded, failures := downloader.downloadAll(assets, dir)
+
+	// Then
+	require.Equal(t, map[string]string{
+		"iphone8-16.6-en-portrait-test_results_merged.xml": filepath.Join(dir, "iphone8-16.6-en-portrait-test_results_merged.xml"),
+		"iphone8-16.6-en-portrait_video.mp4":               filepath.Join(dir, "iphone8-16.6-en-portrait_video.mp4"),
+	}, downloaded)
+
+	for name, pth := range downloaded {
+		content, err := os.ReadFile(pth)
+		require.NoError(t, err)
+		require.Equal(t, server.assets[name], string(content))
+	}
+
+	require.Len(t, failures, 2)
+	require.Equal(t, "iphone13pro-16.6-en-portrait-test_results_merged.xml", failures[0].fileName)
+	require.ErrorContains(t, failures[0].err, "md5 mismatch")
+	require.Equal(t, "iphone8-16.6-en-portrait_syslog.txt", failures[1].fileName)
+	require.ErrorContains(t, failures[1].err, "non success response code: 404")
+}
+
+func Test_expectedMD5FromHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{
+			name:   "GCS md5 hash",
+			header: http.Header{"X-Goog-Hash": []string{"crc32c=n03x6A==,md5=Ojk9c3dhfxgoKVVHYwFbHQ=="}},
+			want:   "3a393d7377617f182829554763015b1d",
+		},
+		{
+			name:   "MD5 ETag",
+			header: http.Header{"Etag": []string{`"3A393D7377617F182829554763015B1D"`}},
+			want:   "3a393d7377617f182829554763015b1d",
+		},
+		{
+			name:   "Multipart ETag",
+			header: http.Header{"Etag": []string{`"3a393d7377617f182829554763015b1d-2"`}},
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, expectedMD5FromHeaders(tt.header))
+		})
+	}
+}
+
+func Test_localAssetPath(t *testing.T) {
+	suffix := strings.Repeat("a", 250) + "_video.mp4"
+	pth1 := localAssetPath("dir", "iphone8-16.6-en-portrait-"+suffix)
+	pth2 := localAssetPath("dir", "iphone13pro-16.6-en-portrait-"+suffix)
+
+	require.NotEqual(t, pth1, pth2)
+	require.Len(t, filepath.Base(pth1), maxFileNameLength)
+	require.True(t, strings.HasSuffix(pth1, "_video.mp4"))
+	require.Equal(t, filepath.Join("dir", "short.xml"), localAssetPath("dir", "short.xml"))
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"debug/macho"
+	"fmt"
+	"strings"
+)
+
+const (
+	loadCmdDyldInfo         macho.LoadCmd = 0x22
+	loadCmdDyldInfoOnly     macho.LoadCmd = 0x80000022
+	loadCmdDyldChainedFixup macho.LoadCmd = 0x80000034
+)
+
+/*
+readImports reads the symbols the binary binds from other images (_OBJC_CLASS_$_XCTestCase): the chained fixups' imports
+by ordinal (LC_DYLD_CHAINED_FIXUPS), or the bound addresses of the dyld info's bind opcodes (LC_DYLD_INFO).
+*/
+func (m *machoImage) readImports() error {
+	for _, load := range m.file.Loads {
+		raw := load.Raw()
+		if len(raw) < 16 {
+			continue
+		}
+
+		switch macho.LoadCmd(m.file.ByteOrder.Uint32(raw)) {
+		case loadCmdDyldChainedFixup:
+			data, err := m.readFile(m.file.ByteOrder.Uint32(raw[8:]), m.file.ByteOrder.Uint32(raw[12:]))
+			if err != nil {
+				return fmt.Errorf("failed to read chained fixups: %w", err)
+			}
+			if m.chainedImports, err = m.parseChainedImports(data); err != nil {
+				return fmt.Errorf("failed to read chained fixups: %w", err)
+			}
+		case loadCmdDyldInfo, loadCmdDyldInfoOnly:
+			if len(raw) < 24 {
+				return fmt.Errorf("invalid dyld info load command")
+			}
+			data, err := m.readFile(m.file.ByteOrder.Uint32(raw[16:]), m.file.ByteOrder.Uint32(raw[20:]))
+			if err != nil {
+				return fmt.Errorf("failed to read bind info: %w", err)
+			}
+			if m.binds, err = parseBindOpcodes(data, m.segments()); err != nil {
+				return fmt.Errorf("failed to read bind info: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// boundSymbol returns the symbol bound to the pointer at addr, false if the pointer is not bound to another image.
+func (m machoImage) boundSymbol(addr uint64) (string, bool, error) {
+	if symbol, ok := m.binds[addr]; ok {
+		return symbol, true, nil
+	}
+
+	data, err := m.read(addr, 8)
+	if err != nil {
+		return "", false, err
+	}
+	pointer := m.file.ByteOrder.Uint64(data)
+	if pointer>>63 == 0 {
+		return "", false, nil
+	}
+
+	// A chained bind (DYLD_CHAINED_PTR_64) has the import ordinal in its low 24 bits
+	ordinal := pointer & (1<<24 - 1)
+	if ordinal >= uint64(len(m.chainedImports)) {
+		return "", false, nil
+	}
+	return m.chainedImports[ordinal], true, nil
+}
+
+// segments returns the segments in the order of their load commands, bind opcodes refer to them by index.
+func (m machoImage) segments() []*macho.Segment {
+	var segments []*macho.Segment
+	for _, load := range m.file.Loads {
+		if segment, ok := load.(*macho.Segment); ok {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+// readFile reads the file content at offset, from the segment containing it (__LINKEDIT).
+func (m machoImage) readFile(offset, size uint32) ([]byte, error) {
+	for _, segment := range m.segments() {
+		if uint64(offset) < segment.Offset || uint64(offset)+uint64(size) > segment.Offset+segment.Filesz {
+			continue
+		}
+
+		data := make([]byte, size)
+		if _, err := segment.ReadAt(data, int64(uint64(offset)-segment.Offset)); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("file offset 0x%x is not in any segment", offset)
+}
+
+/*
+parseChainedImports returns the import names of the chained fixups (dyld_chained_fixups_header): the imports are
+DYLD_CHAINED_IMPORT (format 1), DYLD_CHAINED_IMPORT_ADDEND (2) or DYLD_CHAINED_IMPORT_ADDEND64 (3) entries,
+with the offset of their name in the uncompressed symbol pool.
+*/
+func (m machoImage) parseChainedImports(data []byte) ([]string, error) {
+	if len(data) < 28 {
+		return nil, fmt.Errorf("invalid chained fixups header")
+	}
+
+	byteOrder := m.file.ByteOrder
+	importsOffset := uint64(byteOrder.Uint32(data[8:]))
+	symbolsOffset := uint64(byteOrder.Uint32(data[12:]))
+	importsCount := uint64(byteOrder.Uint32(data[16:]))
+	importsFormat := byteOrder.Uint32(data[20:])
+	if symbolsFormat := byteOrder.Uint32(data[24:]); symbolsFormat != 0 {
+		return nil, fmt.Errorf("unsupported symbols format: %d", symbolsFormat)
+	}
+
+	var importSize uint64
+	switch importsFormat {
+	case 1:
+		importSize = 4
+	case 2:
+		importSize = 8
+	case 3:
+		importSize = 16
+	default:
+		return nil, fmt.Errorf("unsupported imports format: %d", importsFormat)
+	}
+	if importsOffset+importsCount*importSize > uint64(len(data)) {
+		return nil, fmt.Errorf("imports out of range")
+	}
+
+	var names []string
+	for i := uint64(0); i < importsCount; i++ {
+		entry := data[importsOffset+i*importSize:]
+
+		nameOffset := uint64(byteOrder.Uint32(entry) >> 9)
+		if importsFormat == 3 {
+			nameOffset = byteOrder.Uint64(entry) >> 32
+		}
+
+		start := symbolsOffset + nameOffset
+		if start >= uint64(len(data)) {
+			return nil, fmt.Errorf("import name out of range")
+		}
+		end := strings.IndexByte(string(data[start:]), 0)
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated import name")
+		}
+		names = append(names, string(data[start:start+uint64(end)]))
+	}
+
+	return names, nil
+}
+
+/*
+parseBindOpcodes returns the bound symbols by address, running the dyld info's bind opcodes (BIND_OPCODE_*):
+the opcodes set the symbol and the address (a segment's index and an offset), and bind the symbol to one or more pointers.
+*/
+func parseBindOpcodes(data []byte, segments []*macho.Segment) (map[uint64]string, error) {
+	binds := map[uint64]string{}
+	var symbol string
+	var addr uint64
+
+	pos := 0
+	uleb := func() uint64 {
+		var value uint64
+		for shift := 0; pos < len(data); shift += 7 {
+			b := data[pos]
+			pos++
+			value |= uint64(b&0x7f) << shift
+			if b&0x80 == 0 {
+				break
+			}
+		}
+		return value
+	}
+	bind := func() {
+		binds[addr] = symbol
+		addr += 8
+	}
+
+	for pos < len(data) {
+		opcode, immediate := data[pos]&0xf0, data[pos]&0x0f
+		pos++
+
+		switch opcode {
+		case 0x00: // DONE
+			return binds, nil
+		case 0x10, 0x30, 0x50: // SET_DYLIB_ORDINAL_IMM, SET_DYLIB_SPECIAL_IMM, SET_TYPE_IMM
+		case 0x20, 0x60: // SET_DYLIB_ORDINAL_ULEB, SET_ADDEND_SLEB
+			uleb()
+		case 0x40: // SET_SYMBOL_TRAILING_FLAGS_IMM
+			end := strings.IndexByte(string(data[pos:]), 0)
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated symbol name")
+			}
+			symbol = string(data[pos : pos+end])
+			pos += end + 1
+		case 0x70: // SET_SEGMENT_AND_OFFSET_ULEB
+			if int(immediate) >= len(segments) {
+				return nil, fmt.Errorf("invalid segment index: %d", immediate)
+			}
+			addr = segments[immediate].Addr + uleb()
+		case 0x80: // ADD_ADDR_ULEB
+			addr += uleb()
+		case 0x90: // DO_BIND
+			bind()
+		case 0xa0: // DO_BIND_ADD_ADDR_ULEB
+			bind()
+			addr += uleb()
+		case 0xb0: // DO_BIND_ADD_ADDR_IMM_SCALED
+			bind()
+			addr += uint64(immediate) * 8
+		case 0xc0: // DO_BIND_ULEB_TIMES_SKIPPING_ULEB
+			count, skip := uleb(), uleb()
+			for i := uint64(0); i < count; i++ {
+				bind()
+				addr += skip
+			}
+		default:
+			return nil, fmt.Errorf("unsupported bind opcode: 0x%x", opcode)
+		}
+	}
+	return binds, nil
+}
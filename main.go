@@ -1,24 +1,18 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
+	"sort"
 	"strings"
-	"text/tabwriter"
 	"time"
 
-	"google.golang.org/api/testing/v1"
 	toolresults "google.golang.org/api/toolresults/v1beta3"
 
 	"github.com/bitrise-io/go-steputils/v2/stepconf"
-	"github.com/bitrise-io/go-utils/colorstring"
+	"github.com/bitrise-io/go-steputils/v2/testquarantine"
 	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-io/go-utils/pathutil"
 	"github.com/bitrise-io/go-utils/sliceutil"
@@ -36,12 +30,23 @@ type ConfigsModel struct {
 	AppSlug    string          `env:"BITRISE_APP_SLUG,required"`
 
 	// shared
-	ZipPath              string  `env:"zip_path,file"`
+	ZipPath              string  `env:"zip_path"`
+	TestProductsDir      string  `env:"test_products_dir"`
+	AppPath              string  `env:"app_path"`
+	TestBundlePaths      string  `env:"test_bundle_paths"`
 	TestDevices          string  `env:"test_devices,required"`
 	TestTimeout          float64 `env:"test_timeout,range[0..2700]"`
 	DownloadTestResults  bool    `env:"download_test_results,opt[false,true]"`
+	DownloadIncludes     string  `env:"download_include_patterns"`
+	DownloadExcludes     string  `env:"download_exclude_patterns"`
+	DownloadHeavyAssets  string  `env:"download_heavy_assets_for,opt[all_devices,unsuccessful_devices]"`
 	NumFlakyTestAttempts int     `env:"num_flaky_test_attempts,range[0..10]"`
 	QuarantinedTests     string  `env:"quarantined_tests"`
+	MinTestCount         int     `env:"min_test_count,range[0..]"`
+	MissingTestsAction   string  `env:"missing_tests_action,opt[warn,fail]"`
+	SuccessPolicy        string  `env:"success_policy"`
+	QueueTimeThreshold   int     `env:"queue_time_threshold,range[0..]"`
+	QueueTimeAction      string  `env:"queue_time_action,opt[warn]"`
 }
 
 // UploadURLRequest ...
@@ -68,333 +73,178 @@ func main() {
 
 	stepconf.Print(configs)
 
-	// add quarantined tests to xctestrun
-	if configs.QuarantinedTests != "" {
-		fmt.Println()
-		log.TInfof("Adding quarantined tests to xctestrun")
-
-		quarantinedTestsList, err := parseQuarantinedTests(configs.QuarantinedTests)
-		if err != nil {
-			failf("Failed to parse quarantined tests: %s", err)
-		}
-
-		if len(quarantinedTestsList) == 0 {
-			log.TPrintf("No quarantined tests found")
-		} else {
-			log.TPrintf("%d quarantined tests found", len(quarantinedTestsList))
-
-			updatedTestBundleZipPth, err := addQuarantinedTestsToTestBundle(configs.ZipPath, quarantinedTestsList)
-			if err != nil {
-				failf("Failed to add quarantined tests to xctestrun: %s", err)
-			}
-
-			configs.ZipPath = updatedTestBundleZipPth
-			log.TDonef("=> Quarantined tests added to xctestrun")
-		}
+	policy, err := parseSuccessPolicy(configs.SuccessPolicy)
+	if err != nil {
+		failf("Process config: invalid success policy: %s", err)
 	}
 
-	fmt.Println()
-	log.TInfof("Upload IPAs")
-	{
-		url := configs.APIBaseURL + "/assets/" + configs.AppSlug + "/" + configs.BuildSlug + "/" + string(configs.APIToken)
-
-		req, err := http.NewRequest("POST", url, nil)
-		if err != nil {
-			failf("Failed to create http request, error: %s", err)
-		}
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			failf("Failed to get http response, error: %s", err)
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				failf("Failed to read response body, error: %s", err)
-			}
-			failf("Failed to start test: %d, error: %s", resp.StatusCode, string(body))
-		}
+	if configs.MinTestCount > 0 && !configs.DownloadTestResults {
+		log.Warnf("The min_test_count input is used only if download_test_results is set to true")
+	}
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			failf("Failed to read response body, error: %s", err)
-		}
+	downloadFilter, err := newAssetFilter(configs.DownloadIncludes, configs.DownloadExcludes, configs.DownloadHeavyAssets)
+	if err != nil {
+		failf("Process config: invalid download filters: %s", err)
+	}
 
-		responseModel := &UploadURLRequest{}
+	if configs.TestProductsDir != "" || configs.AppPath != "" {
+		fmt.Println()
+		log.TInfof("Creating test bundle zip")
+	}
 
-		if err := json.Unmarshal(body, responseModel); err != nil {
-			failf("Failed to unmarshal response body, error: %s", err)
-		}
+	testBundleZipPth, err := prepareTestBundleZip(configs.ZipPath, configs.TestProductsDir, configs.AppPath, configs.TestBundlePaths)
+	if err != nil {
+		failf("Failed to prepare test bundle: %s", err)
+	}
 
-		if err := uploadFile(responseModel.AppURL, configs.ZipPath); err != nil {
-			failf("Failed to upload file(%s) to (%s), error: %s", configs.ZipPath, responseModel.AppURL, err)
-		}
+	if testBundleZipPth != configs.ZipPath {
+		configs.ZipPath = testBundleZipPth
+		log.TDonef("=> Test bundle zip created: %s", testBundleZipPth)
+	}
 
-		log.TDonef("=> .xctestrun uploaded")
+	testDevices, err := parseTestDevices(configs.TestDevices)
+	if err != nil {
+		failf("Process config: %s", err)
 	}
 
 	fmt.Println()
-	log.TInfof("Start test")
-	{
-		url := configs.APIBaseURL + "/" + configs.AppSlug + "/" + configs.BuildSlug + "/" + string(configs.APIToken)
-
-		testModel := &testing.TestMatrix{}
-		testModel.EnvironmentMatrix = &testing.EnvironmentMatrix{IosDeviceList: &testing.IosDeviceList{}}
-		testModel.EnvironmentMatrix.IosDeviceList.IosDevices = []*testing.IosDevice{}
-		testModel.FlakyTestAttempts = int64(configs.NumFlakyTestAttempts)
-
-		scanner := bufio.NewScanner(strings.NewReader(configs.TestDevices))
-		for scanner.Scan() {
-			device := scanner.Text()
-			device = strings.TrimSpace(device)
-			if device == "" {
-				continue
-			}
-
-			deviceParams := strings.Split(device, ",")
-			if len(deviceParams) != 4 {
-				failf("Invalid test device configuration: %s", device)
-			}
-
-			newDevice := testing.IosDevice{
-				IosModelId:   deviceParams[0],
-				IosVersionId: deviceParams[1],
-				Locale:       deviceParams[2],
-				Orientation:  deviceParams[3],
-			}
-
-			testModel.EnvironmentMatrix.IosDeviceList.IosDevices = append(testModel.EnvironmentMatrix.IosDeviceList.IosDevices, &newDevice)
-		}
-
-		testModel.TestSpecification = &testing.TestSpecification{
-			TestTimeout: fmt.Sprintf("%fs", configs.TestTimeout),
+	log.TInfof("Reading test inventory")
+
+	inventory, inventoryErr := readTestInventory(configs.ZipPath)
+	if inventoryErr != nil {
+		log.TWarnf("Failed to read test inventory: %s", inventoryErr)
+	} else {
+		log.TDonef("=> %d test(s) found in %d test target(s)", inventory.testCount(), len(inventory.targets()))
+
+		if inventoryDir, err := pathutil.NormalizedOSTempDirPath("vdtesting_test_inventory"); err != nil {
+			log.TWarnf("Failed to create test inventory dir: %s", err)
+		} else if err := outputExporter.ExportTestInventory(inventory.export(), inventoryDir); err != nil {
+			log.TWarnf("Failed to export test inventory: %s", err)
 		}
+	}
 
-		testModel.TestSpecification.IosXcTest = &testing.IosXcTest{}
+	matrix := testMatrix{zipPath: configs.ZipPath, devices: testDevices}
+	var appliedQuarantineEntries []quarantineEntry
 
-		jsonByte, err := json.Marshal(testModel)
-		if err != nil {
-			failf("Failed to marshal test model, error: %s", err)
-		}
-
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonByte))
-		if err != nil {
-			failf("Failed to create http request, error: %s", err)
-		}
+	// add quarantined tests to xctestrun
+	if configs.QuarantinedTests != "" {
+		fmt.Println()
+		log.TInfof("Adding quarantined tests to xctestrun")
 
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		quarantineEntries, ignoredQuarantineEntries, err := parseQuarantinedTests(configs.QuarantinedTests, time.Now())
 		if err != nil {
-			failf("Failed to get http response, error: %s", err)
+			failf("Failed to parse quarantined tests: %s", err)
 		}
 
-		if resp.StatusCode != http.StatusOK {
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				failf("Failed to read response body, error: %s", err)
+		if needsTestInventory(quarantineEntries) {
+			if inventoryErr != nil {
+				log.TWarnf("Without the test inventory quarantined test targets and patterns are not applied, quarantined testMethod() tests are handled as XCTest tests")
 			}
-			failf("Failed to start test: %d, error: %s", resp.StatusCode, string(body))
-		}
-
-		log.TDonef("=> Test started")
-	}
-
-	fmt.Println()
-	log.TInfof("Waiting for test results")
 
-	dimensionToStatus := map[string]bool{}
-	{
-		finished := false
-		printedLogs := []string{}
-
-		stepIDToStepStates := map[string]stepStates{}
-
-		for !finished {
-			url := configs.APIBaseURL + "/" + configs.AppSlug + "/" + configs.BuildSlug + "/" + string(configs.APIToken)
-
-			req, err := http.NewRequest("GET", url, nil)
-			if err != nil {
-				failf("Failed to create http request, error: %s", err)
-			}
+			quarantineEntries = expandQuarantineEntries(quarantineEntries, inventory)
+			for _, entry := range quarantineEntries {
+				if entry.testClass != "" && !containsGlobPattern(entry.testTarget, entry.testClass, entry.testMethod) {
+					continue
+				}
 
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil || (resp != nil && resp.StatusCode != http.StatusOK) {
-				resp, err = client.Do(req)
-				if err != nil {
-					failf("Failed to get http response, error: %s", err)
+				var skipTestIdentifiers []string
+				for _, target := range inventory.targets() {
+					for _, skipTestIdentifier := range entry.skipTestIdentifiers[target] {
+						skipTestIdentifiers = append(skipTestIdentifiers, target+"/"+skipTestIdentifier)
+					}
 				}
-			}
 
-			if resp == nil || resp.Body == nil {
-				failf("Failed to get http response, response body is nil")
+				log.TPrintf("%s expanded to %d SkipTestIdentifier(s):", entry.pattern(), len(skipTestIdentifiers))
+				for _, skipTestIdentifier := range skipTestIdentifiers {
+					log.Printf("- %s", skipTestIdentifier)
+				}
 			}
+		}
 
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				failf("Failed to read response body, error: %s", err)
-			}
+		var blueprintNames []string
+		if len(quarantineEntries) == 0 {
+			log.TPrintf("No quarantined tests found")
+		} else {
+			log.TPrintf("%d quarantined tests found", len(quarantineEntries))
 
-			if resp.StatusCode != http.StatusOK {
-				failf("Failed to get test status, error: %s", string(body))
-			}
+			appliedQuarantineEntries = applyingQuarantineEntries(quarantineEntries, testDevices)
+			if len(appliedQuarantineEntries) > 0 {
+				updatedTestBundleZipPth, names, err := addQuarantinedTestsToTestBundle(configs.ZipPath, skippedTestsByTarget(appliedQuarantineEntries))
+				if err != nil {
+					failf("Failed to add quarantined tests to xctestrun: %s", err)
+				}
 
-			responseModel := &toolresults.ListStepsResponse{}
+				matrix.zipPath = updatedTestBundleZipPth
+				blueprintNames = names
 
-			if err := json.Unmarshal(body, responseModel); err != nil {
-				failf("Failed to unmarshal response body, error: %s, body: %s", err, string(body))
+				log.TDonef("=> Quarantined tests added to xctestrun")
 			}
+		}
 
-			updateStepsStates(stepIDToStepStates, *responseModel)
-
-			finished = true
-			testsRunning := 0
-			for _, step := range responseModel.Steps {
-				if step.State != "complete" {
-					finished = false
-					testsRunning++
-				}
+		quarantineReport := createQuarantineReport(quarantineEntries, ignoredQuarantineEntries, blueprintNames, testDevices)
+		log.TPrintf("%d quarantined test(s) applied, %d stale, %d dropped, %d expired", quarantineReport.Applied, quarantineReport.Stale, quarantineReport.Dropped, quarantineReport.Expired)
+		for _, entry := range quarantineReport.Entries {
+			if entry.Status != output.QuarantineEntryApplied {
+				log.TWarnf("- %s quarantined test %s/%s/%s: %s", entry.Status, strings.Join(entry.TestSuiteName, ","), entry.ClassName, entry.TestCaseName, entry.Reason)
 			}
+		}
 
-			msg := ""
-			if len(responseModel.Steps) == 0 {
-				finished = false
-				msg = "- Validating"
-			} else {
-				msg = fmt.Sprintf("- (%d/%d) running", testsRunning, len(responseModel.Steps))
-			}
+		if quarantineReportDir, err := pathutil.NormalizedOSTempDirPath("vdtesting_quarantine"); err != nil {
+			log.TWarnf("Failed to create quarantine report dir: %s", err)
+		} else if err := outputExporter.ExportQuarantineReport(quarantineReport, quarantineReportDir); err != nil {
+			log.TWarnf("Failed to export quarantine report: %s", err)
+		}
+	}
 
-			if !sliceutil.IsStringInSlice(msg, printedLogs) {
-				log.Printf(msg)
-				printedLogs = append(printedLogs, msg)
-			}
+	client := newAPIClient(configs)
 
-			if finished {
-				log.TDonef("=> Test finished")
-				fmt.Println()
+	submitTestMatrix(client, matrix, configs.TestTimeout, configs.NumFlakyTestAttempts)
 
-				printStepsStates(stepIDToStepStates, time.Now(), os.Stdout)
-				fmt.Println()
+	fmt.Println()
+	log.TInfof("Waiting for test results")
 
-				log.TInfof("Test results:")
-				w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
-				if _, err := fmt.Fprintln(w, "Model\tOS version\tOrientation\tLocale\tOutcome\t"); err != nil {
-					failf("Failed to write in writer")
-				}
+	watcher := newQueueTimeWatcher(time.Duration(configs.QueueTimeThreshold) * time.Second)
+	progress := newProgressReporter(os.Stdout, isTerminal(os.Stdout), time.Now())
+	steps, stepIDToStepStates := waitForTestResults(client, watcher, progress)
 
-				for _, step := range responseModel.Steps {
-					dimensions := createDimensions(*step)
-					outcome := step.Outcome.Summary
+	log.TDonef("=> Test finished")
+	fmt.Println()
 
-					dimensionID := fmt.Sprintf("%s.%s.%s.%s", dimensions["Model"], dimensions["Version"], dimensions["Orientation"], dimensions["Locale"])
-					isSuccess := true
-					if outcome == "failure" || outcome == "inconclusive" || outcome == "skipped" {
-						isSuccess = false
-					}
+	finishedTime := time.Now()
+	printStepsStates(stepIDToStepStates, finishedTime, os.Stdout)
+	fmt.Println()
 
-					_, exists := dimensionToStatus[dimensionID]
-					if exists {
-						if isSuccess {
-							// Mark the dimension as successful if at least one step (test run) was successful.
-							dimensionToStatus[dimensionID] = true
-						}
-					} else {
-						dimensionToStatus[dimensionID] = isSuccess
-					}
+	log.TInfof("Queue time per device:")
+	if err := printQueueTimes(collectDeviceResults(steps), stepIDToStepStates, finishedTime, os.Stdout); err != nil {
+		log.TWarnf("Failed to print queue times: %s", err)
+	}
+	fmt.Println()
 
-					switch outcome {
-					case "success":
-						outcome = colorstring.Green(outcome)
-					case "failure":
-						if step.Outcome.FailureDetail != nil {
-							if step.Outcome.FailureDetail.Crashed {
-								outcome += "(Crashed)"
-							}
-							if step.Outcome.FailureDetail.NotInstalled {
-								outcome += "(NotInstalled)"
-							}
-							if step.Outcome.FailureDetail.OtherNativeCrash {
-								outcome += "(OtherNativeCrash)"
-							}
-							if step.Outcome.FailureDetail.TimedOut {
-								outcome += "(TimedOut)"
-							}
-							if step.Outcome.FailureDetail.UnableToCrawl {
-								outcome += "(UnableToCrawl)"
-							}
-						}
-						outcome = colorstring.Red(outcome)
-					case "inconclusive":
-						if step.Outcome.InconclusiveDetail != nil {
-							if step.Outcome.InconclusiveDetail.AbortedByUser {
-								outcome += "(AbortedByUser)"
-							}
-							if step.Outcome.InconclusiveDetail.InfrastructureFailure {
-								outcome += "(InfrastructureFailure)"
-							}
-						}
-						outcome = colorstring.Yellow(outcome)
-					case "skipped":
-						if step.Outcome.SkippedDetail != nil {
-							if step.Outcome.SkippedDetail.IncompatibleAppVersion {
-								outcome += "(IncompatibleAppVersion)"
-							}
-							if step.Outcome.SkippedDetail.IncompatibleArchitecture {
-								outcome += "(IncompatibleArchitecture)"
-							}
-							if step.Outcome.SkippedDetail.IncompatibleDevice {
-								outcome += "(IncompatibleDevice)"
-							}
-						}
-						outcome = colorstring.Blue(outcome)
-					}
+	log.TInfof("Test results:")
+	if err := printTestResults(steps, os.Stdout); err != nil {
+		failf("Failed to print test results: %s", err)
+	}
 
-					if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", dimensions["Model"], dimensions["Version"], dimensions["Orientation"], dimensions["Locale"], outcome); err != nil {
-						failf("Failed to write in writer")
-					}
-				}
-				if err := w.Flush(); err != nil {
-					log.Errorf("Failed to flush writer, error: %s", err)
-				}
-			}
-			if !finished {
-				time.Sleep(10 * time.Second)
-			}
-		}
+	deviceResults := collectDeviceResults(steps)
+	dimensionToStatus := map[string]bool{}
+	for _, deviceResult := range deviceResults {
+		dimensionToStatus[deviceResult.dimensionID] = deviceResult.isSuccess()
 	}
 
+	var downloadedPths map[string]string
+	var incompleteDevices []string
 	if configs.DownloadTestResults {
 		fmt.Println()
 		log.TInfof("Downloading test assets")
 		{
-			url := configs.APIBaseURL + "/assets/" + configs.AppSlug + "/" + configs.BuildSlug + "/" + string(configs.APIToken)
-
-			req, err := http.NewRequest("GET", url, nil)
+			responseModel, err := client.getAssets()
 			if err != nil {
-				failf("Failed to create http request, error: %s", err)
+				failf("Failed to get test assets: %s", err)
 			}
 
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				failf("Failed to get http response, error: %s", err)
-			}
-
-			if resp.StatusCode != http.StatusOK {
-				failf("Failed to get http response, status code: %d", resp.StatusCode)
-			}
-
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				failf("Failed to read response body, error: %s", err)
-			}
-
-			responseModel := map[string]string{}
-
-			if err := json.Unmarshal(body, &responseModel); err != nil {
-				failf("Failed to unmarshal response body, error: %s", err)
+			assetsToDownload := downloadFilter.filter(responseModel, dimensionToStatus)
+			if skipped := len(responseModel) - len(assetsToDownload); skipped > 0 {
+				log.TPrintf("%d of %d test asset(s) skipped by the download filters", skipped, len(responseModel))
 			}
 
 			tempDir, err := pathutil.NormalizedOSTempDirPath("vdtesting_test_assets")
@@ -402,13 +252,11 @@ func main() {
 				failf("Failed to create temp dir, error: %s", err)
 			}
 
-			var mergedTestResultXmlPths []string
-			for fileName, fileURL := range responseModel {
-				pth := filepath.Join(tempDir, fileName)
-				if err := downloadFile(fileURL, pth); err != nil {
-					failf("Failed to download file, error: %s", err)
-				}
+			var downloadFailures []assetDownloadError
+			downloadedPths, downloadFailures = newAssetDownloader().downloadAll(assetsToDownload, tempDir)
 
+			var mergedTestResultXmlPths []string
+			for fileName, pth := range downloadedPths {
 				// per test run results: iphone13pro-16.6-en-portrait_test_result_0.xml
 				// rerun test results: iphone8-16.6-en-portrait-rerun_1_test_result_0.xml
 				// merged result: iphone13pro-16.6-en-portrait-test_results_merged.xml
@@ -416,9 +264,26 @@ func main() {
 					mergedTestResultXmlPths = append(mergedTestResultXmlPths, pth)
 				}
 			}
+			sort.Strings(mergedTestResultXmlPths)
 
 			log.TPrintf("%d merged test results XML(s) found", len(mergedTestResultXmlPths))
-			log.TDonef("=> %d test Assets downloaded", len(responseModel))
+			log.TDonef("=> %d test Assets downloaded", len(downloadedPths))
+
+			if len(downloadFailures) > 0 {
+				log.TErrorf("Failed to download %d test asset(s):", len(downloadFailures))
+				for _, failure := range downloadFailures {
+					log.Errorf("- %s", failure)
+				}
+			}
+
+			manifest, err := createAssetManifest(downloadedPths, downloadFailures)
+			if err != nil {
+				log.TWarnf("Failed to create test asset manifest: %s", err)
+			} else if manifestPth, err := writeAssetManifest(tempDir, manifest); err != nil {
+				log.TWarnf("Failed to write test asset manifest: %s", err)
+			} else {
+				log.TPrintf("Test asset manifest written to %s", manifestPth)
+			}
 
 			if err := outputExporter.ExportTestResultsDir(tempDir); err != nil {
 				log.TWarnf("Failed to export test assets: %s", err)
@@ -426,114 +291,203 @@ func main() {
 				if err := outputExporter.ExportFlakyTestsEnvVar(mergedTestResultXmlPths); err != nil {
 					log.TWarnf("Failed to export flaky tests env var: %s", err)
 				}
+
+				devices := deviceTestResults(downloadedPths)
+				if err := outputExporter.ExportFlakyTestsFiles(devices, tempDir); err != nil {
+					log.TWarnf("Failed to export flaky tests files: %s", err)
+				}
+				if err := outputExporter.ExportFailedTests(devices, tempDir); err != nil {
+					log.TWarnf("Failed to export failed tests: %s", err)
+				}
+
+				if quarantinedTests, err := testquarantine.ParseQuarantinedTests(configs.QuarantinedTests); err != nil {
+					log.TWarnf("Failed to parse quarantined tests: %s", err)
+				} else if err := outputExporter.ExportQuarantineSuggestions(devices, quarantinedTests, tempDir); err != nil {
+					log.TWarnf("Failed to export quarantine suggestions: %s", err)
+				}
+
+				reportDir, err := createTestReportDir(envRepository.Get("BITRISE_TEST_RESULT_DIR"), tempDir)
+				if err != nil {
+					log.TWarnf("Failed to create test report dir: %s", err)
+				} else if err := outputExporter.ExportConsolidatedTestReport(devices, reportDir); err != nil {
+					log.TWarnf("Failed to export consolidated test report: %s", err)
+				}
+
+				expectation := createTestExpectation(inventory, appliedQuarantineEntries, testDevices, configs.MinTestCount)
+				if len(expectation.Tests) > 0 || expectation.MinTestCount > 0 {
+					if missingTestsReport, err := outputExporter.ExportMissingTests(devices, expectation, tempDir); err != nil {
+						log.TWarnf("Failed to export missing tests: %s", err)
+					} else {
+						incompleteDevices = missingTestsReport.IncompleteDevices()
+					}
+				}
 			}
 		}
 	}
 
-	var failedTestRuns []string
-	for dimension, isSuccess := range dimensionToStatus {
-		if !isSuccess {
-			failedTestRuns = append(failedTestRuns, dimension)
+	var incompleteDimensionIDs []string
+	if len(incompleteDevices) > 0 {
+		fmt.Println()
+		if configs.MissingTestsAction == missingTestsActionFail {
+			log.Errorf("Expected tests did not run on %d device(s): %s", len(incompleteDevices), strings.Join(incompleteDevices, ", "))
+			for _, deviceResult := range deviceResults {
+				if sliceutil.IsStringInSlice(deviceResult.assetName(), incompleteDevices) {
+					incompleteDimensionIDs = append(incompleteDimensionIDs, deviceResult.dimensionID)
+				}
+			}
+		} else {
+			log.Warnf("Expected tests did not run on %d device(s): %s", len(incompleteDevices), strings.Join(incompleteDevices, ", "))
 		}
 	}
 
-	if len(failedTestRuns) > 0 {
-		log.Errorf("%d test run(s) failed", len(failedTestRuns))
+	decision := policy.evaluate(deviceResults, incompleteDimensionIDs)
+
+	fmt.Println()
+	runResults := createRunResults(deviceResults, stepIDToStepStates, finishedTime, downloadedPths)
+	runResults.Success = decision.success
+	if runResultsDir, err := pathutil.NormalizedOSTempDirPath("vdtesting_results"); err != nil {
+		log.TWarnf("Failed to create run results dir: %s", err)
+	} else {
+		if err := outputExporter.ExportRunResults(runResults, runResultsDir); err != nil {
+			log.TWarnf("Failed to export run results: %s", err)
+		}
+		if err := outputExporter.ExportRunReport(runResults, runResultsDir); err != nil {
+			log.TWarnf("Failed to export run report: %s", err)
+		}
+	}
+
+	for _, device := range decision.devices {
+		if device.verdict != deviceVerdictPassed {
+			log.Printf("- %s: %s (%s)", device.dimensionID, device.verdict, device.reason)
+		}
+	}
+
+	if !decision.success {
+		log.Errorf("%s", decision.reason)
 		os.Exit(1)
 	}
 }
 
-func downloadFile(url string, localPath string) error {
-	// on HFS file system the max file name length: 255 UTF-16 encoding units
-	base := filepath.Base(localPath)
-	if len(base) > 255 {
-		log.Warnf("too long filename: %s", base)
-		base = base[len(base)-255:]
-		log.Warnf("trimming to: %s", base)
-		localPath = filepath.Join(filepath.Dir(localPath), base)
+// submitTestMatrix uploads the matrix's test bundle and starts the matrix.
+func submitTestMatrix(client apiClient, matrix testMatrix, testTimeout float64, flakyTestAttempts int) {
+	fmt.Println()
+	log.TInfof("Upload IPAs")
+	if err := uploadTestBundle(client, matrix); err != nil {
+		failf("Failed to upload test bundle: %s", err)
 	}
 
-	out, err := os.Create(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to open the local cache file for write: %s", err)
+	fmt.Println()
+	log.TInfof("Start test")
+	if err := client.startTest(matrix.testMatrixModel(testTimeout, flakyTestAttempts)); err != nil {
+		failf("Failed to start test: %s", err)
 	}
-	defer func() {
-		if err := out.Close(); err != nil {
-			log.Printf("Failed to close Archive download file (%s): %s", localPath, err)
+	log.TDonef("=> Test started")
+}
+
+/*
+waitForTestResults polls the test matrix until all of its steps are complete and returns the completed steps and
+their states. The steps pending longer than the watcher's threshold are warned about, the progress is reported on every poll.
+*/
+func waitForTestResults(client apiClient, watcher *queueTimeWatcher, progress *progressReporter) ([]*toolresults.Step, map[string]stepStates) {
+	printedValidating := false
+	stepIDToStepStates := map[string]stepStates{}
+	progress.keepTable()
+
+	for {
+		currentTime := time.Now()
+		responseModel, err := client.getSteps()
+		if err != nil {
+			progress.keepTable()
+			failf("Failed to get test status: %s", err)
 		}
-	}()
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("failed to create cache download request: %s", err)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			log.Printf("Failed to close Archive download response body: %s", err)
+		steps := responseModel.Steps
+		validating := len(steps) == 0
+
+		updateStepsStates(stepIDToStepStates, *responseModel, currentTime)
+		for _, step := range watcher.exceededSteps(steps, stepIDToStepStates, currentTime) {
+			progress.keepTable()
+			log.TWarnf("%s has been pending for more than %s, the queue time threshold", createStepNameWithDimensions(*step), watcher.threshold)
 		}
-	}()
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("failed to download archive - non success response code: %d", resp.StatusCode)
+		finished := !validating
+		for _, step := range steps {
+			if step.State != stepStateComplete {
+				finished = false
+			}
+		}
+
+		if validating && !printedValidating {
+			log.Printf("- Validating")
+			printedValidating = true
+		}
+		progress.update(steps, currentTime)
+
+		if finished {
+			return steps, stepIDToStepStates
+		}
+
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// uploadTestBundle uploads the matrix's test bundle to the build's upload URL.
+func uploadTestBundle(client apiClient, matrix testMatrix) error {
+	responseModel, err := client.getUploadURL()
+	if err != nil {
+		return fmt.Errorf("failed to get upload url: %w", err)
 	}
 
-	if _, err := io.Copy(out, resp.Body); err != nil {
-		return fmt.Errorf("failed to save cache content into file: %s", err)
+	if err := uploadFile(responseModel.AppURL, matrix.zipPath); err != nil {
+		return fmt.Errorf("failed to upload file(%s) to (%s), error: %w", matrix.zipPath, responseModel.AppURL, err)
 	}
 
+	log.TDonef("=> .xctestrun uploaded")
 	return nil
 }
 
-func uploadFile(uploadURL string, archiveFilePath string) error {
-	archFile, err := os.Open(archiveFilePath)
-	if err != nil {
-		return fmt.Errorf("failed to open archive file for upload (%s): %s", archiveFilePath, err)
+/*
+createTestReportDir returns the dir for the step's own test reports.
+
+In a Bitrise build, test reports are collected from $BITRISE_TEST_RESULT_DIR: each report has its own
+subdir with a test-info.json, and the Deploy to Bitrise.io Step uploads them to the Test Reports page.
+Outside of Bitrise the fallback dir is used.
+*/
+func createTestReportDir(testResultDir, fallbackDir string) (string, error) {
+	if testResultDir == "" {
+		return fallbackDir, nil
 	}
-	isFileCloseRequired := true
-	defer func() {
-		if !isFileCloseRequired {
-			return
-		}
-		if err := archFile.Close(); err != nil {
-			log.Printf(" (!) Failed to close archive file (%s): %s", archiveFilePath, err)
-		}
-	}()
 
-	fileInfo, err := archFile.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to get File Stats of the Archive file (%s): %s", archiveFilePath, err)
+	dir := filepath.Join(testResultDir, "vdtesting_consolidated")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
 	}
-	fileSize := fileInfo.Size()
 
-	req, err := http.NewRequest("PUT", uploadURL, archFile)
+	testInfo, err := json.Marshal(map[string]string{"test-name": "iOS Device Testing (all devices)"})
 	if err != nil {
-		return fmt.Errorf("failed to create upload request: %s", err)
+		return "", err
 	}
 
-	req.Header.Add("Content-Length", strconv.FormatInt(fileSize, 10))
-	req.ContentLength = fileSize
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to upload: %s", err)
+	if err := os.WriteFile(filepath.Join(dir, "test-info.json"), testInfo, 0644); err != nil {
+		return "", err
 	}
-	isFileCloseRequired = false
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			log.Printf(" [!] Failed to close response body: %s", err)
-		}
-	}()
 
-	_, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %s", err)
+	return dir, nil
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
 	}
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("failed to upload file, response code was: %d", resp.StatusCode)
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
 	}
 
-	return nil
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
 }
 
 func createDimensions(step toolresults.Step) map[string]string {
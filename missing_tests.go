@@ -0,0 +1,33 @@
+package main
+
+import (
+	"google.golang.org/api/testing/v1"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/output"
+)
+
+const (
+	missingTestsActionWarn = "warn"
+	missingTestsActionFail = "fail"
+)
+
+/*
+createTestExpectation returns the tests expected to run on each device: the inventory's tests without the quarantined
+tests. Without a test inventory only the minimum test count (and the devices' test results) is checked.
+*/
+func createTestExpectation(inventory testInventory, quarantineEntries []quarantineEntry, devices []*testing.IosDevice, minTestCount int) output.TestExpectation {
+	expectation := output.TestExpectation{Tests: map[string][]string{}, MinTestCount: minTestCount}
+	for _, device := range devices {
+		expectation.Devices = append(expectation.Devices, iosDeviceAssetName(device))
+	}
+
+	expectedTests := inventory.expectedTests(skippedTestsByTarget(quarantineEntries))
+	if len(expectedTests) == 0 {
+		return expectation
+	}
+
+	for _, deviceName := range expectation.Devices {
+		expectation.Tests[deviceName] = expectedTests
+	}
+	return expectation
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	testingapi "google.golang.org/api/testing/v1"
+)
+
+func Test_createTestExpectation(t *testing.T) {
+	iphone8 := &testingapi.IosDevice{IosModelId: "iphone8", IosVersionId: "15.7", Locale: "en", Orientation: "portrait"}
+	iphone13pro := &testingapi.IosDevice{IosModelId: "iphone13pro", IosVersionId: "16.6", Locale: "en", Orientation: "portrait"}
+
+	inventory := testInventory{
+		tests: map[string]map[string][]string{
+			"BullsEyeTests": {
+				"GameTests":   {"testScore", "testStart"},
+				"SlowTests":   {"testSlow"},
+				"RandomTests": {"testRandom"},
+			},
+			"BullsEyeUITests": {
+				"LaunchTests": {"testLaunch", "testLaunchPerformance"},
+			},
+		},
+		swiftTests: map[string]map[string][]string{
+			"BullsEyeTests": {
+				"ScoreTests":           {"scoreIsCalculated(points:)"},
+				"GameTests.RoundTests": {"roundStarts()", "roundEnds()"},
+				// Module level functions are not expected
+				"": {"launchSucceeds()"},
+			},
+		},
+		skippedTests: map[string][]string{"BullsEyeTests": {"SlowTests", "GameTests/RoundTests/roundEnds()"}},
+		onlyTests:    map[string][]string{"BullsEyeUITests": {"LaunchTests/testLaunch()"}},
+	}
+	devices := []*testingapi.IosDevice{iphone8, iphone13pro}
+	entries := []quarantineEntry{{skipTestIdentifiers: map[string][]string{"BullsEyeTests": {"RandomTests/testRandom", "GameTests"}}}}
+
+	expectation := createTestExpectation(inventory, entries, devices, 3)
+	require.Equal(t, 3, expectation.MinTestCount)
+	require.Equal(t, []string{"iphone8-15.7-en-portrait", "iphone13pro-16.6-en-portrait"}, expectation.Devices)
+	expectedTests := []string{
+		"BullsEyeTests/ScoreTests/scoreIsCalculated(points:)",
+		"BullsEyeUITests/LaunchTests/testLaunch",
+	}
+	require.Equal(t, map[string][]string{
+		"iphone8-15.7-en-portrait":     expectedTests,
+		"iphone13pro-16.6-en-portrait": expectedTests,
+	}, expectation.Tests)
+
+	require.Equal(t, []string{
+		"BullsEyeTests/GameTests/testScore",
+		"BullsEyeTests/GameTests/testStart",
+		"BullsEyeTests/RandomTests/testRandom",
+		"BullsEyeTests/GameTests.RoundTests/roundStarts()",
+		"BullsEyeTests/ScoreTests/scoreIsCalculated(points:)",
+		"BullsEyeUITests/LaunchTests/testLaunch",
+	}, createTestExpectation(inventory, nil, devices, 0).Tests["iphone8-15.7-en-portrait"])
+
+	withoutInventory := createTestExpectation(testInventory{}, entries, devices, 0)
+	require.Empty(t, withoutInventory.Tests)
+	require.Len(t, withoutInventory.Devices, 2)
+}
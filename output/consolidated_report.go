@@ -0,0 +1,106 @@
+package output
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	consolidatedTestReportPathEnvVarKey = "VDTESTING_CONSOLIDATED_TEST_REPORT_PATH"
+	consolidatedTestReportFileName      = "consolidated_test_results.xml"
+)
+
+// DeviceTestResult is a device dimension's test run with its merged test results XML.
+type DeviceTestResult struct {
+	// Name is the device dimension in the test assets' naming format: iphone8-16.6-en-portrait
+	Name        string
+	Model       string
+	OSVersion   string
+	Locale      string
+	Orientation string
+	// Attempts is the number of test runs on the device, including reruns.
+	Attempts                int
+	MergedTestResultXMLPath string
+	// TestResultXMLPaths are the test results XMLs of the attempts, in the order of the attempts.
+	TestResultXMLPaths []string
+}
+
+// ExportConsolidatedTestReport merges the devices' test results into a single JUnit report in dir,
+// with a test suite per device, and exports the report's path.
+func (e exporter) ExportConsolidatedTestReport(devices []DeviceTestResult, dir string) error {
+	report, err := e.createConsolidatedTestReport(devices)
+	if err != nil {
+		return err
+	}
+
+	content, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal consolidated test report: %w", err)
+	}
+
+	pth := filepath.Join(dir, consolidatedTestReportFileName)
+	if err := os.WriteFile(pth, append([]byte(xml.Header), content...), 0644); err != nil {
+		return fmt.Errorf("failed to write consolidated test report: %w", err)
+	}
+
+	if err := e.outputExporter.ExportOutput(consolidatedTestReportPathEnvVarKey, pth); err != nil {
+		return fmt.Errorf("failed to export %s: %w", consolidatedTestReportPathEnvVarKey, err)
+	}
+	e.logger.Donef("The consolidated test report path (%s) is exported to the %s environment variable.", pth, consolidatedTestReportPathEnvVarKey)
+
+	return nil
+}
+
+func (e exporter) createConsolidatedTestReport(devices []DeviceTestResult) (TestSuites, error) {
+	report := TestSuites{}
+	for _, device := range devices {
+		testReport, err := e.convertTestReport(device.MergedTestResultXMLPath)
+		if err != nil {
+			return TestSuites{}, fmt.Errorf("failed to convert test report (%s): %w", device.MergedTestResultXMLPath, err)
+		}
+
+		testSuite := deviceTestSuite(testReport)
+		testSuite.Name = device.Name
+		testSuite.Properties = &Properties{
+			Property: []Property{
+				{Name: "model", Value: device.Model},
+				{Name: "os_version", Value: device.OSVersion},
+				{Name: "locale", Value: device.Locale},
+				{Name: "orientation", Value: device.Orientation},
+				{Name: "attempts", Value: strconv.Itoa(device.Attempts)},
+			},
+		}
+
+		report.Tests += testSuite.Tests
+		report.Failures += testSuite.Failures
+		report.Flakes += testSuite.Flakes
+		report.Errors += testSuite.Errors
+		report.Skipped += testSuite.Skipped
+		report.Time += testSuite.Time
+		report.TestSuites = append(report.TestSuites, testSuite)
+	}
+
+	return report, nil
+}
+
+// deviceTestSuite returns the report's only test suite, or a test suite with the report's suites nested.
+func deviceTestSuite(testReport TestSuites) TestSuite {
+	if len(testReport.TestSuites) == 1 {
+		return testReport.TestSuites[0]
+	}
+
+	testSuite := TestSuite{TestSuites: testReport.TestSuites}
+	for _, nested := range testReport.TestSuites {
+		testSuite.Tests += nested.Tests
+		testSuite.Failures += nested.Failures
+		testSuite.Flakes += nested.Flakes
+		testSuite.Errors += nested.Errors
+		testSuite.Skipped += nested.Skipped
+		testSuite.Time += nested.Time
+	}
+
+	return testSuite
+}
@@ -0,0 +1,76 @@
+package output
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/mocks"
+)
+
+func TestExportConsolidatedTestReport(t *testing.T) {
+	_, b, _, _ := runtime.Caller(0)
+	testDataDir := filepath.Join(filepath.Dir(b), "testdata")
+	devices := []DeviceTestResult{
+		{
+			Name:                    "iphone13pro-16.6-en-portrait",
+			Model:                   "iphone13pro",
+			OSVersion:               "16.6",
+			Locale:                  "en",
+			Orientation:             "portrait",
+			Attempts:                1,
+			MergedTestResultXMLPath: filepath.Join(testDataDir, "iphone13pro-16.6-en-portrait-test_results_merged.xml"),
+		},
+		{
+			Name:                    "iphone8-16.6-en-portrait",
+			Model:                   "iphone8",
+			OSVersion:               "16.6",
+			Locale:                  "en",
+			Orientation:             "portrait",
+			Attempts:                3,
+			MergedTestResultXMLPath: filepath.Join(testDataDir, "iphone8-16.6-en-portrait-test_results_merged.xml"),
+		},
+	}
+	dir := t.TempDir()
+	wantReportPth := filepath.Join(dir, consolidatedTestReportFileName)
+
+	logger := mocks.NewLogger(t)
+	mockOutputExporter := mocks.NewOutputExporter(t)
+	logger.On("Donef", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockOutputExporter.On("ExportOutput", consolidatedTestReportPathEnvVarKey, wantReportPth).Return(nil)
+
+	e := exporter{
+		outputExporter: mockOutputExporter,
+		logger:         logger,
+	}
+
+	err := e.ExportConsolidatedTestReport(devices, dir)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(wantReportPth)
+	require.NoError(t, err)
+
+	var report TestSuites
+	require.NoError(t, xml.Unmarshal(content, &report))
+	require.Equal(t, 2, report.Tests)
+	require.Equal(t, 1, report.Flakes)
+	require.Len(t, report.TestSuites, 2)
+
+	iphone8Suite := report.TestSuites[1]
+	require.Equal(t, "iphone8-16.6-en-portrait", iphone8Suite.Name)
+	require.Equal(t, []Property{
+		{XMLName: xml.Name{Local: "property"}, Name: "model", Value: "iphone8"},
+		{XMLName: xml.Name{Local: "property"}, Name: "os_version", Value: "16.6"},
+		{XMLName: xml.Name{Local: "property"}, Name: "locale", Value: "en"},
+		{XMLName: xml.Name{Local: "property"}, Name: "orientation", Value: "portrait"},
+		{XMLName: xml.Name{Local: "property"}, Name: "attempts", Value: "3"},
+	}, iphone8Suite.Properties.Property)
+	require.Len(t, iphone8Suite.TestCases, 1)
+	require.Equal(t, "BullsEyeFailingTests.BullsEyeRandomlyFailingTests", iphone8Suite.TestCases[0].ClassName)
+	require.Equal(t, "true", iphone8Suite.TestCases[0].Flaky)
+}
@@ -0,0 +1,144 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	failedTestCasesEnvVarKey         = "BITRISE_FAILED_TEST_CASES"
+	failedTestCasesJSONPathEnvVarKey = "BITRISE_FAILED_TEST_CASES_JSON_PATH"
+	failedTestCasesJSONFileName      = "failed_test_cases.json"
+
+	failureKindAssertion = "assertion"
+	failureKindCrash     = "crash"
+)
+
+// failedTestCases is the content of the failed test cases JSON file.
+type failedTestCases struct {
+	FailedTestCases []failedTestCase `json:"failed_test_cases"`
+}
+
+type failedTestCase struct {
+	// Name is in the BITRISE_FAILED_TEST_CASES format: TestSuite.TestClass.TestName
+	Name    string              `json:"name"`
+	Devices []failedTestCaseRun `json:"devices"`
+}
+
+// failedTestCaseRun is a failed test case's final attempt on a device.
+type failedTestCaseRun struct {
+	Device      string `json:"device"`
+	Model       string `json:"model"`
+	OSVersion   string `json:"os_version"`
+	Locale      string `json:"locale"`
+	Orientation string `json:"orientation"`
+	// Kind is crash if the test crashed, assertion otherwise.
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+/*
+ExportFailedTests exports the test cases which failed in their final attempt on any device:
+the BITRISE_FAILED_TEST_CASES env var lists them in the BITRISE_FLAKY_TEST_CASES format, and a JSON file
+in dir lists them with the devices they failed on and their failure.
+*/
+func (e exporter) ExportFailedTests(devices []DeviceTestResult, dir string) error {
+	testCases, err := e.collectFailedTestCases(devices)
+	if err != nil {
+		return err
+	}
+	if len(testCases) == 0 {
+		return nil
+	}
+
+	e.logger.TDonef("%d failed test case(s) detected, exporting %s env var", len(testCases), failedTestCasesEnvVarKey)
+
+	var names []string
+	for _, testCase := range testCases {
+		names = append(names, testCase.Name)
+	}
+	if err := e.outputExporter.ExportOutput(failedTestCasesEnvVarKey, e.limitedTestCaseList(failedTestCasesEnvVarKey, names)); err != nil {
+		return fmt.Errorf("failed to export %s: %w", failedTestCasesEnvVarKey, err)
+	}
+
+	content, err := json.MarshalIndent(failedTestCases{FailedTestCases: testCases}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal failed test cases: %w", err)
+	}
+
+	pth := filepath.Join(dir, failedTestCasesJSONFileName)
+	if err := os.WriteFile(pth, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", failedTestCasesJSONFileName, err)
+	}
+
+	if err := e.outputExporter.ExportOutput(failedTestCasesJSONPathEnvVarKey, pth); err != nil {
+		return fmt.Errorf("failed to export %s: %w", failedTestCasesJSONPathEnvVarKey, err)
+	}
+	e.logger.Donef("The failed test cases file path (%s) is exported to the %s environment variable.", pth, failedTestCasesJSONPathEnvVarKey)
+
+	return nil
+}
+
+func (e exporter) collectFailedTestCases(devices []DeviceTestResult) ([]failedTestCase, error) {
+	nameToTestCase := map[string]*failedTestCase{}
+	for _, device := range devices {
+		testReport, err := e.convertTestReport(device.MergedTestResultXMLPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert test report (%s): %w", device.MergedTestResultXMLPath, err)
+		}
+
+		for _, testSuite := range testReport.LeafTestSuites() {
+			for _, testCase := range testSuite.TestCases {
+				if testCaseStatus(testCase) != testCaseStatusFailed {
+					continue
+				}
+
+				name := fullTestCaseName(testSuite, testCase)
+				failed, ok := nameToTestCase[name]
+				if !ok {
+					failed = &failedTestCase{Name: name}
+					nameToTestCase[name] = failed
+				}
+				failed.Devices = append(failed.Devices, failedTestCaseRun{
+					Device:      device.Name,
+					Model:       device.Model,
+					OSVersion:   device.OSVersion,
+					Locale:      device.Locale,
+					Orientation: device.Orientation,
+					Kind:        testCaseFailureKind(testCase),
+					Message:     testCaseFailureMessage(testCase),
+				})
+			}
+		}
+	}
+
+	var testCases []failedTestCase
+	for _, testCase := range nameToTestCase {
+		testCases = append(testCases, *testCase)
+	}
+	sort.Slice(testCases, func(i, j int) bool {
+		return testCases[i].Name < testCases[j].Name
+	})
+
+	return testCases, nil
+}
+
+// testCaseFailureKind tells crashes apart from assertion failures: XCTest reports crashes as errors, or as failures mentioning the crash.
+func testCaseFailureKind(testCase TestCase) string {
+	if testCase.Error != nil {
+		return failureKindCrash
+	}
+
+	if testCase.Failure != nil {
+		text := strings.ToLower(testCase.Failure.Type + " " + testCase.Failure.Message + " " + testCase.Failure.Value)
+		if strings.Contains(text, "crash") {
+			return failureKindCrash
+		}
+	}
+
+	return failureKindAssertion
+}
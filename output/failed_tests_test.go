@@ -0,0 +1,77 @@
+package output
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/mocks"
+)
+
+func TestExportFailedTests(t *testing.T) {
+	dir := t.TempDir()
+	reportPth := filepath.Join(dir, "ipad10-16.6-en-landscape-test_results_merged.xml")
+	require.NoError(t, os.WriteFile(reportPth, []byte(`<testsuite name="BullsEyeTests" tests="4" failures="2" errors="1">
+  <testcase name="testAssert" classname="BullsEyeTests">
+    <failure message="XCTAssertEqual failed: (&quot;1&quot;) is not equal to (&quot;2&quot;)">BullsEyeTests.swift:12</failure>
+  </testcase>
+  <testcase name="testCrash" classname="BullsEyeTests">
+    <error message="Test crashed with signal segv"/>
+  </testcase>
+  <testcase name="testFlaky" classname="BullsEyeTests" flaky="true">
+    <failure>failed</failure>
+  </testcase>
+  <testcase name="testPass" classname="BullsEyeTests"/>
+</testsuite>`), 0644))
+	devices := []DeviceTestResult{
+		{
+			Name:                    "ipad10-16.6-en-landscape",
+			Model:                   "ipad10",
+			OSVersion:               "16.6",
+			Locale:                  "en",
+			Orientation:             "landscape",
+			Attempts:                1,
+			MergedTestResultXMLPath: reportPth,
+		},
+	}
+
+	logger := mocks.NewLogger(t)
+	mockOutputExporter := mocks.NewOutputExporter(t)
+	logger.On("TDonef", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	logger.On("Donef", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockOutputExporter.On("ExportOutput", failedTestCasesEnvVarKey, "- BullsEyeTests.BullsEyeTests.testAssert\n- BullsEyeTests.BullsEyeTests.testCrash\n").Return(nil)
+	mockOutputExporter.On("ExportOutput", failedTestCasesJSONPathEnvVarKey, filepath.Join(dir, failedTestCasesJSONFileName)).Return(nil)
+
+	e := exporter{
+		outputExporter: mockOutputExporter,
+		logger:         logger,
+	}
+
+	err := e.ExportFailedTests(devices, dir)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(dir, failedTestCasesJSONFileName))
+	require.NoError(t, err)
+	var got failedTestCases
+	require.NoError(t, json.Unmarshal(content, &got))
+	require.Equal(t, failedTestCases{
+		FailedTestCases: []failedTestCase{
+			{
+				Name: "BullsEyeTests.BullsEyeTests.testAssert",
+				Devices: []failedTestCaseRun{
+					{Device: "ipad10-16.6-en-landscape", Model: "ipad10", OSVersion: "16.6", Locale: "en", Orientation: "landscape", Kind: "assertion", Message: `XCTAssertEqual failed: ("1") is not equal to ("2")`},
+				},
+			},
+			{
+				Name: "BullsEyeTests.BullsEyeTests.testCrash",
+				Devices: []failedTestCaseRun{
+					{Device: "ipad10-16.6-en-landscape", Model: "ipad10", OSVersion: "16.6", Locale: "en", Orientation: "landscape", Kind: "crash", Message: "Test crashed with signal segv"},
+				},
+			},
+		},
+	}, got)
+}
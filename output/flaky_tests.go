@@ -0,0 +1,165 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	flakyTestCasesPathEnvVarKey     = "BITRISE_FLAKY_TEST_CASES_PATH"
+	flakyTestCasesJSONPathEnvVarKey = "BITRISE_FLAKY_TEST_CASES_JSON_PATH"
+	flakyTestCasesFileName          = "flaky_test_cases.txt"
+	flakyTestCasesJSONFileName      = "flaky_test_cases.json"
+)
+
+// flakyTestCases is the content of the flaky test cases JSON file.
+type flakyTestCases struct {
+	FlakyTestCases []flakyTestCase `json:"flaky_test_cases"`
+}
+
+type flakyTestCase struct {
+	// Name is in the BITRISE_FLAKY_TEST_CASES format: TestSuite.TestClass.TestName
+	Name    string             `json:"name"`
+	Devices []flakyTestCaseRun `json:"devices"`
+}
+
+// flakyTestCaseRun is a flaky test case's run on a device.
+type flakyTestCaseRun struct {
+	Device         string `json:"device"`
+	FailedAttempts int    `json:"failed_attempts"`
+	Attempts       int    `json:"attempts"`
+}
+
+/*
+ExportFlakyTestsFiles writes the complete list of flaky test cases into dir, as text and as JSON,
+with the devices the test cases were flaky on and their number of failed attempts, and exports the files' paths.
+
+Unlike the BITRISE_FLAKY_TEST_CASES env var, the files are not size limited.
+*/
+func (e exporter) ExportFlakyTestsFiles(devices []DeviceTestResult, dir string) error {
+	testCases, err := e.collectFlakyTestCases(devices)
+	if err != nil {
+		return err
+	}
+	if len(testCases) == 0 {
+		return nil
+	}
+
+	jsonContent, err := json.MarshalIndent(flakyTestCases{FlakyTestCases: testCases}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal flaky test cases: %w", err)
+	}
+
+	for _, f := range []struct {
+		envVarKey string
+		fileName  string
+		content   []byte
+	}{
+		{envVarKey: flakyTestCasesPathEnvVarKey, fileName: flakyTestCasesFileName, content: []byte(flakyTestCasesText(testCases))},
+		{envVarKey: flakyTestCasesJSONPathEnvVarKey, fileName: flakyTestCasesJSONFileName, content: jsonContent},
+	} {
+		pth := filepath.Join(dir, f.fileName)
+		if err := os.WriteFile(pth, f.content, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.fileName, err)
+		}
+
+		if err := e.outputExporter.ExportOutput(f.envVarKey, pth); err != nil {
+			return fmt.Errorf("failed to export %s: %w", f.envVarKey, err)
+		}
+		e.logger.Donef("The flaky test cases file path (%s) is exported to the %s environment variable.", pth, f.envVarKey)
+	}
+
+	return nil
+}
+
+func (e exporter) collectFlakyTestCases(devices []DeviceTestResult) ([]flakyTestCase, error) {
+	nameToTestCase := map[string]*flakyTestCase{}
+	for _, device := range devices {
+		testReport, err := e.convertTestReport(device.MergedTestResultXMLPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert test report (%s): %w", device.MergedTestResultXMLPath, err)
+		}
+
+		failedAttempts, err := e.countFailedAttempts(device.TestResultXMLPaths)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, testSuite := range testReport.LeafTestSuites() {
+			for _, testCase := range testSuite.TestCases {
+				if !testCase.IsFlaky() {
+					continue
+				}
+
+				name := fullTestCaseName(testSuite, testCase)
+				run := flakyTestCaseRun{
+					Device:         device.Name,
+					FailedAttempts: failedAttempts[name],
+					Attempts:       device.Attempts,
+				}
+				if run.FailedAttempts == 0 {
+					// Without the attempts' reports only the merged report's rerun elements are known
+					run.FailedAttempts = max(len(testCase.FlakyFailures)+len(testCase.FlakyErrors), 1)
+				}
+
+				flaky, ok := nameToTestCase[name]
+				if !ok {
+					flaky = &flakyTestCase{Name: name}
+					nameToTestCase[name] = flaky
+				}
+				flaky.Devices = append(flaky.Devices, run)
+			}
+		}
+	}
+
+	var testCases []flakyTestCase
+	for _, testCase := range nameToTestCase {
+		testCases = append(testCases, *testCase)
+	}
+	sort.Slice(testCases, func(i, j int) bool {
+		return testCases[i].Name < testCases[j].Name
+	})
+
+	return testCases, nil
+}
+
+// countFailedAttempts returns the number of attempts each test case (by full name) failed in.
+func (e exporter) countFailedAttempts(attemptTestResultXMLPths []string) (map[string]int, error) {
+	failedAttempts := map[string]int{}
+	for _, pth := range attemptTestResultXMLPths {
+		testReport, err := e.convertTestReport(pth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert test report (%s): %w", pth, err)
+		}
+
+		for _, testSuite := range testReport.LeafTestSuites() {
+			for _, testCase := range testSuite.TestCases {
+				if testCase.Failure != nil || testCase.Error != nil {
+					failedAttempts[fullTestCaseName(testSuite, testCase)]++
+				}
+			}
+		}
+	}
+	return failedAttempts, nil
+}
+
+/*
+flakyTestCasesText lists the test cases in the BITRISE_FLAKY_TEST_CASES format, each followed by its devices:
+
+- TestSuite.TestClass.TestName
+  - iphone8-16.6-en-portrait: 1 of 3 attempts failed
+*/
+func flakyTestCasesText(testCases []flakyTestCase) string {
+	var b strings.Builder
+	for _, testCase := range testCases {
+		b.WriteString(fmt.Sprintf("- %s\n", testCase.Name))
+		for _, run := range testCase.Devices {
+			b.WriteString(fmt.Sprintf("  - %s: %d of %d attempts failed\n", run.Device, run.FailedAttempts, run.Attempts))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,90 @@
+package output
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/mocks"
+)
+
+func TestExportFlakyTestsFiles(t *testing.T) {
+	_, b, _, _ := runtime.Caller(0)
+	testDataDir := filepath.Join(filepath.Dir(b), "testdata")
+	devices := []DeviceTestResult{
+		{
+			Name:                    "iphone13pro-16.6-en-portrait",
+			Attempts:                1,
+			MergedTestResultXMLPath: filepath.Join(testDataDir, "iphone13pro-16.6-en-portrait-test_results_merged.xml"),
+			TestResultXMLPaths:      []string{filepath.Join(testDataDir, "iphone13pro-16.6-en-portrait_test_result_0.xml")},
+		},
+		{
+			Name:                    "iphone8-16.6-en-portrait",
+			Attempts:                3,
+			MergedTestResultXMLPath: filepath.Join(testDataDir, "iphone8-16.6-en-portrait-test_results_merged.xml"),
+			TestResultXMLPaths: []string{
+				filepath.Join(testDataDir, "iphone8-16.6-en-portrait_test_result_0.xml"),
+				filepath.Join(testDataDir, "iphone8-16.6-en-portrait-rerun_1_test_result_0.xml"),
+				filepath.Join(testDataDir, "iphone8-16.6-en-portrait-rerun_2_test_result_0.xml"),
+			},
+		},
+	}
+	dir := t.TempDir()
+
+	logger := mocks.NewLogger(t)
+	mockOutputExporter := mocks.NewOutputExporter(t)
+	logger.On("Donef", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockOutputExporter.On("ExportOutput", flakyTestCasesPathEnvVarKey, filepath.Join(dir, flakyTestCasesFileName)).Return(nil)
+	mockOutputExporter.On("ExportOutput", flakyTestCasesJSONPathEnvVarKey, filepath.Join(dir, flakyTestCasesJSONFileName)).Return(nil)
+
+	e := exporter{
+		outputExporter: mockOutputExporter,
+		logger:         logger,
+	}
+
+	err := e.ExportFlakyTestsFiles(devices, dir)
+	require.NoError(t, err)
+
+	text, err := os.ReadFile(filepath.Join(dir, flakyTestCasesFileName))
+	require.NoError(t, err)
+	require.Equal(t, `- BullsEyeFailingTests.BullsEyeRandomlyFailingTests.testRandomlyFail
+  - iphone8-16.6-en-portrait: 1 of 3 attempts failed
+`, string(text))
+
+	jsonContent, err := os.ReadFile(filepath.Join(dir, flakyTestCasesJSONFileName))
+	require.NoError(t, err)
+	var got flakyTestCases
+	require.NoError(t, json.Unmarshal(jsonContent, &got))
+	require.Equal(t, flakyTestCases{
+		FlakyTestCases: []flakyTestCase{
+			{
+				Name:    "BullsEyeFailingTests.BullsEyeRandomlyFailingTests.testRandomlyFail",
+				Devices: []flakyTestCaseRun{{Device: "iphone8-16.6-en-portrait", FailedAttempts: 1, Attempts: 3}},
+			},
+		},
+	}, got)
+}
+
+func TestExportFlakyTestsFiles_noFlakyTests(t *testing.T) {
+	_, b, _, _ := runtime.Caller(0)
+	testDataDir := filepath.Join(filepath.Dir(b), "testdata")
+	devices := []DeviceTestResult{
+		{
+			Name:                    "iphone13pro-16.6-en-portrait",
+			Attempts:                1,
+			MergedTestResultXMLPath: filepath.Join(testDataDir, "iphone13pro-16.6-en-portrait-test_results_merged.xml"),
+		},
+	}
+
+	e := exporter{
+		outputExporter: mocks.NewOutputExporter(t),
+		logger:         mocks.NewLogger(t),
+	}
+
+	require.NoError(t, e.ExportFlakyTestsFiles(devices, t.TempDir()))
+}
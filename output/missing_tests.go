@@ -0,0 +1,155 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/testquarantine"
+)
+
+const (
+	missingTestsPathEnvVarKey = "VDTESTING_MISSING_TESTS_PATH"
+	missingTestsFileName      = "missing_tests.json"
+)
+
+// TestExpectation describes the tests expected to run on the devices.
+type TestExpectation struct {
+	// Devices are the names of the test devices (iphone8-16.6-en-portrait), in the test devices input's order.
+	Devices []string
+	// Tests are the expected tests by device name (iphone8-16.6-en-portrait), as TestTarget/TestClass/testMethod.
+	Tests map[string][]string
+	// MinTestCount is the minimum number of test cases expected to run on each device, 0 if not set.
+	MinTestCount int
+}
+
+// MissingTestsReport lists the expected tests which did not run, per device.
+type MissingTestsReport struct {
+	MinTestCount int                  `json:"min_test_count,omitempty"`
+	Devices      []DeviceMissingTests `json:"devices"`
+}
+
+// DeviceMissingTests compares the tests which ran on a device to the expected tests.
+type DeviceMissingTests struct {
+	// Device is the device dimension in the test assets' naming format: iphone8-16.6-en-portrait
+	Device string `json:"device"`
+	// Incomplete is true if the device has no test results, an expected test is missing,
+	// or fewer test cases ran than the minimum test count.
+	Incomplete        bool     `json:"incomplete"`
+	ExpectedTestCount int      `json:"expected_test_count"`
+	ExecutedTestCount int      `json:"executed_test_count"`
+	MissingTests      []string `json:"missing_tests"`
+}
+
+// IncompleteDevices returns the names of the devices on which expected tests did not run.
+func (r MissingTestsReport) IncompleteDevices() []string {
+	var devices []string
+	for _, device := range r.Devices {
+		if device.Incomplete {
+			devices = append(devices, device.Device)
+		}
+	}
+	return devices
+}
+
+/*
+ExportMissingTests compares the test cases of the devices' merged test results XML to the expected tests, writes the
+comparison into dir and exports the file's path.
+
+A test counts as executed regardless of its status, the cases of a parameterized Swift Testing test count once.
+A test device without a merged test results XML is incomplete, all of its expected tests are missing.
+*/
+func (e exporter) ExportMissingTests(devices []DeviceTestResult, expectation TestExpectation, dir string) (MissingTestsReport, error) {
+	report, err := e.createMissingTestsReport(devices, expectation)
+	if err != nil {
+		return MissingTestsReport{}, err
+	}
+
+	for _, device := range report.Devices {
+		if !device.Incomplete {
+			continue
+		}
+
+		e.logger.Warnf("%s: %d test case(s) ran, %d test(s) missing", device.Device, device.ExecutedTestCount, len(device.MissingTests))
+		for _, test := range device.MissingTests {
+			e.logger.Printf("- %s", test)
+		}
+	}
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return MissingTestsReport{}, fmt.Errorf("failed to marshal missing tests: %w", err)
+	}
+
+	pth := filepath.Join(dir, missingTestsFileName)
+	if err := os.WriteFile(pth, content, 0644); err != nil {
+		return MissingTestsReport{}, fmt.Errorf("failed to write %s: %w", missingTestsFileName, err)
+	}
+
+	if err := e.outputExporter.ExportOutput(missingTestsPathEnvVarKey, pth); err != nil {
+		return MissingTestsReport{}, fmt.Errorf("failed to export %s: %w", missingTestsPathEnvVarKey, err)
+	}
+	e.logger.Donef("The missing tests path (%s) is exported to the %s environment variable.", pth, missingTestsPathEnvVarKey)
+
+	return report, nil
+}
+
+func (e exporter) createMissingTestsReport(devices []DeviceTestResult, expectation TestExpectation) (MissingTestsReport, error) {
+	mergedTestResultXMLPaths := map[string]string{}
+	for _, device := range devices {
+		mergedTestResultXMLPaths[device.Name] = device.MergedTestResultXMLPath
+	}
+
+	report := MissingTestsReport{MinTestCount: expectation.MinTestCount, Devices: []DeviceMissingTests{}}
+	for _, deviceName := range expectation.Devices {
+		executed := map[string]bool{}
+		executedCount := 0
+		hasResults := mergedTestResultXMLPaths[deviceName] != ""
+		if hasResults {
+			testReport, err := e.convertTestReport(mergedTestResultXMLPaths[deviceName])
+			if err != nil {
+				return MissingTestsReport{}, fmt.Errorf("failed to convert test report (%s): %w", mergedTestResultXMLPaths[deviceName], err)
+			}
+
+			for _, testSuite := range testReport.LeafTestSuites() {
+				for _, testCase := range testSuite.TestCases {
+					executedCount++
+					if test, ok := quarantinedTestOf(testSuite, testCase); ok {
+						executed[quarantinedTestKey(test)] = true
+					}
+				}
+			}
+		}
+
+		expectedTests := expectation.Tests[deviceName]
+		missingTests := []string{}
+		for _, expectedTest := range expectedTests {
+			if !executed[expectedTestKey(expectedTest)] {
+				missingTests = append(missingTests, expectedTest)
+			}
+		}
+
+		report.Devices = append(report.Devices, DeviceMissingTests{
+			Device:            deviceName,
+			Incomplete:        !hasResults || len(missingTests) > 0 || executedCount < expectation.MinTestCount,
+			ExpectedTestCount: len(expectedTests),
+			ExecutedTestCount: executedCount,
+			MissingTests:      missingTests,
+		})
+	}
+
+	return report, nil
+}
+
+// expectedTestKey converts the TestTarget/TestClass/testMethod test to the quarantinedTestKey format.
+func expectedTestKey(test string) string {
+	target, rest, _ := strings.Cut(test, "/")
+	className, testCaseName, _ := strings.Cut(rest, "/")
+	return quarantinedTestKey(testquarantine.QuarantinedTest{
+		TestSuiteName: []string{target},
+		ClassName:     className,
+		TestCaseName:  testCaseName,
+	})
+}
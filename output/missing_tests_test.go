@@ -0,0 +1,103 @@
+package output
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/mocks"
+)
+
+func TestExportMissingTests(t *testing.T) {
+	dir := t.TempDir()
+	devices := []DeviceTestResult{
+		{
+			Name: "iphone8-15.7-en-portrait",
+			MergedTestResultXMLPath: writeTestReport(t, dir, "iphone8.xml", `<testsuite name="" tests="3">
+  <testcase name="testScore" classname="BullsEyeTests.GameTests"/>
+  <testcase name="testStart()" classname="BullsEyeTests.GameTests"><failure>failed</failure></testcase>
+  <testcase name="testLaunch" classname="BullsEyeUITests.LaunchTests"/>
+</testsuite>`),
+		},
+		{
+			Name: "iphone13pro-16.6-en-portrait",
+			MergedTestResultXMLPath: writeTestReport(t, dir, "iphone13pro.xml", `<testsuite name="" tests="1">
+  <testcase name="testScore" classname="BullsEyeTests.GameTests"/>
+</testsuite>`),
+		},
+	}
+	expectation := TestExpectation{
+		Devices: []string{"iphone8-15.7-en-portrait", "iphone13pro-16.6-en-portrait", "iphone14-17.5-en-portrait"},
+		Tests: map[string][]string{
+			"iphone8-15.7-en-portrait":     {"BullsEyeTests/GameTests/testScore", "BullsEyeTests/GameTests/testStart", "BullsEyeUITests/LaunchTests/testLaunch"},
+			"iphone13pro-16.6-en-portrait": {"BullsEyeTests/GameTests/testScore", "BullsEyeUITests/LaunchTests/testLaunch"},
+			"iphone14-17.5-en-portrait":    {"BullsEyeTests/GameTests/testScore", "BullsEyeUITests/LaunchTests/testLaunch"},
+		},
+		MinTestCount: 2,
+	}
+	wantPth := filepath.Join(dir, missingTestsFileName)
+
+	logger := mocks.NewLogger(t)
+	mockOutputExporter := mocks.NewOutputExporter(t)
+	logger.On("Warnf", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	logger.On("Printf", mock.Anything, mock.Anything).Return(nil)
+	logger.On("Donef", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockOutputExporter.On("ExportOutput", missingTestsPathEnvVarKey, wantPth).Return(nil)
+
+	e := exporter{
+		outputExporter: mockOutputExporter,
+		logger:         logger,
+	}
+
+	report, err := e.ExportMissingTests(devices, expectation, dir)
+	require.NoError(t, err)
+
+	want := MissingTestsReport{
+		MinTestCount: 2,
+		Devices: []DeviceMissingTests{
+			{Device: "iphone8-15.7-en-portrait", ExpectedTestCount: 3, ExecutedTestCount: 3, MissingTests: []string{}},
+			{Device: "iphone13pro-16.6-en-portrait", Incomplete: true, ExpectedTestCount: 2, ExecutedTestCount: 1, MissingTests: []string{"BullsEyeUITests/LaunchTests/testLaunch"}},
+			{Device: "iphone14-17.5-en-portrait", Incomplete: true, ExpectedTestCount: 2, ExecutedTestCount: 0, MissingTests: []string{"BullsEyeTests/GameTests/testScore", "BullsEyeUITests/LaunchTests/testLaunch"}},
+		},
+	}
+	require.Equal(t, want, report)
+	require.Equal(t, []string{"iphone13pro-16.6-en-portrait", "iphone14-17.5-en-portrait"}, report.IncompleteDevices())
+	logger.AssertCalled(t, "Printf", "- %s", "BullsEyeUITests/LaunchTests/testLaunch")
+
+	content, err := os.ReadFile(wantPth)
+	require.NoError(t, err)
+
+	var got MissingTestsReport
+	require.NoError(t, json.Unmarshal(content, &got))
+	require.Equal(t, want, got)
+}
+
+func TestExportMissingTests_minTestCount(t *testing.T) {
+	dir := t.TempDir()
+	devices := []DeviceTestResult{
+		{
+			Name:                    "iphone8-15.7-en-portrait",
+			MergedTestResultXMLPath: writeTestReport(t, dir, "iphone8.xml", `<testsuite name="BullsEyeTests" tests="0"></testsuite>`),
+		},
+	}
+
+	logger := mocks.NewLogger(t)
+	mockOutputExporter := mocks.NewOutputExporter(t)
+	logger.On("Warnf", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	logger.On("Donef", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockOutputExporter.On("ExportOutput", missingTestsPathEnvVarKey, mock.Anything).Return(nil)
+
+	e := exporter{
+		outputExporter: mockOutputExporter,
+		logger:         logger,
+	}
+
+	report, err := e.ExportMissingTests(devices, TestExpectation{Devices: []string{"iphone8-15.7-en-portrait"}, MinTestCount: 1}, dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{"iphone8-15.7-en-portrait"}, report.IncompleteDevices())
+	require.Empty(t, report.Devices[0].MissingTests)
+}
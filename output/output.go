@@ -1,10 +1,13 @@
 package output
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"os"
 
+	"github.com/bitrise-io/go-steputils/v2/testquarantine"
 	"github.com/bitrise-io/go-utils/v2/log"
 )
 
@@ -15,7 +18,16 @@ const (
 
 type Exporter interface {
 	ExportTestResultsDir(dir string) error
+	ExportTestInventory(inventory TestInventory, dir string) error
 	ExportFlakyTestsEnvVar(mergedTestResultXmlPths []string) error
+	ExportFlakyTestsFiles(devices []DeviceTestResult, dir string) error
+	ExportFailedTests(devices []DeviceTestResult, dir string) error
+	ExportQuarantineReport(report QuarantineReport, dir string) error
+	ExportQuarantineSuggestions(devices []DeviceTestResult, quarantinedTests []testquarantine.QuarantinedTest, dir string) error
+	ExportMissingTests(devices []DeviceTestResult, expectation TestExpectation, dir string) (MissingTestsReport, error)
+	ExportConsolidatedTestReport(devices []DeviceTestResult, dir string) error
+	ExportRunResults(results RunResults, dir string) error
+	ExportRunReport(results RunResults, dir string) error
 }
 
 type exporter struct {
@@ -39,37 +51,73 @@ func (e exporter) ExportTestResultsDir(dir string) error {
 }
 
 func (e exporter) ExportFlakyTestsEnvVar(mergedTestResultXmlPths []string) error {
-	var flakyTestSuites []TestSuite
+	var testSuites []TestSuite
 	for _, testResultXMLPth := range mergedTestResultXmlPths {
-		testSuite, err := e.convertTestReport(testResultXMLPth)
+		testReport, err := e.convertTestReport(testResultXMLPth)
 		if err != nil {
 			return fmt.Errorf("failed to convert test report (%s): %w", testResultXMLPth, err)
 		}
 
-		if testSuite.Flakes > 0 {
-			flakyTestSuites = append(flakyTestSuites, testSuite)
-		}
+		testSuites = append(testSuites, testReport.LeafTestSuites()...)
 	}
 
-	if err := e.exportFlakyTestCasesEnvVar(flakyTestSuites); err != nil {
+	if err := e.exportFlakyTestCasesEnvVar(testSuites); err != nil {
 		return fmt.Errorf("failed to export flaky test cases env var: %w", err)
 	}
 
 	return nil
 }
 
-func (e exporter) convertTestReport(pth string) (TestSuite, error) {
+func (e exporter) convertTestReport(pth string) (TestSuites, error) {
 	data, err := os.ReadFile(pth)
 	if err != nil {
-		return TestSuite{}, err
+		return TestSuites{}, err
 	}
 
-	var testSuite TestSuite
-	if err := xml.Unmarshal(data, &testSuite); err != nil {
-		return TestSuite{}, nil
-	}
+	return parseTestReport(data)
+}
+
+// parseTestReport parses a JUnit XML test report with either a <testsuites> or a single <testsuite> root element.
+func parseTestReport(data []byte) (TestSuites, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return TestSuites{}, fmt.Errorf("no root element found")
+		} else if err != nil {
+			return TestSuites{}, err
+		}
 
-	return testSuite, nil
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "testsuites":
+			var testSuites TestSuites
+			if err := decoder.DecodeElement(&testSuites, &start); err != nil {
+				return TestSuites{}, err
+			}
+			return testSuites, nil
+		case "testsuite":
+			var testSuite TestSuite
+			if err := decoder.DecodeElement(&testSuite, &start); err != nil {
+				return TestSuites{}, err
+			}
+			return TestSuites{
+				Tests:      testSuite.Tests,
+				Failures:   testSuite.Failures,
+				Flakes:     testSuite.Flakes,
+				Errors:     testSuite.Errors,
+				Skipped:    testSuite.Skipped,
+				Time:       testSuite.Time,
+				TestSuites: []TestSuite{testSuite},
+			}, nil
+		default:
+			return TestSuites{}, fmt.Errorf("unexpected root element: <%s>", start.Name.Local)
+		}
+	}
 }
 
 func (e exporter) exportFlakyTestCasesEnvVar(flakyTestSuites []TestSuite) error {
@@ -81,19 +129,11 @@ func (e exporter) exportFlakyTestCasesEnvVar(flakyTestSuites []TestSuite) error
 	var flakyTestCases []string
 	for _, testSuite := range flakyTestSuites {
 		for _, testCase := range testSuite.TestCases {
-			if testCase.Flaky != "true" {
+			if !testCase.IsFlaky() {
 				continue
 			}
 
-			testCaseName := testCase.Name
-			if len(testCase.ClassName) > 0 {
-				testCaseName = fmt.Sprintf("%s.%s", testCase.ClassName, testCase.Name)
-			}
-
-			if len(testSuite.Name) > 0 {
-				testCaseName = testSuite.Name + "." + testCaseName
-			}
-
+			testCaseName := fullTestCaseName(testSuite, testCase)
 			if _, stored := storedFlakyTestCases[testCaseName]; !stored {
 				storedFlakyTestCases[testCaseName] = true
 				flakyTestCases = append(flakyTestCases, testCaseName)
@@ -107,17 +147,7 @@ func (e exporter) exportFlakyTestCasesEnvVar(flakyTestSuites []TestSuite) error
 		e.logger.TDonef("%d flaky test case(s) detected, exporting %s env var", len(flakyTestCases), flakyTestCasesEnvVarKey)
 	}
 
-	var flakyTestCasesMessage string
-	for i, flakyTestCase := range flakyTestCases {
-		flakyTestCasesMessageLine := fmt.Sprintf("- %s\n", flakyTestCase)
-
-		if len(flakyTestCasesMessage)+len(flakyTestCasesMessageLine) > flakyTestCasesEnvVarSizeLimitInBytes {
-			e.logger.TWarnf("%s env var size limit (%d characters) exceeded. Skipping %d test cases.", flakyTestCasesEnvVarKey, flakyTestCasesEnvVarSizeLimitInBytes, len(flakyTestCases)-i)
-			break
-		}
-
-		flakyTestCasesMessage += flakyTestCasesMessageLine
-	}
+	flakyTestCasesMessage := e.limitedTestCaseList(flakyTestCasesEnvVarKey, flakyTestCases)
 
 	if err := e.outputExporter.ExportOutput(flakyTestCasesEnvVarKey, flakyTestCasesMessage); err != nil {
 		return fmt.Errorf("failed to export %s: %w", flakyTestCasesEnvVarKey, err)
@@ -125,3 +155,33 @@ func (e exporter) exportFlakyTestCasesEnvVar(flakyTestSuites []TestSuite) error
 
 	return nil
 }
+
+// fullTestCaseName returns the test case's name prefixed with its class and test suite name: TestSuite.TestClass.TestName
+func fullTestCaseName(testSuite TestSuite, testCase TestCase) string {
+	testCaseName := testCase.Name
+	if len(testCase.ClassName) > 0 {
+		testCaseName = fmt.Sprintf("%s.%s", testCase.ClassName, testCase.Name)
+	}
+
+	if len(testSuite.Name) > 0 {
+		testCaseName = testSuite.Name + "." + testCaseName
+	}
+
+	return testCaseName
+}
+
+// limitedTestCaseList lists the test cases (one `- TestSuite.TestClass.TestName` per line) up to the env var size limit.
+func (e exporter) limitedTestCaseList(envVarKey string, testCases []string) string {
+	var message string
+	for i, testCase := range testCases {
+		line := fmt.Sprintf("- %s\n", testCase)
+
+		if len(message)+len(line) > flakyTestCasesEnvVarSizeLimitInBytes {
+			e.logger.TWarnf("%s env var size limit (%d characters) exceeded. Skipping %d test cases.", envVarKey, flakyTestCasesEnvVarSizeLimitInBytes, len(testCases)-i)
+			break
+		}
+
+		message += line
+	}
+	return message
+}
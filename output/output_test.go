@@ -2,6 +2,7 @@ package output
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -199,3 +200,97 @@ func Test_exporter_exportFlakyTestCasesEnvVar(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseTestReport(t *testing.T) {
+	tests := []struct {
+		name              string
+		report            string
+		wantSuiteNames    []string
+		wantFlakyTests    []string
+		wantErrorContains string
+	}{
+		{
+			name: "testsuite root",
+			report: `<?xml version='1.0' encoding='UTF-8' ?>
+<testsuite name="Suite1" tests="1" flakes="1">
+  <testcase name="testA" classname="Class1" flaky="true"><failure type="XCTAssertEqual">failed</failure></testcase>
+</testsuite>`,
+			wantSuiteNames: []string{"Suite1"},
+			wantFlakyTests: []string{"testA"},
+		},
+		{
+			name: "testsuites root with nested suites, properties and system-out",
+			report: `<?xml version='1.0' encoding='UTF-8'?>
+<testsuites>
+  <testsuite name='Target'>
+    <properties><property name='device' value='iphone8'/></properties>
+    <testsuite name='Class1'>
+      <testcase name='testA' classname='Class1'/>
+      <system-out>log</system-out>
+    </testsuite>
+    <testsuite name='Class2'>
+      <testcase name='testB' classname='Class2'>
+        <flakyFailure message='failed' type='assertion'><stackTrace>trace</stackTrace></flakyFailure>
+      </testcase>
+      <testcase name='testC' classname='Class2'>
+        <failure message='failed'/>
+        <rerunFailure message='failed again'/>
+      </testcase>
+    </testsuite>
+  </testsuite>
+</testsuites>`,
+			wantSuiteNames: []string{"Class1", "Class2"},
+			wantFlakyTests: []string{"testB"},
+		},
+		{
+			name:              "Malformed report",
+			report:            `<testsuite name="Suite1"><testcase name="testA">`,
+			wantErrorContains: "XML syntax error",
+		},
+		{
+			name:              "Unexpected root element",
+			report:            `<html></html>`,
+			wantErrorContains: "unexpected root element: <html>",
+		},
+		{
+			name:              "Empty report",
+			report:            ``,
+			wantErrorContains: "no root element found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := parseTestReport([]byte(tt.report))
+			if tt.wantErrorContains != "" {
+				require.ErrorContains(t, err, tt.wantErrorContains)
+				return
+			}
+			require.NoError(t, err)
+
+			var suiteNames, flakyTests []string
+			for _, testSuite := range report.LeafTestSuites() {
+				suiteNames = append(suiteNames, testSuite.Name)
+				for _, testCase := range testSuite.TestCases {
+					if testCase.IsFlaky() {
+						flakyTests = append(flakyTests, testCase.Name)
+					}
+				}
+			}
+			require.Equal(t, tt.wantSuiteNames, suiteNames)
+			require.Equal(t, tt.wantFlakyTests, flakyTests)
+		})
+	}
+}
+
+func TestExportFlakyTestsEnvVar_invalidReport(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "iphone8-16.6-en-portrait-test_results_merged.xml")
+	require.NoError(t, os.WriteFile(pth, []byte("<testsuite>"), 0644))
+
+	e := exporter{
+		outputExporter: mocks.NewOutputExporter(t),
+		logger:         mocks.NewLogger(t),
+	}
+
+	err := e.ExportFlakyTestsEnvVar([]string{pth})
+	require.ErrorContains(t, err, pth)
+}
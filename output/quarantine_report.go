@@ -0,0 +1,79 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bitrise-io/go-steputils/v2/testquarantine"
+)
+
+const (
+	quarantineReportPathEnvVarKey   = "VDTESTING_QUARANTINE_REPORT_PATH"
+	quarantineAppliedCountEnvVarKey = "VDTESTING_QUARANTINE_APPLIED_COUNT"
+	quarantineStaleCountEnvVarKey   = "VDTESTING_QUARANTINE_STALE_COUNT"
+	quarantineDroppedCountEnvVarKey = "VDTESTING_QUARANTINE_DROPPED_COUNT"
+	quarantineExpiredCountEnvVarKey = "VDTESTING_QUARANTINE_EXPIRED_COUNT"
+	quarantineReportFileName        = "quarantine_report.json"
+)
+
+const (
+	// QuarantineEntryApplied means the entry's test target is in the test bundle, so the test is skipped.
+	QuarantineEntryApplied = "applied"
+	// QuarantineEntryStale means the entry's test target is not in the test bundle, or the entry applies to none of the devices.
+	QuarantineEntryStale = "stale"
+	// QuarantineEntryDropped means the entry misses its test suite name, or has an invalid glob pattern or expiry.
+	QuarantineEntryDropped = "dropped"
+	// QuarantineEntryExpired means the entry's expiry passed, so the test runs normally.
+	QuarantineEntryExpired = "expired"
+)
+
+// QuarantineReport tells which entries of the quarantined tests input were applied to the test bundle.
+type QuarantineReport struct {
+	Applied int                     `json:"applied"`
+	Stale   int                     `json:"stale"`
+	Dropped int                     `json:"dropped"`
+	Expired int                     `json:"expired"`
+	Entries []QuarantineReportEntry `json:"entries"`
+}
+
+// QuarantineReportEntry is a quarantined tests input entry with its status.
+type QuarantineReportEntry struct {
+	testquarantine.QuarantinedTest
+	// Status is one of QuarantineEntryApplied, QuarantineEntryStale, QuarantineEntryDropped or QuarantineEntryExpired.
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// ExportQuarantineReport writes the quarantine report into dir and exports its path, and the number of applied, stale, dropped and expired entries.
+func (e exporter) ExportQuarantineReport(report QuarantineReport, dir string) error {
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal quarantine report: %w", err)
+	}
+
+	pth := filepath.Join(dir, quarantineReportFileName)
+	if err := os.WriteFile(pth, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", quarantineReportFileName, err)
+	}
+
+	for _, o := range []struct {
+		key   string
+		value string
+	}{
+		{key: quarantineReportPathEnvVarKey, value: pth},
+		{key: quarantineAppliedCountEnvVarKey, value: strconv.Itoa(report.Applied)},
+		{key: quarantineStaleCountEnvVarKey, value: strconv.Itoa(report.Stale)},
+		{key: quarantineDroppedCountEnvVarKey, value: strconv.Itoa(report.Dropped)},
+		{key: quarantineExpiredCountEnvVarKey, value: strconv.Itoa(report.Expired)},
+	} {
+		if err := e.outputExporter.ExportOutput(o.key, o.value); err != nil {
+			return fmt.Errorf("failed to export %s: %w", o.key, err)
+		}
+	}
+	e.logger.Donef("The quarantine report path (%s) is exported to the %s environment variable.", pth, quarantineReportPathEnvVarKey)
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package output
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/testquarantine"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/mocks"
+)
+
+func TestExportQuarantineReport(t *testing.T) {
+	report := QuarantineReport{
+		Applied: 1,
+		Stale:   1,
+		Entries: []QuarantineReportEntry{
+			{
+				QuarantinedTest: testquarantine.QuarantinedTest{TestCaseName: "testRandomlyFail()", TestSuiteName: []string{"BullsEyeFailingTests"}, ClassName: "BullsEyeRandomlyFailingTests"},
+				Status:          QuarantineEntryApplied,
+			},
+			{
+				QuarantinedTest: testquarantine.QuarantinedTest{TestCaseName: "testRemoved()", TestSuiteName: []string{"RemovedTests"}, ClassName: "RemovedTests"},
+				Status:          QuarantineEntryStale,
+				Reason:          "test target RemovedTests not found in the xctestrun files",
+			},
+		},
+	}
+	dir := t.TempDir()
+	wantPth := filepath.Join(dir, quarantineReportFileName)
+
+	logger := mocks.NewLogger(t)
+	mockOutputExporter := mocks.NewOutputExporter(t)
+	logger.On("Donef", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockOutputExporter.On("ExportOutput", quarantineReportPathEnvVarKey, wantPth).Return(nil)
+	mockOutputExporter.On("ExportOutput", quarantineAppliedCountEnvVarKey, "1").Return(nil)
+	mockOutputExporter.On("ExportOutput", quarantineStaleCountEnvVarKey, "1").Return(nil)
+	mockOutputExporter.On("ExportOutput", quarantineDroppedCountEnvVarKey, "0").Return(nil)
+	mockOutputExporter.On("ExportOutput", quarantineExpiredCountEnvVarKey, "0").Return(nil)
+
+	e := exporter{
+		outputExporter: mockOutputExporter,
+		logger:         logger,
+	}
+
+	err := e.ExportQuarantineReport(report, dir)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(wantPth)
+	require.NoError(t, err)
+	var got QuarantineReport
+	require.NoError(t, json.Unmarshal(content, &got))
+	require.Equal(t, report, got)
+}
@@ -0,0 +1,220 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-steputils/v2/testquarantine"
+)
+
+const (
+	quarantineSuggestionsPathEnvVarKey = "VDTESTING_QUARANTINE_SUGGESTIONS_PATH"
+	quarantineSuggestionsFileName      = "quarantine_suggestions.json"
+	// minFlakyDevicesToSuggestQuarantine is the number of devices a test needs to be flaky on to be suggested for quarantine.
+	minFlakyDevicesToSuggestQuarantine = 2
+)
+
+// quarantineSuggestion is a test case suggested for quarantine, the reason is only logged.
+type quarantineSuggestion struct {
+	test   testquarantine.QuarantinedTest
+	reason string
+}
+
+// testCaseDeviceStatus is a test case's final status on a device.
+type testCaseDeviceStatus struct {
+	device DeviceTestResult
+	status string
+}
+
+type testCaseStatuses struct {
+	test     testquarantine.QuarantinedTest
+	statuses []testCaseDeviceStatus
+}
+
+/*
+ExportQuarantineSuggestions writes the test cases suggested for quarantine into dir, in the $BITRISE_QUARANTINED_TESTS_JSON
+schema (the file is a valid quarantined tests input), and exports the file's path. A test case is suggested if it is not quarantined yet, and either:
+- it was flaky on multiple devices
+- it failed on every device with a specific OS version, but passed on all the other OS versions
+*/
+func (e exporter) ExportQuarantineSuggestions(devices []DeviceTestResult, quarantinedTests []testquarantine.QuarantinedTest, dir string) error {
+	suggestions, err := e.createQuarantineSuggestions(devices, quarantinedTests)
+	if err != nil {
+		return err
+	}
+	if len(suggestions) == 0 {
+		return nil
+	}
+
+	e.logger.TDonef("%d test case(s) suggested for quarantine:", len(suggestions))
+	var tests []testquarantine.QuarantinedTest
+	for _, suggestion := range suggestions {
+		e.logger.Printf("- %s/%s/%s: %s", suggestion.test.TestSuiteName[0], suggestion.test.ClassName, suggestion.test.TestCaseName, suggestion.reason)
+		tests = append(tests, suggestion.test)
+	}
+
+	content, err := json.MarshalIndent(tests, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal quarantine suggestions: %w", err)
+	}
+
+	pth := filepath.Join(dir, quarantineSuggestionsFileName)
+	if err := os.WriteFile(pth, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", quarantineSuggestionsFileName, err)
+	}
+
+	if err := e.outputExporter.ExportOutput(quarantineSuggestionsPathEnvVarKey, pth); err != nil {
+		return fmt.Errorf("failed to export %s: %w", quarantineSuggestionsPathEnvVarKey, err)
+	}
+	e.logger.Donef("The quarantine suggestions path (%s) is exported to the %s environment variable.", pth, quarantineSuggestionsPathEnvVarKey)
+
+	return nil
+}
+
+func (e exporter) createQuarantineSuggestions(devices []DeviceTestResult, quarantinedTests []testquarantine.QuarantinedTest) ([]quarantineSuggestion, error) {
+	quarantined := map[string]bool{}
+	for _, test := range quarantinedTests {
+		quarantined[quarantinedTestKey(test)] = true
+	}
+
+	keyToStatuses := map[string]*testCaseStatuses{}
+	for _, device := range devices {
+		testReport, err := e.convertTestReport(device.MergedTestResultXMLPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert test report (%s): %w", device.MergedTestResultXMLPath, err)
+		}
+
+		for _, testSuite := range testReport.LeafTestSuites() {
+			for _, testCase := range testSuite.TestCases {
+				test, ok := quarantinedTestOf(testSuite, testCase)
+				if !ok {
+					continue
+				}
+
+				key := quarantinedTestKey(test)
+				if quarantined[key] {
+					continue
+				}
+
+				statuses, ok := keyToStatuses[key]
+				if !ok {
+					statuses = &testCaseStatuses{test: test}
+					keyToStatuses[key] = statuses
+				}
+				statuses.statuses = append(statuses.statuses, testCaseDeviceStatus{device: device, status: testCaseStatus(testCase)})
+			}
+		}
+	}
+
+	var suggestions []quarantineSuggestion
+	for _, statuses := range keyToStatuses {
+		if reason := quarantineReason(statuses.statuses); reason != "" {
+			suggestions = append(suggestions, quarantineSuggestion{test: statuses.test, reason: reason})
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		return quarantinedTestKey(suggestions[i].test) < quarantinedTestKey(suggestions[j].test)
+	})
+
+	return suggestions, nil
+}
+
+// quarantineReason returns why the test case should be quarantined based on its statuses on the devices, or an empty string.
+func quarantineReason(statuses []testCaseDeviceStatus) string {
+	var flakyDevices []string
+	osVersionToStatuses := map[string][]string{}
+	for _, status := range statuses {
+		if status.status == testCaseStatusFlaky {
+			flakyDevices = append(flakyDevices, status.device.Name)
+		}
+		osVersionToStatuses[status.device.OSVersion] = append(osVersionToStatuses[status.device.OSVersion], status.status)
+	}
+
+	if len(flakyDevices) >= minFlakyDevicesToSuggestQuarantine {
+		return fmt.Sprintf("flaky on %d devices: %s", len(flakyDevices), strings.Join(flakyDevices, ", "))
+	}
+
+	if len(osVersionToStatuses) < 2 {
+		// Without other OS versions the failure can't be attributed to the OS version
+		return ""
+	}
+
+	var failingOSVersions []string
+	for osVersion, osStatuses := range osVersionToStatuses {
+		switch {
+		case allStatusesAre(osStatuses, testCaseStatusFailed):
+			failingOSVersions = append(failingOSVersions, osVersion)
+		case !allStatusesAre(osStatuses, testCaseStatusPassed):
+			return ""
+		}
+	}
+
+	if len(failingOSVersions) == 0 || len(failingOSVersions) == len(osVersionToStatuses) {
+		return ""
+	}
+
+	sort.Strings(failingOSVersions)
+	return fmt.Sprintf("fails only on iOS %s", strings.Join(failingOSVersions, ", "))
+}
+
+func allStatusesAre(statuses []string, status string) bool {
+	for _, s := range statuses {
+		if s != status {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+quarantinedTestOf converts the JUnit test case to the quarantined test format: the test target is the
+class name's module prefix (BullsEyeTests.BullsEyeRandomlyFailingTests), or the test suite's name, the test case
+name has the `()` suffix, like in the quarantined tests input (testRandomlyFail()).
+Nested Swift Testing suites keep their separator (BullsEyeTests.GameTests.RoundTests: GameTests.RoundTests).
+*/
+func quarantinedTestOf(testSuite TestSuite, testCase TestCase) (testquarantine.QuarantinedTest, bool) {
+	target, className, found := strings.Cut(testCase.ClassName, ".")
+	if !found {
+		target, className = testSuite.Name, testCase.ClassName
+	}
+
+	if target == "" || className == "" || testCase.Name == "" {
+		return testquarantine.QuarantinedTest{}, false
+	}
+
+	// The cases of a parameterized Swift Testing test are quarantined together
+	return testquarantine.QuarantinedTest{
+		TestCaseName:  TestFunctionName(testCase.Name),
+		TestSuiteName: []string{target},
+		ClassName:     className,
+	}, true
+}
+
+/*
+quarantinedTestKey identifies the test case regardless of the `()` suffix of its name, the arguments of a parameterized
+Swift Testing test case (scoreIsCalculated(points:) (points: 10)), and the separator of nested Swift Testing suites.
+*/
+func quarantinedTestKey(test testquarantine.QuarantinedTest) string {
+	target := ""
+	if len(test.TestSuiteName) > 0 {
+		target = test.TestSuiteName[0]
+	}
+
+	return fmt.Sprintf("%s/%s/%s", target, strings.ReplaceAll(test.ClassName, "/", "."), strings.TrimSuffix(TestFunctionName(test.TestCaseName), "()"))
+}
+
+/*
+TestFunctionName returns the test case's name with its argument labels, without the arguments of a parameterized
+Swift Testing test case: scoreIsCalculated(points:) (points: 10) -> scoreIsCalculated(points:), testScore -> testScore().
+*/
+func TestFunctionName(testCaseName string) string {
+	if end := strings.Index(testCaseName, ")"); end != -1 {
+		return testCaseName[:end+1]
+	}
+	return testCaseName + "()"
+}
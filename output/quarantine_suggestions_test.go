@@ -0,0 +1,106 @@
+package output
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-steputils/v2/testquarantine"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/mocks"
+)
+
+func writeTestReport(t *testing.T, dir, name, content string) string {
+	pth := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(pth, []byte(content), 0644))
+	return pth
+}
+
+func TestExportQuarantineSuggestions(t *testing.T) {
+	dir := t.TempDir()
+	devices := []DeviceTestResult{
+		{
+			Name:      "iphone8-15.7-en-portrait",
+			OSVersion: "15.7",
+			MergedTestResultXMLPath: writeTestReport(t, dir, "iphone8.xml", `<testsuite name="" tests="4">
+  <testcase name="testFlaky" classname="BullsEyeTests.GameTests" flaky="true"><failure>failed</failure></testcase>
+  <testcase name="testOSSpecific" classname="BullsEyeTests.GameTests"><failure>failed</failure></testcase>
+  <testcase name="testQuarantined" classname="BullsEyeTests.GameTests" flaky="true"><failure>failed</failure></testcase>
+  <testcase name="testFailing" classname="BullsEyeTests.GameTests"><failure>failed</failure></testcase>
+</testsuite>`),
+		},
+		{
+			Name:      "iphone13pro-16.6-en-portrait",
+			OSVersion: "16.6",
+			MergedTestResultXMLPath: writeTestReport(t, dir, "iphone13pro.xml", `<testsuite name="" tests="4">
+  <testcase name="testFlaky" classname="BullsEyeTests.GameTests" flaky="true"><failure>failed</failure></testcase>
+  <testcase name="testOSSpecific" classname="BullsEyeTests.GameTests"/>
+  <testcase name="testQuarantined" classname="BullsEyeTests.GameTests" flaky="true"><failure>failed</failure></testcase>
+  <testcase name="testFailing" classname="BullsEyeTests.GameTests"><failure>failed</failure></testcase>
+</testsuite>`),
+		},
+		{
+			Name:      "ipad10-16.6-en-landscape",
+			OSVersion: "16.6",
+			MergedTestResultXMLPath: writeTestReport(t, dir, "ipad10.xml", `<testsuite name="" tests="1">
+  <testcase name="testOSSpecific" classname="BullsEyeTests.GameTests"/>
+</testsuite>`),
+		},
+	}
+	quarantinedTests := []testquarantine.QuarantinedTest{
+		{TestCaseName: "testQuarantined()", TestSuiteName: []string{"BullsEyeTests"}, ClassName: "GameTests"},
+	}
+	wantPth := filepath.Join(dir, quarantineSuggestionsFileName)
+
+	logger := mocks.NewLogger(t)
+	mockOutputExporter := mocks.NewOutputExporter(t)
+	logger.On("TDonef", mock.Anything, mock.Anything).Return(nil)
+	logger.On("Printf", "- %s/%s/%s: %s", "BullsEyeTests", "GameTests", "testFlaky()", "flaky on 2 devices: iphone8-15.7-en-portrait, iphone13pro-16.6-en-portrait").Return(nil)
+	logger.On("Printf", "- %s/%s/%s: %s", "BullsEyeTests", "GameTests", "testOSSpecific()", "fails only on iOS 15.7").Return(nil)
+	logger.On("Donef", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockOutputExporter.On("ExportOutput", quarantineSuggestionsPathEnvVarKey, wantPth).Return(nil)
+
+	e := exporter{
+		outputExporter: mockOutputExporter,
+		logger:         logger,
+	}
+
+	err := e.ExportQuarantineSuggestions(devices, quarantinedTests, dir)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(wantPth)
+	require.NoError(t, err)
+
+	var got []testquarantine.QuarantinedTest
+	require.NoError(t, json.Unmarshal(content, &got))
+	require.Equal(t, []testquarantine.QuarantinedTest{
+		{TestCaseName: "testFlaky()", TestSuiteName: []string{"BullsEyeTests"}, ClassName: "GameTests"},
+		{TestCaseName: "testOSSpecific()", TestSuiteName: []string{"BullsEyeTests"}, ClassName: "GameTests"},
+	}, got)
+	require.NotContains(t, string(content), "reason")
+
+	// The suggestions are valid quarantined tests input
+	parsed, err := testquarantine.ParseQuarantinedTests(string(content))
+	require.NoError(t, err)
+	require.Len(t, parsed, 2)
+}
+
+func Test_quarantinedTestKey(t *testing.T) {
+	xctest := quarantinedTestKey(testquarantine.QuarantinedTest{TestCaseName: "testScore()", TestSuiteName: []string{"BullsEyeTests"}, ClassName: "GameTests"})
+	require.Equal(t, "BullsEyeTests/GameTests/testScore", xctest)
+
+	xctestCase, ok := quarantinedTestOf(TestSuite{}, TestCase{Name: "testScore", ClassName: "BullsEyeTests.GameTests"})
+	require.True(t, ok)
+	require.Equal(t, testquarantine.QuarantinedTest{TestCaseName: "testScore()", TestSuiteName: []string{"BullsEyeTests"}, ClassName: "GameTests"}, xctestCase)
+	require.Equal(t, xctest, quarantinedTestKey(xctestCase))
+
+	parameterizedCase, ok := quarantinedTestOf(TestSuite{}, TestCase{Name: "scoreIsCalculated(points:) (points: 10)", ClassName: "BullsEyeTests.GameTests.ScoreTests"})
+	require.True(t, ok)
+	require.Equal(t, testquarantine.QuarantinedTest{TestCaseName: "scoreIsCalculated(points:)", TestSuiteName: []string{"BullsEyeTests"}, ClassName: "GameTests.ScoreTests"}, parameterizedCase)
+
+	quarantined := testquarantine.QuarantinedTest{TestCaseName: "scoreIsCalculated(points:)", TestSuiteName: []string{"BullsEyeTests"}, ClassName: "GameTests/ScoreTests"}
+	require.Equal(t, quarantinedTestKey(quarantined), quarantinedTestKey(parameterizedCase))
+}
@@ -0,0 +1,326 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+const (
+	htmlReportPathEnvVarKey     = "VDTESTING_HTML_REPORT_PATH"
+	markdownReportPathEnvVarKey = "VDTESTING_MARKDOWN_REPORT_PATH"
+	htmlReportFileName          = "vdtesting_report.html"
+	markdownReportFileName      = "vdtesting_report.md"
+)
+
+// runReport is the data of the HTML and Markdown reports.
+type runReport struct {
+	Success        bool
+	FailedDevices  int
+	Devices        []deviceReport
+	FailingTests   []testCaseReport
+	FlakyTests     []testCaseReport
+	HasTestResults bool
+}
+
+type deviceReport struct {
+	DeviceRunResult
+	DisplayName    string
+	Outcome        string
+	StateDurations []stateDuration
+	FailingTests   []string
+	Assets         []DeviceAsset
+}
+
+type stateDuration struct {
+	State    string
+	Duration string
+}
+
+// testCaseReport is a failing or flaky test case with the devices it failed or flaked on.
+type testCaseReport struct {
+	Name    string
+	Devices []string
+	Message string
+}
+
+// ExportRunReport writes a self-contained HTML report and a Markdown summary of the run results into dir,
+// and exports their paths. Asset links are relative to dir when possible.
+func (e exporter) ExportRunReport(results RunResults, dir string) error {
+	report := e.createRunReport(results, dir)
+
+	var html bytes.Buffer
+	if err := htmlReportTemplate.Execute(&html, report); err != nil {
+		return fmt.Errorf("failed to render HTML report: %w", err)
+	}
+
+	var markdown bytes.Buffer
+	if err := markdownReportTemplate.Execute(&markdown, report); err != nil {
+		return fmt.Errorf("failed to render Markdown report: %w", err)
+	}
+
+	for _, r := range []struct {
+		envVarKey string
+		fileName  string
+		content   []byte
+	}{
+		{envVarKey: htmlReportPathEnvVarKey, fileName: htmlReportFileName, content: html.Bytes()},
+		{envVarKey: markdownReportPathEnvVarKey, fileName: markdownReportFileName, content: markdown.Bytes()},
+	} {
+		pth := filepath.Join(dir, r.fileName)
+		if err := os.WriteFile(pth, r.content, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", r.fileName, err)
+		}
+
+		if err := e.outputExporter.ExportOutput(r.envVarKey, pth); err != nil {
+			return fmt.Errorf("failed to export %s: %w", r.envVarKey, err)
+		}
+		e.logger.Donef("The report path (%s) is exported to the %s environment variable.", pth, r.envVarKey)
+	}
+
+	return nil
+}
+
+// createRunReport collects the report's data, a device with an invalid test results XML is reported without test results.
+func (e exporter) createRunReport(results RunResults, dir string) runReport {
+	report := runReport{Success: results.Success}
+
+	failingTests := map[string]*testCaseReport{}
+	flakyTests := map[string]*testCaseReport{}
+	addTestCase := func(testCases map[string]*testCaseReport, name, device, message string) {
+		testCase, ok := testCases[name]
+		if !ok {
+			testCase = &testCaseReport{Name: name, Message: message}
+			testCases[name] = testCase
+		}
+		testCase.Devices = append(testCase.Devices, device)
+	}
+
+	for _, device := range results.Devices {
+		deviceReport := deviceReport{
+			DeviceRunResult: device,
+			DisplayName:     deviceDisplayName(device),
+			Outcome:         device.Outcome,
+			StateDurations:  sortedStateDurations(device.StateDurations),
+		}
+		if len(device.OutcomeDetails) > 0 {
+			deviceReport.Outcome += " (" + strings.Join(device.OutcomeDetails, ", ") + ")"
+		}
+		if device.Outcome == "failure" || device.Outcome == "inconclusive" || device.Outcome == "skipped" {
+			report.FailedDevices++
+		}
+
+		for _, asset := range device.Assets {
+			if relPth, err := filepath.Rel(dir, asset.Path); err == nil {
+				asset.Path = relPth
+			}
+			deviceReport.Assets = append(deviceReport.Assets, asset)
+		}
+
+		if device.MergedTestResultXMLPath != "" {
+			testReport, err := e.convertTestReport(device.MergedTestResultXMLPath)
+			if err != nil {
+				e.logger.Warnf("%s: failed to convert test report (%s), its test results are not reported: %s", device.Dimension, device.MergedTestResultXMLPath, err)
+				report.Devices = append(report.Devices, deviceReport)
+				continue
+			}
+
+			testCounts := countTestCases(testReport)
+			deviceReport.TestCounts = &testCounts
+			report.HasTestResults = true
+
+			for _, testSuite := range testReport.LeafTestSuites() {
+				for _, testCase := range testSuite.TestCases {
+					name := fullTestCaseName(testSuite, testCase)
+					switch testCaseStatus(testCase) {
+					case testCaseStatusFailed:
+						addTestCase(failingTests, name, deviceReport.DisplayName, testCaseFailureMessage(testCase))
+						deviceReport.FailingTests = append(deviceReport.FailingTests, name)
+					case testCaseStatusFlaky:
+						addTestCase(flakyTests, name, deviceReport.DisplayName, "")
+					}
+				}
+			}
+		}
+
+		report.Devices = append(report.Devices, deviceReport)
+	}
+
+	report.FailingTests = sortedTestCaseReports(failingTests)
+	report.FlakyTests = sortedTestCaseReports(flakyTests)
+
+	return report
+}
+
+func deviceDisplayName(device DeviceRunResult) string {
+	if device.Model == "" {
+		return device.Dimension
+	}
+	return fmt.Sprintf("%s %s %s %s", device.Model, device.OSVersion, device.Locale, device.Orientation)
+}
+
+// stateOrder is the order of the step states in the test matrix's lifecycle.
+var stateOrder = map[string]int{"pending": 0, "inProgress": 1, "complete": 2}
+
+func sortedStateDurations(durations map[string]float64) []stateDuration {
+	var states []string
+	for state := range durations {
+		states = append(states, state)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		orderI, okI := stateOrder[states[i]]
+		orderJ, okJ := stateOrder[states[j]]
+		if okI != okJ {
+			return okI
+		}
+		if orderI != orderJ {
+			return orderI < orderJ
+		}
+		return states[i] < states[j]
+	})
+
+	var stateDurations []stateDuration
+	for _, state := range states {
+		duration := time.Duration(durations[state] * float64(time.Second))
+		stateDurations = append(stateDurations, stateDuration{State: state, Duration: duration.String()})
+	}
+	return stateDurations
+}
+
+// testCaseFailureMessage returns the first line of the test case's failure or error.
+func testCaseFailureMessage(testCase TestCase) string {
+	var message string
+	switch {
+	case testCase.Failure != nil:
+		message = testCase.Failure.Message
+		if message == "" {
+			message = testCase.Failure.Value
+		}
+	case testCase.Error != nil:
+		message = testCase.Error.Message
+		if message == "" {
+			message = testCase.Error.Value
+		}
+	}
+
+	message = strings.TrimSpace(message)
+	if firstLine, _, found := strings.Cut(message, "\n"); found {
+		message = strings.TrimSpace(firstLine)
+	}
+	return message
+}
+
+func sortedTestCaseReports(testCases map[string]*testCaseReport) []testCaseReport {
+	var reports []testCaseReport
+	for _, testCase := range testCases {
+		reports = append(reports, *testCase)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Name < reports[j].Name
+	})
+	return reports
+}
+
+// markdownCell escapes the value to fit in a Markdown table cell.
+func markdownCell(value string) string {
+	value = strings.ReplaceAll(value, "|", `\|`)
+	return strings.ReplaceAll(value, "\n", " ")
+}
+
+var markdownReportTemplate = texttemplate.Must(texttemplate.New("markdown").Funcs(texttemplate.FuncMap{
+	"cell": markdownCell,
+	"join": strings.Join,
+}).Parse(`# iOS Device Testing results
+
+{{if .Success}}✅ The tests passed on every device.{{else}}❌ The tests failed on {{.FailedDevices}} of {{len .Devices}} device(s).{{end}}
+
+| Device | Outcome | Attempts |{{if .HasTestResults}} Tests | Passed | Failed | Flaky | Skipped |{{end}} Time in states |
+| --- | --- | --- |{{if .HasTestResults}} --- | --- | --- | --- | --- |{{end}} --- |
+{{- $hasTestResults := .HasTestResults}}
+{{range .Devices}}| {{cell .DisplayName}} | {{cell .Outcome}} | {{.Attempts}} |{{if $hasTestResults}}{{with .TestCounts}} {{.Total}} | {{.Passed}} | {{.Failed}} | {{.Flaky}} | {{.Skipped}} |{{else}} - | - | - | - | - |{{end}}{{end}} {{range $i, $s := .StateDurations}}{{if $i}}, {{end}}{{$s.State}}: {{$s.Duration}}{{end}} |
+{{end}}
+{{- if .FailingTests}}
+## Failing tests ({{len .FailingTests}})
+
+{{range .FailingTests}}- ` + "`{{.Name}}`" + ` on {{join .Devices ", "}}{{if .Message}}
+  > {{.Message}}{{end}}
+{{end}}{{end}}
+{{- if .FlakyTests}}
+## Flaky tests ({{len .FlakyTests}})
+
+{{range .FlakyTests}}- ` + "`{{.Name}}`" + ` on {{join .Devices ", "}}
+{{end}}{{end}}`))
+
+var htmlReportTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(htmltemplate.FuncMap{
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>iOS Device Testing results</title>
+<style>
+body { font-family: -apple-system, Helvetica, Arial, sans-serif; margin: 2em; color: #2b2b2b; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ddd; padding: 6px 10px; text-align: left; }
+th { background: #f4f4f4; }
+.success { color: #0a8a3a; }
+.failure { color: #c0262d; }
+.inconclusive { color: #b07800; }
+.skipped { color: #2d6bc0; }
+details { margin-bottom: 1em; }
+summary { cursor: pointer; font-weight: bold; }
+pre { background: #f8f8f8; padding: 6px; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>iOS Device Testing results</h1>
+{{if .Success}}<p class="success">The tests passed on every device.</p>{{else}}<p class="failure">The tests failed on {{.FailedDevices}} of {{len .Devices}} device(s).</p>{{end}}
+
+<h2>Devices</h2>
+<table>
+<tr><th>Device</th><th>Outcome</th><th>Attempts</th>{{if .HasTestResults}}<th>Tests</th><th>Passed</th><th>Failed</th><th>Flaky</th><th>Skipped</th>{{end}}</tr>
+{{- $hasTestResults := .HasTestResults}}
+{{range .Devices}}<tr><td><a href="#{{.Dimension}}">{{.DisplayName}}</a></td><td class="{{.DeviceRunResult.Outcome}}">{{.Outcome}}</td><td>{{.Attempts}}</td>{{if $hasTestResults}}{{with .TestCounts}}<td>{{.Total}}</td><td>{{.Passed}}</td><td>{{.Failed}}</td><td>{{.Flaky}}</td><td>{{.Skipped}}</td>{{else}}<td>-</td><td>-</td><td>-</td><td>-</td><td>-</td>{{end}}{{end}}</tr>
+{{end}}</table>
+
+{{if .FailingTests}}<h2>Failing tests ({{len .FailingTests}})</h2>
+<table>
+<tr><th>Test case</th><th>Devices</th><th>Message</th></tr>
+{{range .FailingTests}}<tr><td>{{.Name}}</td><td>{{join .Devices ", "}}</td><td><pre>{{.Message}}</pre></td></tr>
+{{end}}</table>
+{{end}}
+{{- if .FlakyTests}}<h2>Flaky tests ({{len .FlakyTests}})</h2>
+<table>
+<tr><th>Test case</th><th>Devices</th></tr>
+{{range .FlakyTests}}<tr><td>{{.Name}}</td><td>{{join .Devices ", "}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Device details</h2>
+{{range .Devices}}<details id="{{.Dimension}}">
+<summary>{{.DisplayName}}: <span class="{{.DeviceRunResult.Outcome}}">{{.Outcome}}</span></summary>
+<p>Attempts: {{.Attempts}}</p>
+{{if .StateDurations}}<p>Time in states:</p>
+<ul>
+{{range .StateDurations}}<li>{{.State}}: {{.Duration}}</li>
+{{end}}</ul>
+{{end}}
+{{- if .FailingTests}}<p>Failing tests:</p>
+<ul>
+{{range .FailingTests}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}
+{{- if .Assets}}<p>Assets:</p>
+<ul>
+{{range .Assets}}<li>{{.Kind}}: <a href="{{.Path}}">{{.Name}}</a></li>
+{{end}}</ul>
+{{end}}</details>
+{{end}}</body>
+</html>
+`))
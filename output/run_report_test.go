@@ -0,0 +1,110 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/mocks"
+)
+
+func TestExportRunReport(t *testing.T) {
+	_, b, _, _ := runtime.Caller(0)
+	testDataDir := filepath.Join(filepath.Dir(b), "testdata")
+	dir := t.TempDir()
+
+	failingReportPth := filepath.Join(dir, "ipad10-16.6-en-landscape-test_results_merged.xml")
+	require.NoError(t, os.WriteFile(failingReportPth, []byte(`<testsuite name="" tests="2" failures="1">
+  <testcase name="testRandomlyFail" classname="BullsEyeFailingTests.BullsEyeRandomlyFailingTests">
+    <failure>Assertion Failure at BullsEyeFailingTests.swift:127: failed
+    more details</failure>
+  </testcase>
+  <testcase name="testPass" classname="BullsEyeFailingTests.BullsEyeRandomlyFailingTests"/>
+</testsuite>`), 0644))
+
+	results := RunResults{
+		Success: false,
+		Devices: []DeviceRunResult{
+			{
+				Dimension:               "ipad10.16.6.landscape.en",
+				Model:                   "ipad10",
+				OSVersion:               "16.6",
+				Locale:                  "en",
+				Orientation:             "landscape",
+				Outcome:                 "failure",
+				OutcomeDetails:          []string{"Crashed"},
+				Attempts:                1,
+				StateDurations:          map[string]float64{"complete": 0, "pending": 60, "inProgress": 30},
+				Assets:                  []DeviceAsset{{Name: "ipad10-16.6-en-landscape_video.mp4", Kind: "video", Path: filepath.Join(dir, "ipad10-16.6-en-landscape_video.mp4")}},
+				MergedTestResultXMLPath: failingReportPth,
+			},
+			{
+				Dimension:               "iphone8.16.6.portrait.en",
+				Model:                   "iphone8",
+				OSVersion:               "16.6",
+				Locale:                  "en",
+				Orientation:             "portrait",
+				Outcome:                 "success",
+				Attempts:                3,
+				MergedTestResultXMLPath: filepath.Join(testDataDir, "iphone8-16.6-en-portrait-test_results_merged.xml"),
+			},
+			{
+				Dimension:               "iphone13pro.16.6.portrait.en",
+				Model:                   "iphone13pro",
+				OSVersion:               "16.6",
+				Locale:                  "en",
+				Orientation:             "portrait",
+				Outcome:                 "success",
+				Attempts:                1,
+				MergedTestResultXMLPath: writeTestReport(t, dir, "iphone13pro-16.6-en-portrait-test_results_merged.xml", `<testsuite name="BullsEyeTests"`),
+			},
+		},
+	}
+
+	logger := mocks.NewLogger(t)
+	mockOutputExporter := mocks.NewOutputExporter(t)
+	logger.On("Warnf", mock.Anything, "iphone13pro.16.6.portrait.en", mock.Anything, mock.Anything).Return(nil)
+	logger.On("Donef", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockOutputExporter.On("ExportOutput", htmlReportPathEnvVarKey, filepath.Join(dir, htmlReportFileName)).Return(nil)
+	mockOutputExporter.On("ExportOutput", markdownReportPathEnvVarKey, filepath.Join(dir, markdownReportFileName)).Return(nil)
+
+	e := exporter{
+		outputExporter: mockOutputExporter,
+		logger:         logger,
+	}
+
+	err := e.ExportRunReport(results, dir)
+	require.NoError(t, err)
+
+	markdown, err := os.ReadFile(filepath.Join(dir, markdownReportFileName))
+	require.NoError(t, err)
+	require.Equal(t, `# iOS Device Testing results
+
+❌ The tests failed on 1 of 3 device(s).
+
+| Device | Outcome | Attempts | Tests | Passed | Failed | Flaky | Skipped | Time in states |
+| --- | --- | --- | --- | --- | --- | --- | --- | --- |
+| ipad10 16.6 en landscape | failure (Crashed) | 1 | 2 | 1 | 1 | 0 | 0 | pending: 1m0s, inProgress: 30s, complete: 0s |
+| iphone8 16.6 en portrait | success | 3 | 1 | 0 | 0 | 1 | 0 |  |
+| iphone13pro 16.6 en portrait | success | 1 | - | - | - | - | - |  |
+
+## Failing tests (1)
+
+- `+"`BullsEyeFailingTests.BullsEyeRandomlyFailingTests.testRandomlyFail`"+` on ipad10 16.6 en landscape
+  > Assertion Failure at BullsEyeFailingTests.swift:127: failed
+
+## Flaky tests (1)
+
+- `+"`BullsEyeFailingTests.BullsEyeRandomlyFailingTests.testRandomlyFail`"+` on iphone8 16.6 en portrait
+`, string(markdown))
+
+	html, err := os.ReadFile(filepath.Join(dir, htmlReportFileName))
+	require.NoError(t, err)
+	require.Contains(t, string(html), `<a href="ipad10-16.6-en-landscape_video.mp4">ipad10-16.6-en-landscape_video.mp4</a>`)
+	require.Contains(t, string(html), `<td class="failure">failure (Crashed)</td>`)
+	require.Contains(t, string(html), `<li>pending: 1m0s</li>`)
+}
@@ -0,0 +1,150 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	runResultsEnvVarKey   = "VDTESTING_RESULTS_JSON"
+	runResultsFileName    = "vdtesting_results.json"
+	testCaseStatusPassed  = "passed"
+	testCaseStatusFailed  = "failed"
+	testCaseStatusFlaky   = "flaky"
+	testCaseStatusSkipped = "skipped"
+)
+
+// RunResults is the machine-readable summary of the test run.
+type RunResults struct {
+	// Success is true if the test run succeeded according to the success policy, by default if the tests succeeded on every device.
+	Success bool              `json:"success"`
+	Devices []DeviceRunResult `json:"devices"`
+}
+
+// DeviceRunResult is the result of a device dimension's test run.
+type DeviceRunResult struct {
+	// Dimension is the device dimension's ID: <model>.<os version>.<orientation>.<locale>
+	Dimension   string `json:"dimension"`
+	Model       string `json:"model"`
+	OSVersion   string `json:"os_version"`
+	Locale      string `json:"locale"`
+	Orientation string `json:"orientation"`
+	// Outcome is the test run's outcome summary: success, failure, inconclusive, skipped or unset.
+	Outcome string `json:"outcome"`
+	// OutcomeDetails are the set flags of the outcome's failure, inconclusive or skipped detail (Crashed, TimedOut, InfrastructureFailure, ...).
+	OutcomeDetails []string `json:"outcome_details"`
+	// Attempts is the number of test runs on the device, including reruns.
+	Attempts int `json:"attempts"`
+	// StateDurations is the time spent in each step state, in seconds.
+	StateDurations map[string]float64 `json:"state_durations"`
+	// QueueTime is the time spent in the pending state, in seconds.
+	QueueTime float64 `json:"queue_time"`
+	// RunTime is the time spent in the inProgress state, in seconds.
+	RunTime float64 `json:"run_time"`
+	// StateTransitions are the states of the device's test runs in the order they were seen, re-entries included.
+	StateTransitions []StateTransition `json:"state_transitions,omitempty"`
+	// TestCounts is only available if the device's merged test results XML is downloaded and valid.
+	TestCounts *TestCounts `json:"test_counts,omitempty"`
+	// Assets are the device's downloaded videos, logs, crash reports and xcresult bundles.
+	Assets []DeviceAsset `json:"assets,omitempty"`
+
+	MergedTestResultXMLPath string `json:"-"`
+}
+
+// StateTransition is a test run's stay in a state.
+type StateTransition struct {
+	// TestRun is the 1-based index of the device's test run (attempt).
+	TestRun int    `json:"test_run"`
+	State   string `json:"state"`
+	// Duration is the time spent in the state, in seconds.
+	Duration float64 `json:"duration"`
+}
+
+// DeviceAsset is a downloaded test asset of a device.
+type DeviceAsset struct {
+	Name string `json:"name"`
+	// Kind is one of video, log, crash or xcresult.
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+}
+
+// TestCounts counts the test cases by their final status, flaky test cases are not counted as passed.
+type TestCounts struct {
+	Total   int `json:"total"`
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"`
+	Flaky   int `json:"flaky"`
+	Skipped int `json:"skipped"`
+}
+
+// ExportRunResults writes the run results as JSON into dir, with the devices' test counts filled from their
+// merged test results XML, and exports the file's path. A device with an invalid test results XML has no test counts.
+func (e exporter) ExportRunResults(results RunResults, dir string) error {
+	for i, device := range results.Devices {
+		if device.MergedTestResultXMLPath == "" {
+			continue
+		}
+
+		testReport, err := e.convertTestReport(device.MergedTestResultXMLPath)
+		if err != nil {
+			e.logger.Warnf("%s: failed to convert test report (%s), its test counts are not available: %s", device.Dimension, device.MergedTestResultXMLPath, err)
+			continue
+		}
+
+		testCounts := countTestCases(testReport)
+		results.Devices[i].TestCounts = &testCounts
+	}
+
+	content, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run results: %w", err)
+	}
+
+	pth := filepath.Join(dir, runResultsFileName)
+	if err := os.WriteFile(pth, content, 0644); err != nil {
+		return fmt.Errorf("failed to write run results: %w", err)
+	}
+
+	if err := e.outputExporter.ExportOutput(runResultsEnvVarKey, pth); err != nil {
+		return fmt.Errorf("failed to export %s: %w", runResultsEnvVarKey, err)
+	}
+	e.logger.Donef("The run results path (%s) is exported to the %s environment variable.", pth, runResultsEnvVarKey)
+
+	return nil
+}
+
+func countTestCases(testReport TestSuites) TestCounts {
+	var counts TestCounts
+	for _, testSuite := range testReport.LeafTestSuites() {
+		for _, testCase := range testSuite.TestCases {
+			counts.Total++
+			switch testCaseStatus(testCase) {
+			case testCaseStatusFlaky:
+				counts.Flaky++
+			case testCaseStatusFailed:
+				counts.Failed++
+			case testCaseStatusSkipped:
+				counts.Skipped++
+			default:
+				counts.Passed++
+			}
+		}
+	}
+	return counts
+}
+
+// testCaseStatus returns the test case's final status, a flaky test case keeps its last failure in the merged report.
+func testCaseStatus(testCase TestCase) string {
+	switch {
+	case testCase.IsFlaky():
+		return testCaseStatusFlaky
+	case testCase.Failure != nil || testCase.Error != nil:
+		return testCaseStatusFailed
+	case testCase.Skipped != nil:
+		return testCaseStatusSkipped
+	default:
+		return testCaseStatusPassed
+	}
+}
@@ -0,0 +1,88 @@
+package output
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/mocks"
+)
+
+func TestExportRunResults(t *testing.T) {
+	_, b, _, _ := runtime.Caller(0)
+	testDataDir := filepath.Join(filepath.Dir(b), "testdata")
+	dir := t.TempDir()
+	results := RunResults{
+		Success: true,
+		Devices: []DeviceRunResult{
+			{
+				Dimension:               "iphone8.16.6.portrait.en",
+				Outcome:                 "success",
+				Attempts:                3,
+				StateDurations:          map[string]float64{"pending": 60, "inProgress": 30},
+				MergedTestResultXMLPath: filepath.Join(testDataDir, "iphone8-16.6-en-portrait-test_results_merged.xml"),
+			},
+			{
+				Dimension: "ipad10.16.6.landscape.en",
+				Outcome:   "success",
+				Attempts:  1,
+			},
+			{
+				Dimension:               "iphone13pro.16.6.portrait.en",
+				Outcome:                 "success",
+				Attempts:                1,
+				MergedTestResultXMLPath: writeTestReport(t, dir, "iphone13pro.xml", `<testsuite name="BullsEyeTests"`),
+			},
+		},
+	}
+	wantPth := filepath.Join(dir, runResultsFileName)
+
+	logger := mocks.NewLogger(t)
+	mockOutputExporter := mocks.NewOutputExporter(t)
+	logger.On("Warnf", mock.Anything, "iphone13pro.16.6.portrait.en", mock.Anything, mock.Anything).Return(nil)
+	logger.On("Donef", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockOutputExporter.On("ExportOutput", runResultsEnvVarKey, wantPth).Return(nil)
+
+	e := exporter{
+		outputExporter: mockOutputExporter,
+		logger:         logger,
+	}
+
+	err := e.ExportRunResults(results, dir)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(wantPth)
+	require.NoError(t, err)
+
+	var got RunResults
+	require.NoError(t, json.Unmarshal(content, &got))
+	require.True(t, got.Success)
+	require.Len(t, got.Devices, 3)
+	require.Equal(t, &TestCounts{Total: 1, Flaky: 1}, got.Devices[0].TestCounts)
+	require.Equal(t, map[string]float64{"pending": 60, "inProgress": 30}, got.Devices[0].StateDurations)
+	require.Nil(t, got.Devices[1].TestCounts)
+	require.Nil(t, got.Devices[2].TestCounts)
+}
+
+func Test_countTestCases(t *testing.T) {
+	report := TestSuites{
+		TestSuites: []TestSuite{
+			{
+				TestCases: []TestCase{
+					{Name: "passed"},
+					{Name: "failed", Failure: &Failure{}},
+					{Name: "errored", Error: &Error{}},
+					{Name: "skipped", Skipped: &Skipped{}},
+					{Name: "flaky", Flaky: "true", Failure: &Failure{}},
+				},
+			},
+		},
+	}
+
+	require.Equal(t, TestCounts{Total: 5, Passed: 1, Failed: 2, Flaky: 1, Skipped: 1}, countTestCases(report))
+}
@@ -0,0 +1,55 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	testInventoryPathEnvVarKey = "VDTESTING_TEST_INVENTORY_PATH"
+	testInventoryFileName      = "test_inventory.json"
+)
+
+// TestInventory lists the test bundle's tests, before running them.
+type TestInventory struct {
+	TestCount int                   `json:"test_count"`
+	Targets   []TestInventoryTarget `json:"targets"`
+}
+
+// TestInventoryTarget is a test target (BlueprintName) of the test bundle's xctestrun files.
+type TestInventoryTarget struct {
+	Name string `json:"name"`
+	// SkippedTests are the test target's SkipTestIdentifiers in the test bundle's xctestrun files.
+	SkippedTests []string             `json:"skipped_tests,omitempty"`
+	Classes      []TestInventoryClass `json:"classes"`
+	// SwiftTestingSuites are the Swift Testing suites with their test functions, module level functions are in a suite without name.
+	SwiftTestingSuites []TestInventoryClass `json:"swift_testing_suites,omitempty"`
+}
+
+// TestInventoryClass is a test class with its test methods, or a Swift Testing suite with its test functions.
+type TestInventoryClass struct {
+	Name    string   `json:"name"`
+	Methods []string `json:"methods"`
+}
+
+// ExportTestInventory writes the test inventory into dir and exports its path.
+func (e exporter) ExportTestInventory(inventory TestInventory, dir string) error {
+	content, err := json.MarshalIndent(inventory, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal test inventory: %w", err)
+	}
+
+	pth := filepath.Join(dir, testInventoryFileName)
+	if err := os.WriteFile(pth, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", testInventoryFileName, err)
+	}
+
+	if err := e.outputExporter.ExportOutput(testInventoryPathEnvVarKey, pth); err != nil {
+		return fmt.Errorf("failed to export %s: %w", testInventoryPathEnvVarKey, err)
+	}
+	e.logger.Donef("The test inventory path (%s) is exported to the %s environment variable.", pth, testInventoryPathEnvVarKey)
+
+	return nil
+}
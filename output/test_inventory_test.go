@@ -0,0 +1,48 @@
+package output
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/mocks"
+)
+
+func TestExportTestInventory(t *testing.T) {
+	dir := t.TempDir()
+	inventory := TestInventory{
+		TestCount: 2,
+		Targets: []TestInventoryTarget{
+			{
+				Name:         "BullsEyeTests",
+				SkippedTests: []string{"BullsEyeSlowTests"},
+				Classes:      []TestInventoryClass{{Name: "GameTests", Methods: []string{"testScore", "testStart"}}},
+			},
+		},
+	}
+	wantPth := filepath.Join(dir, testInventoryFileName)
+
+	logger := mocks.NewLogger(t)
+	mockOutputExporter := mocks.NewOutputExporter(t)
+	logger.On("Donef", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockOutputExporter.On("ExportOutput", testInventoryPathEnvVarKey, wantPth).Return(nil)
+
+	e := exporter{
+		outputExporter: mockOutputExporter,
+		logger:         logger,
+	}
+
+	err := e.ExportTestInventory(inventory, dir)
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(wantPth)
+	require.NoError(t, err)
+
+	var got TestInventory
+	require.NoError(t, json.Unmarshal(content, &got))
+	require.Equal(t, inventory, got)
+}
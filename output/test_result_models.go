@@ -4,17 +4,47 @@ import (
 	"encoding/xml"
 )
 
+// TestSuites ...
+type TestSuites struct {
+	XMLName    xml.Name    `xml:"testsuites"`
+	Name       string      `xml:"name,attr,omitempty"`
+	Tests      int         `xml:"tests,attr"`
+	Failures   int         `xml:"failures,attr"`
+	Flakes     int         `xml:"flakes,attr"`
+	Errors     int         `xml:"errors,attr"`
+	Skipped    int         `xml:"skipped,attr"`
+	Time       float64     `xml:"time,attr"`
+	TestSuites []TestSuite `xml:"testsuite"`
+}
+
 // TestSuite ...
 type TestSuite struct {
-	XMLName   xml.Name   `xml:"testsuite"`
-	Name      string     `xml:"name,attr"`
-	Tests     int        `xml:"tests,attr"`
-	Failures  int        `xml:"failures,attr"`
-	Flakes    int        `xml:"flakes,attr"`
-	Errors    int        `xml:"errors,attr"`
-	Skipped   int        `xml:"skipped,attr"`
-	Time      float64    `xml:"time,attr"`
-	TestCases []TestCase `xml:"testcase"`
+	XMLName    xml.Name    `xml:"testsuite"`
+	Name       string      `xml:"name,attr"`
+	Tests      int         `xml:"tests,attr"`
+	Failures   int         `xml:"failures,attr"`
+	Flakes     int         `xml:"flakes,attr"`
+	Errors     int         `xml:"errors,attr"`
+	Skipped    int         `xml:"skipped,attr"`
+	Time       float64     `xml:"time,attr"`
+	Properties *Properties `xml:"properties,omitempty"`
+	TestCases  []TestCase  `xml:"testcase"`
+	TestSuites []TestSuite `xml:"testsuite"`
+	SystemOut  string      `xml:"system-out,omitempty"`
+	SystemErr  string      `xml:"system-err,omitempty"`
+}
+
+// Properties ...
+type Properties struct {
+	XMLName  xml.Name   `xml:"properties"`
+	Property []Property `xml:"property"`
+}
+
+// Property ...
+type Property struct {
+	XMLName xml.Name `xml:"property"`
+	Name    string   `xml:"name,attr"`
+	Value   string   `xml:"value,attr"`
 }
 
 // TestCase ...
@@ -23,28 +53,78 @@ type TestCase struct {
 	Name      string   `xml:"name,attr"`
 	ClassName string   `xml:"classname,attr"`
 	Time      float64  `xml:"time,attr"`
-	Flaky     string   `xml:"flaky,attr"`
+	Flaky     string   `xml:"flaky,attr,omitempty"`
 
 	Failure *Failure `xml:"failure,omitempty"`
 	Skipped *Skipped `xml:"skipped,omitempty"`
 	Error   *Error   `xml:"error,omitempty"`
+
+	// Rerun elements (Maven Surefire format): failed attempts of a test which passed eventually (flaky)
+	// or failed in every attempt (rerun).
+	FlakyFailures []RerunResult `xml:"flakyFailure,omitempty"`
+	FlakyErrors   []RerunResult `xml:"flakyError,omitempty"`
+	RerunFailures []RerunResult `xml:"rerunFailure,omitempty"`
+	RerunErrors   []RerunResult `xml:"rerunError,omitempty"`
+
+	SystemOut string `xml:"system-out,omitempty"`
+	SystemErr string `xml:"system-err,omitempty"`
 }
 
 // Failure ...
 type Failure struct {
 	XMLName xml.Name `xml:"failure,omitempty"`
 	Message string   `xml:"message,attr,omitempty"`
+	Type    string   `xml:"type,attr,omitempty"`
 	Value   string   `xml:",chardata"`
 }
 
 // Skipped ...
 type Skipped struct {
 	XMLName xml.Name `xml:"skipped,omitempty"`
+	Message string   `xml:"message,attr,omitempty"`
 }
 
 // Error ...
 type Error struct {
 	XMLName xml.Name `xml:"error,omitempty"`
 	Message string   `xml:"message,attr,omitempty"`
+	Type    string   `xml:"type,attr,omitempty"`
 	Value   string   `xml:",chardata"`
 }
+
+// RerunResult ...
+type RerunResult struct {
+	Message    string `xml:"message,attr,omitempty"`
+	Type       string `xml:"type,attr,omitempty"`
+	StackTrace string `xml:"stackTrace,omitempty"`
+	SystemOut  string `xml:"system-out,omitempty"`
+	SystemErr  string `xml:"system-err,omitempty"`
+	Value      string `xml:",chardata"`
+}
+
+// IsFlaky returns true if the test case failed at least once, but passed at least once as well.
+func (c TestCase) IsFlaky() bool {
+	return c.Flaky == "true" || len(c.FlakyFailures) > 0 || len(c.FlakyErrors) > 0
+}
+
+// LeafTestSuites returns the test suites which contain the test cases, nested suites are flattened.
+func (s TestSuites) LeafTestSuites() []TestSuite {
+	var leafs []TestSuite
+	for _, testSuite := range s.TestSuites {
+		leafs = append(leafs, testSuite.leafTestSuites()...)
+	}
+	return leafs
+}
+
+func (s TestSuite) leafTestSuites() []TestSuite {
+	var leafs []TestSuite
+	if len(s.TestCases) > 0 || len(s.TestSuites) == 0 {
+		leaf := s
+		leaf.TestSuites = nil
+		leafs = append(leafs, leaf)
+	}
+	for _, nested := range s.TestSuites {
+		leafs = append(leafs, nested.leafTestSuites()...)
+	}
+	return leafs
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+)
+
+const progressHeartbeatInterval = time.Minute
+
+/*
+progressReporter reports the devices' test runs while waiting for the test results. On a TTY it redraws a table of
+the test runs on every poll, otherwise it prints a line per state transition and a heartbeat line every minute.
+
+The reporter tracks every step it has seen, the completed steps are the history the ETA is derived from.
+*/
+type progressReporter struct {
+	w         io.Writer
+	tty       bool
+	startTime time.Time
+
+	stepIDToStepStates map[string]stepStates
+	lastHeartbeat      time.Time
+	// redrawnLines is the number of lines of the last redrawn table.
+	redrawnLines int
+}
+
+func newProgressReporter(w io.Writer, tty bool, startTime time.Time) *progressReporter {
+	return &progressReporter{
+		w:                  w,
+		tty:                tty,
+		startTime:          startTime,
+		stepIDToStepStates: map[string]stepStates{},
+		lastHeartbeat:      startTime,
+	}
+}
+
+// keepTable starts a new table on the next redraw, keeping the previous one above the lines logged since.
+func (r *progressReporter) keepTable() {
+	r.redrawnLines = 0
+}
+
+// isTerminal reports whether the file is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fileInfo.Mode()&os.ModeCharDevice != 0
+}
+
+// update reports the polled steps.
+func (r *progressReporter) update(steps []*toolresults.Step, currentTime time.Time) {
+	var err error
+	if r.tty {
+		err = r.redraw(steps, currentTime)
+	} else {
+		err = r.printTransitions(steps, currentTime)
+	}
+	if err != nil {
+		fmt.Printf("Failed to print test progress: %s\n", err)
+	}
+}
+
+// printTransitions prints the steps' state changes since the last poll, and a heartbeat line if it is due.
+func (r *progressReporter) printTransitions(steps []*toolresults.Step, currentTime time.Time) error {
+	names := r.stepNames(steps)
+	for _, step := range steps {
+		states, ok := r.stepIDToStepStates[step.StepId]
+		previousState, previousDuration := states.currentState(currentTime)
+		if !ok {
+			states = newStepStates(*step)
+		}
+		states.saveState(step.State, currentTime)
+		r.stepIDToStepStates[step.StepId] = states
+
+		if ok && previousState == step.State {
+			continue
+		}
+
+		line := fmt.Sprintf("- [%s] %s: %s", r.elapsed(currentTime), names[step.StepId], stepStateText(step))
+		if ok && previousState != "" {
+			line += fmt.Sprintf(" (%s for %s)", stepStateName(previousState), previousDuration.Round(time.Second))
+		}
+		if _, err := fmt.Fprintln(r.w, line); err != nil {
+			return err
+		}
+	}
+
+	if currentTime.Sub(r.lastHeartbeat) < progressHeartbeatInterval {
+		return nil
+	}
+	r.lastHeartbeat = currentTime
+
+	_, err := fmt.Fprintf(r.w, "- [%s] %s\n", r.elapsed(currentTime), r.summary(steps, currentTime))
+	return err
+}
+
+// redraw replaces the previously drawn table with the steps' current state.
+func (r *progressReporter) redraw(steps []*toolresults.Step, currentTime time.Time) error {
+	updateStepsStates(r.stepIDToStepStates, toolresults.ListStepsResponse{Steps: steps}, currentTime)
+
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 0, 3, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "Device\tState\tTime in state\tOutcome\t"); err != nil {
+		return err
+	}
+
+	names := r.stepNames(steps)
+	for _, step := range steps {
+		states := r.stepIDToStepStates[step.StepId]
+		_, duration := states.currentState(currentTime)
+		row := fmt.Sprintf("%s\t%s\t%s\t%s\t", names[step.StepId], stepStateName(step.State), duration.Round(time.Second), formatOutcome(step.Outcome))
+		if _, err := fmt.Fprintln(tw, row); err != nil {
+			return err
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	b.WriteString(fmt.Sprintf("Elapsed: %s, %s\n", r.elapsed(currentTime), r.summary(steps, currentTime)))
+
+	if r.redrawnLines > 0 {
+		// Move the cursor to the start of the previous table and clear the rest of the screen
+		if _, err := fmt.Fprintf(r.w, "\033[%dA\033[J", r.redrawnLines); err != nil {
+			return err
+		}
+	}
+	r.redrawnLines = strings.Count(b.String(), "\n")
+
+	_, err := io.WriteString(r.w, b.String())
+	return err
+}
+
+// summary counts the steps by state and estimates the remaining time, for example: 1 queued, 2 running, 3/6 complete, ETA ~4m0s
+func (r *progressReporter) summary(steps []*toolresults.Step, currentTime time.Time) string {
+	stateToCount := map[string]int{}
+	for _, step := range steps {
+		stateToCount[step.State]++
+	}
+
+	summary := fmt.Sprintf("%d queued, %d running, %d/%d complete", stateToCount[stepStatePending], stateToCount[stepStateInProgress], stateToCount[stepStateComplete], len(steps))
+	if stateToCount[stepStateComplete] == len(steps) {
+		return summary
+	}
+
+	if eta, ok := r.eta(steps, currentTime); ok {
+		return summary + fmt.Sprintf(", ETA ~%s", eta.Round(time.Second))
+	}
+	return summary + ", ETA unknown until a test run completes"
+}
+
+/*
+eta estimates the time until every step completes. A step is expected to take (queued and running) as long as the
+completed steps of the same device on average, or of the same model, or of any device, in this order.
+ok is false if no step completed yet.
+*/
+func (r *progressReporter) eta(steps []*toolresults.Step, currentTime time.Time) (time.Duration, bool) {
+	deviceHistory := map[string][]time.Duration{}
+	modelHistory := map[string][]time.Duration{}
+	var history []time.Duration
+	for _, states := range r.stepIDToStepStates {
+		state, _ := states.currentState(currentTime)
+		if state != stepStateComplete {
+			continue
+		}
+
+		duration := r.activeDuration(states, currentTime)
+		deviceHistory[states.deviceName] = append(deviceHistory[states.deviceName], duration)
+		modelHistory[states.model] = append(modelHistory[states.model], duration)
+		history = append(history, duration)
+	}
+	if len(history) == 0 {
+		return 0, false
+	}
+
+	var eta time.Duration
+	for _, step := range steps {
+		states, ok := r.stepIDToStepStates[step.StepId]
+		if !ok || step.State == stepStateComplete {
+			continue
+		}
+
+		expected := averageDuration(history)
+		if durations, ok := deviceHistory[states.deviceName]; ok {
+			expected = averageDuration(durations)
+		} else if durations, ok := modelHistory[states.model]; ok {
+			expected = averageDuration(durations)
+		}
+
+		if remaining := expected - r.activeDuration(states, currentTime); remaining > eta {
+			eta = remaining
+		}
+	}
+	return eta, true
+}
+
+// activeDuration returns the time the step spent in any state but complete.
+func (r *progressReporter) activeDuration(states stepStates, currentTime time.Time) time.Duration {
+	var duration time.Duration
+	for state, d := range states.durations(currentTime) {
+		if state != stepStateComplete {
+			duration += d
+		}
+	}
+	return duration
+}
+
+// stepNames names the steps after their device, a device's further test runs are numbered: iphone8-16.6-en-portrait #2
+func (r *progressReporter) stepNames(steps []*toolresults.Step) map[string]string {
+	deviceToCount := map[string]int{}
+	names := map[string]string{}
+	for _, step := range steps {
+		name := stepAssetName(step)
+		deviceToCount[name]++
+		if deviceToCount[name] > 1 {
+			names[step.StepId] = fmt.Sprintf("%s #%d", name, deviceToCount[name])
+		} else {
+			names[step.StepId] = name
+		}
+	}
+	return names
+}
+
+func (r *progressReporter) elapsed(currentTime time.Time) time.Duration {
+	return currentTime.Sub(r.startTime).Round(time.Second)
+}
+
+// stepStateText describes the step's state, with the outcome of a complete step: complete, failure(Crashed)
+func stepStateText(step *toolresults.Step) string {
+	if step.State == stepStateComplete && step.Outcome != nil {
+		return stepStateName(step.State) + ", " + outcomeText(step.Outcome)
+	}
+	return stepStateName(step.State)
+}
+
+// stepStateName names the step states the way the progress report refers to them.
+func stepStateName(state string) string {
+	switch state {
+	case stepStatePending:
+		return "queued"
+	case stepStateInProgress:
+		return "running"
+	default:
+		return state
+	}
+}
+
+func averageDuration(durations []time.Duration) time.Duration {
+	var sum time.Duration
+	for _, duration := range durations {
+		sum += duration
+	}
+	return sum / time.Duration(len(durations))
+}
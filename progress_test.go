@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+)
+
+func testStepInState(stepID, model, state string, outcome *toolresults.Outcome) *toolresults.Step {
+	step := testStep(stepID, model, outcome)
+	step.State = state
+	return step
+}
+
+func Test_progressReporter_printTransitions(t *testing.T) {
+	var b bytes.Buffer
+	reporter := newProgressReporter(&b, false, testRefTime())
+
+	reporter.update([]*toolresults.Step{
+		testStepInState("1", "iphone8", "pending", nil),
+		testStepInState("2", "iphone13pro", "pending", nil),
+	}, testRefTime().Add(10*time.Second))
+	reporter.update([]*toolresults.Step{
+		testStepInState("1", "iphone8", "inProgress", nil),
+		testStepInState("2", "iphone13pro", "inProgress", nil),
+	}, testRefTime().Add(40*time.Second))
+	reporter.update([]*toolresults.Step{
+		testStepInState("1", "iphone8", "complete", &toolresults.Outcome{Summary: "success"}),
+		testStepInState("2", "iphone13pro", "inProgress", nil),
+	}, testRefTime().Add(100*time.Second))
+
+	require.Equal(t, `- [10s] iphone8-16.6-en-portrait: queued
+- [10s] iphone13pro-16.6-en-portrait: queued
+- [40s] iphone8-16.6-en-portrait: running (queued for 30s)
+- [40s] iphone13pro-16.6-en-portrait: running (queued for 30s)
+- [1m40s] iphone8-16.6-en-portrait: complete, success (running for 1m0s)
+- [1m40s] 0 queued, 1 running, 1/2 complete, ETA ~0s
+`, b.String())
+}
+
+func Test_progressReporter_eta(t *testing.T) {
+	reporter := newProgressReporter(&bytes.Buffer{}, false, testRefTime())
+	reporter.stepIDToStepStates = map[string]stepStates{
+		// A completed iphone8 test run: 5m
+		"1": {deviceName: "iphone8-16.6-en-portrait", model: "iphone8", transitions: []stateTransition{
+			{state: "pending", startTime: testRefTime()},
+			{state: "inProgress", startTime: testRefTime().Add(1 * time.Minute)},
+			{state: "complete", startTime: testRefTime().Add(5 * time.Minute)},
+		}},
+		// An ipad10 test run: 3m
+		"2": {deviceName: "ipad10-16.6-en-portrait", model: "ipad10", transitions: []stateTransition{
+			{state: "pending", startTime: testRefTime()},
+			{state: "complete", startTime: testRefTime().Add(3 * time.Minute)},
+		}},
+		"3": {deviceName: "iphone8-16.6-en-portrait", model: "iphone8", transitions: []stateTransition{
+			{state: "pending", startTime: testRefTime().Add(5 * time.Minute)},
+		}},
+		"4": {deviceName: "iphone13pro-16.6-en-portrait", model: "iphone13pro", transitions: []stateTransition{
+			{state: "inProgress", startTime: testRefTime().Add(5 * time.Minute)},
+		}},
+	}
+	steps := []*toolresults.Step{
+		testStepInState("3", "iphone8", "pending", nil),
+		testStepInState("4", "iphone13pro", "inProgress", nil),
+	}
+
+	// The iphone8 is expected to take 5m, the iphone13pro (without history) 4m on average
+	eta, ok := reporter.eta(steps, testRefTime().Add(6*time.Minute))
+	require.True(t, ok)
+	require.Equal(t, 4*time.Minute, eta)
+
+	reporter.stepIDToStepStates = map[string]stepStates{"3": reporter.stepIDToStepStates["3"]}
+	_, ok = reporter.eta(steps, testRefTime().Add(6*time.Minute))
+	require.False(t, ok)
+}
+
+func Test_progressReporter_redraw(t *testing.T) {
+	var b bytes.Buffer
+	reporter := newProgressReporter(&b, true, testRefTime())
+
+	reporter.update([]*toolresults.Step{
+		testStepInState("1", "iphone8", "pending", nil),
+		testStepInState("2", "iphone8", "inProgress", nil),
+	}, testRefTime().Add(10*time.Second))
+	reporter.update([]*toolresults.Step{
+		testStepInState("1", "iphone8", "inProgress", nil),
+		testStepInState("2", "iphone8", "inProgress", nil),
+	}, testRefTime().Add(30*time.Second))
+
+	require.Equal(t, `Device                        State     Time in state   Outcome   
+iphone8-16.6-en-portrait      queued    0s                        
+iphone8-16.6-en-portrait #2   running   0s                        
+Elapsed: 10s, 1 queued, 1 running, 0/2 complete, ETA unknown until a test run completes
+`+"\033[4A\033[J"+`Device                        State     Time in state   Outcome   
+iphone8-16.6-en-portrait      running   0s                        
+iphone8-16.6-en-portrait #2   running   20s                       
+Elapsed: 30s, 0 queued, 2 running, 0/2 complete, ETA unknown until a test run completes
+`, b.String())
+}
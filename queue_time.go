@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+)
+
+// queueTimeWatcher finds the steps (test runs) pending longer than the queue time threshold, every step is reported once.
+type queueTimeWatcher struct {
+	// threshold is the time a step may stay pending, 0 disables the watcher.
+	threshold time.Duration
+
+	exceededStepIDs map[string]bool
+}
+
+func newQueueTimeWatcher(threshold time.Duration) *queueTimeWatcher {
+	return &queueTimeWatcher{
+		threshold:       threshold,
+		exceededStepIDs: map[string]bool{},
+	}
+}
+
+// exceededSteps returns the steps which have been pending longer than the threshold since their last entry into the pending state.
+func (w *queueTimeWatcher) exceededSteps(steps []*toolresults.Step, stepIDToStepStates map[string]stepStates, currentTime time.Time) []*toolresults.Step {
+	if w.threshold <= 0 {
+		return nil
+	}
+
+	var exceeded []*toolresults.Step
+	for _, step := range steps {
+		if w.exceededStepIDs[step.StepId] {
+			continue
+		}
+
+		states, ok := stepIDToStepStates[step.StepId]
+		if !ok {
+			continue
+		}
+
+		if state, duration := states.currentState(currentTime); state == stepStatePending && duration > w.threshold {
+			w.exceededStepIDs[step.StepId] = true
+			exceeded = append(exceeded, step)
+		}
+	}
+	return exceeded
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+)
+
+func Test_queueTimeWatcher_exceededSteps(t *testing.T) {
+	steps := []*toolresults.Step{
+		testStep("1", "iphone8", nil),
+		testStep("2", "iphone13pro", nil),
+		testStep("3", "ipad10", nil),
+	}
+	stepIDToStepStates := map[string]stepStates{
+		"1": {transitions: []stateTransition{
+			{state: "pending", startTime: testRefTime()},
+		}},
+		// Re-entered the pending state recently
+		"2": {transitions: []stateTransition{
+			{state: "pending", startTime: testRefTime()},
+			{state: "inProgress", startTime: testRefTime().Add(60 * time.Second)},
+			{state: "pending", startTime: testRefTime().Add(240 * time.Second)},
+		}},
+		"3": {transitions: []stateTransition{
+			{state: "pending", startTime: testRefTime()},
+			{state: "inProgress", startTime: testRefTime().Add(200 * time.Second)},
+		}},
+	}
+
+	watcher := newQueueTimeWatcher(180 * time.Second)
+	require.Equal(t, []*toolresults.Step{steps[0]}, watcher.exceededSteps(steps, stepIDToStepStates, testRefTime().Add(300*time.Second)))
+	// A step is reported once
+	require.Equal(t, []*toolresults.Step{steps[1]}, watcher.exceededSteps(steps, stepIDToStepStates, testRefTime().Add(500*time.Second)))
+
+	disabled := newQueueTimeWatcher(0)
+	require.Empty(t, disabled.exceededSteps(steps, stepIDToStepStates, testRefTime().Add(500*time.Second)))
+}
@@ -4,30 +4,49 @@ import (
 	"fmt"
 	"io"
 	"sort"
+	"text/tabwriter"
 	"time"
 
+	"github.com/bitrise-io/go-utils/sliceutil"
 	toolresults "google.golang.org/api/toolresults/v1beta3"
 )
 
+const (
+	stepStatePending    = "pending"
+	stepStateInProgress = "inProgress"
+	stepStateComplete   = "complete"
+)
+
+// stateTransition is a step's entry into a state, seen when polling the step.
+type stateTransition struct {
+	state     string
+	startTime time.Time
+}
+
+// stepStates records the state transitions of a step, a state can be entered multiple times.
 type stepStates struct {
-	name             string
-	stateToStartTime map[string]time.Time
+	name string
+	// deviceName is the step's device in the test assets' naming format, model is the device's model.
+	deviceName  string
+	model       string
+	transitions []stateTransition
 }
 
 func newStepStates(step toolresults.Step) stepStates {
 	return stepStates{
-		name:             createStepNameWithDimensions(step),
-		stateToStartTime: map[string]time.Time{},
+		name:       createStepNameWithDimensions(step),
+		deviceName: stepAssetName(&step),
+		model:      createDimensions(step)["Model"],
 	}
 }
 
 func (s *stepStates) saveState(state string, startTime time.Time) {
-	if _, ok := s.stateToStartTime[state]; ok {
+	if len(s.transitions) > 0 && s.transitions[len(s.transitions)-1].state == state {
 		return
 	}
 
-	// Haven't seen this state yet -> set state start time
-	s.stateToStartTime[state] = startTime
+	// The state changed since the last poll -> set state start time
+	s.transitions = append(s.transitions, stateTransition{state: state, startTime: startTime})
 }
 
 func (s *stepStates) print(currentTime time.Time, w io.Writer) {
@@ -36,36 +55,68 @@ func (s *stepStates) print(currentTime time.Time, w io.Writer) {
 		return
 	}
 
-	var states []string
-	for state := range s.stateToStartTime {
-		states = append(states, state)
+	durations := s.durations(currentTime)
+	entries := s.entries()
+	for _, state := range s.sortedStates() {
+		line := fmt.Sprintf("- time spent in %s state: ~%s", state, durations[state].Round(time.Second).String())
+		if entries[state] > 1 {
+			line += fmt.Sprintf(" (entered %d times)", entries[state])
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			fmt.Printf("Failed to print step status durations: %s", err)
+			return
+		}
 	}
+}
 
-	sort.Slice(states, func(i, j int) bool {
-		stateI, stateJ := states[i], states[j]
-		startTimeI, startTimeJ := s.stateToStartTime[stateI], s.stateToStartTime[stateJ]
+// durations returns the time spent in each state summed up over its entries, the last state lasts until currentTime.
+func (s *stepStates) durations(currentTime time.Time) map[string]time.Duration {
+	durations := map[string]time.Duration{}
+	for i, transition := range s.transitions {
+		durations[transition.state] += s.transitionDuration(i, currentTime)
+	}
 
-		return startTimeI.Before(startTimeJ)
-	})
+	return durations
+}
 
-	for i, state := range states {
-		startTime := s.stateToStartTime[state]
+// transitionDuration returns the time spent in the state entered by the i-th transition, until the next transition or currentTime.
+func (s *stepStates) transitionDuration(i int, currentTime time.Time) time.Duration {
+	endTime := currentTime
+	if i < len(s.transitions)-1 {
+		endTime = s.transitions[i+1].startTime
+	}
+	return endTime.Sub(s.transitions[i].startTime)
+}
 
-		var endTime time.Time
-		if i == len(states)-1 {
-			endTime = currentTime
-		} else {
-			nextState := states[i+1]
-			endTime = s.stateToStartTime[nextState]
-		}
+// entries returns the number of times each state was entered.
+func (s *stepStates) entries() map[string]int {
+	entries := map[string]int{}
+	for _, transition := range s.transitions {
+		entries[transition.state]++
+	}
+	return entries
+}
 
-		duration := endTime.Sub(startTime)
+// currentState returns the last seen state and the time spent in it since its last entry.
+func (s *stepStates) currentState(currentTime time.Time) (string, time.Duration) {
+	if len(s.transitions) == 0 {
+		return "", 0
+	}
 
-		if _, err := fmt.Fprintf(w, "- time spent in %s state: ~%s\n", state, duration.Round(time.Second).String()); err != nil {
-			fmt.Printf("Failed to print step status durations: %s", err)
-			return
+	transition := s.transitions[len(s.transitions)-1]
+	return transition.state, currentTime.Sub(transition.startTime)
+}
+
+// sortedStates returns the seen states in the order of their first entry.
+func (s *stepStates) sortedStates() []string {
+	var states []string
+	for _, transition := range s.transitions {
+		if !sliceutil.IsStringInSlice(transition.state, states) {
+			states = append(states, transition.state)
 		}
 	}
+	return states
 }
 
 func createStepNameWithDimensions(step toolresults.Step) string {
@@ -73,15 +124,15 @@ func createStepNameWithDimensions(step toolresults.Step) string {
 	return fmt.Sprintf("%s (%s %s %s %s)", step.Name, dimensions["Model"], dimensions["Version"], dimensions["Orientation"], dimensions["Locale"])
 }
 
-func updateStepsStates(stepIDtoStates map[string]stepStates, response toolresults.ListStepsResponse) {
+func updateStepsStates(stepIDtoStates map[string]stepStates, response toolresults.ListStepsResponse, currentTime time.Time) {
 	for _, step := range response.Steps {
 		stepStates, ok := stepIDtoStates[step.StepId]
 		if !ok {
 			stepStates = newStepStates(*step)
-			stepIDtoStates[step.StepId] = stepStates
 		}
 
-		stepStates.saveState(step.State, time.Now())
+		stepStates.saveState(step.State, currentTime)
+		stepIDtoStates[step.StepId] = stepStates
 	}
 }
 
@@ -98,3 +149,39 @@ func printStepsStates(stepIDtoStates map[string]stepStates, currentTime time.Tim
 		stepState.print(currentTime, w)
 	}
 }
+
+/*
+deviceStateDurations sums up the time spent in each state over the device's steps (test runs), including the steps'
+re-entries into a state.
+*/
+func deviceStateDurations(deviceResult deviceResult, stepIDToStepStates map[string]stepStates, currentTime time.Time) map[string]time.Duration {
+	durations := map[string]time.Duration{}
+	for _, step := range deviceResult.steps {
+		states, ok := stepIDToStepStates[step.StepId]
+		if !ok {
+			continue
+		}
+		for state, duration := range states.durations(currentTime) {
+			durations[state] += duration
+		}
+	}
+	return durations
+}
+
+// printQueueTimes prints the time each device spent queued (pending) versus running (inProgress) as a table.
+func printQueueTimes(deviceResults []deviceResult, stepIDToStepStates map[string]stepStates, currentTime time.Time, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "Device\tQueue time\tRun time\tTest runs\t"); err != nil {
+		return err
+	}
+
+	for _, deviceResult := range deviceResults {
+		durations := deviceStateDurations(deviceResult, stepIDToStepStates, currentTime)
+		row := fmt.Sprintf("%s\t%s\t%s\t%d\t", deviceResult.assetName(), durations[stepStatePending].Round(time.Second), durations[stepStateInProgress].Round(time.Second), len(deviceResult.steps))
+		if _, err := fmt.Fprintln(tw, row); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/require"
+	toolresults "google.golang.org/api/toolresults/v1beta3"
 )
 
 func testRefTime() time.Time {
@@ -21,18 +24,18 @@ func Test_printStepsStates(t *testing.T) {
 			stepIDToStepStates: map[string]stepStates{
 				"ID_1": {
 					name: "iOS Tests",
-					stateToStartTime: map[string]time.Time{
-						"pending":    testRefTime(),
-						"inProgress": testRefTime().Add(60 * time.Second),
-						"complete":   testRefTime().Add(90 * time.Second),
+					transitions: []stateTransition{
+						{state: "pending", startTime: testRefTime()},
+						{state: "inProgress", startTime: testRefTime().Add(60 * time.Second)},
+						{state: "complete", startTime: testRefTime().Add(90 * time.Second)},
 					},
 				},
 				"ID_2": {
 					name: "iOS Unit Tests",
-					stateToStartTime: map[string]time.Time{
-						"pending":    testRefTime(),
-						"inProgress": testRefTime().Add(40 * time.Second),
-						"complete":   testRefTime().Add(90 * time.Second),
+					transitions: []stateTransition{
+						{state: "pending", startTime: testRefTime()},
+						{state: "inProgress", startTime: testRefTime().Add(40 * time.Second)},
+						{state: "complete", startTime: testRefTime().Add(90 * time.Second)},
 					},
 				},
 			},
@@ -59,3 +62,67 @@ iOS Unit Tests
 		})
 	}
 }
+
+func Test_updateStepsStates(t *testing.T) {
+	step := testStep("1", "iphone8", nil)
+	stepIDToStepStates := map[string]stepStates{}
+	poll := func(state string, elapsed time.Duration) {
+		step.State = state
+		updateStepsStates(stepIDToStepStates, toolresults.ListStepsResponse{Steps: []*toolresults.Step{step}}, testRefTime().Add(elapsed))
+	}
+
+	poll("pending", 0)
+	poll("pending", 10*time.Second)
+	poll("inProgress", 60*time.Second)
+	// The test run is queued again, for example after losing its device
+	poll("pending", 80*time.Second)
+	poll("inProgress", 120*time.Second)
+	poll("complete", 150*time.Second)
+
+	states := stepIDToStepStates["1"]
+	require.Equal(t, map[string]time.Duration{
+		"pending":    100 * time.Second,
+		"inProgress": 50 * time.Second,
+		"complete":   0,
+	}, states.durations(testRefTime().Add(150*time.Second)))
+
+	var b bytes.Buffer
+	states.print(testRefTime().Add(150*time.Second), &b)
+	require.Equal(t, `iOS Tests (iphone8 16.6 portrait en)
+- time spent in pending state: ~1m40s (entered 2 times)
+- time spent in inProgress state: ~50s (entered 2 times)
+- time spent in complete state: ~0s
+`, b.String())
+}
+
+func Test_printQueueTimes(t *testing.T) {
+	steps := []*toolresults.Step{
+		testStep("1", "iphone8", &toolresults.Outcome{Summary: "failure"}),
+		testStep("2", "iphone8", &toolresults.Outcome{Summary: "success"}),
+		testStep("3", "iphone13pro", &toolresults.Outcome{Summary: "success"}),
+	}
+	stepIDToStepStates := map[string]stepStates{
+		"1": {transitions: []stateTransition{
+			{state: "pending", startTime: testRefTime()},
+			{state: "inProgress", startTime: testRefTime().Add(60 * time.Second)},
+			{state: "complete", startTime: testRefTime().Add(90 * time.Second)},
+		}},
+		"2": {transitions: []stateTransition{
+			{state: "pending", startTime: testRefTime().Add(90 * time.Second)},
+			{state: "inProgress", startTime: testRefTime().Add(150 * time.Second)},
+			{state: "complete", startTime: testRefTime().Add(180 * time.Second)},
+		}},
+		"3": {transitions: []stateTransition{
+			{state: "pending", startTime: testRefTime()},
+			{state: "inProgress", startTime: testRefTime().Add(10 * time.Second)},
+			{state: "complete", startTime: testRefTime().Add(180 * time.Second)},
+		}},
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, printQueueTimes(collectDeviceResults(steps), stepIDToStepStates, testRefTime().Add(180*time.Second), &b))
+	require.Equal(t, `Device                         Queue time   Run time   Test runs   
+iphone8-16.6-en-portrait       2m0s         1m0s       2           
+iphone13pro-16.6-en-portrait   10s          2m50s      1           
+`, b.String())
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/sliceutil"
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+)
+
+const (
+	successPolicyNeutralIncompatibleDevice = "neutral_incompatible_device"
+	successPolicyMaxInfrastructureFailures = "max_infrastructure_failures"
+	successPolicyMinPassRatio              = "min_pass_ratio"
+	successPolicyStrict                    = "strict"
+)
+
+const (
+	deviceVerdictPassed = "passed"
+	deviceVerdictFailed = "failed"
+	// deviceVerdictNeutral devices are left out of the pass ratio.
+	deviceVerdictNeutral = "neutral"
+	// deviceVerdictTolerated devices failed for infrastructure reasons, but within the tolerated number of devices.
+	deviceVerdictTolerated = "tolerated"
+)
+
+// successPolicy decides whether the devices' test runs make the step succeed.
+type successPolicy struct {
+	// neutralIncompatibleDevice leaves the devices skipped with IncompatibleDevice out of the decision.
+	neutralIncompatibleDevice bool
+	// maxInfrastructureFailures is the number of devices allowed to end inconclusive with InfrastructureFailure.
+	maxInfrastructureFailures int
+	// minPassRatio is the ratio of the passed devices required, among the non neutral devices.
+	minPassRatio float64
+	// strict fails the devices with flaky tests.
+	strict bool
+}
+
+// deviceVerdict is the policy's verdict on a device's test runs.
+type deviceVerdict struct {
+	dimensionID string
+	verdict     string
+	reason      string
+}
+
+// successDecision is the outcome of the success policy, reason tells why the step failed.
+type successDecision struct {
+	success bool
+	reason  string
+	devices []deviceVerdict
+}
+
+/*
+parseSuccessPolicy parses the newline separated policy options:
+- neutral_incompatible_device
+- max_infrastructure_failures=N
+- min_pass_ratio=R (0 < R <= 1)
+- strict
+
+Without options every device has to pass, matching the step's original behavior.
+*/
+func parseSuccessPolicy(input string) (successPolicy, error) {
+	policy := successPolicy{minPassRatio: 1}
+	for _, option := range splitPatterns(input) {
+		name, value, hasValue := strings.Cut(option, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+
+		switch name {
+		case successPolicyNeutralIncompatibleDevice, successPolicyStrict:
+			if hasValue {
+				return successPolicy{}, fmt.Errorf("success policy option %s has no value: %s", name, option)
+			}
+			if name == successPolicyStrict {
+				policy.strict = true
+			} else {
+				policy.neutralIncompatibleDevice = true
+			}
+		case successPolicyMaxInfrastructureFailures:
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 0 {
+				return successPolicy{}, fmt.Errorf("invalid %s value, should be a non-negative integer: %s", name, value)
+			}
+			policy.maxInfrastructureFailures = count
+		case successPolicyMinPassRatio:
+			ratio, err := strconv.ParseFloat(value, 64)
+			if err != nil || ratio <= 0 || ratio > 1 {
+				return successPolicy{}, fmt.Errorf("invalid %s value, should be in the (0, 1] range: %s", name, value)
+			}
+			policy.minPassRatio = ratio
+		default:
+			return successPolicy{}, fmt.Errorf("unknown success policy option: %s", option)
+		}
+	}
+	return policy, nil
+}
+
+/*
+evaluate gives a verdict on each device and decides the step's success. A device passes if any of its test runs
+succeeded, unless it ran flaky tests in strict mode or expected tests did not run on it (incompleteDimensionIDs).
+
+Infrastructure failures are tolerated only if at most maxInfrastructureFailures devices have them, otherwise
+all of them fail. The step fails if no device was decided, or if the passed devices' ratio is below minPassRatio.
+*/
+func (p successPolicy) evaluate(deviceResults []deviceResult, incompleteDimensionIDs []string) successDecision {
+	var infrastructureFailures []int
+	var decision successDecision
+	for _, deviceResult := range deviceResults {
+		outcome := deviceResult.outcome()
+		verdict := deviceVerdict{dimensionID: deviceResult.dimensionID, verdict: deviceVerdictPassed}
+
+		switch {
+		case !deviceResult.isSuccess() && p.neutralIncompatibleDevice && hasOutcomeDetail(outcome, outcomeSkipped, "IncompatibleDevice"):
+			verdict.verdict = deviceVerdictNeutral
+			verdict.reason = "skipped on incompatible device"
+		case !deviceResult.isSuccess() && hasOutcomeDetail(outcome, outcomeInconclusive, "InfrastructureFailure"):
+			verdict.verdict = deviceVerdictTolerated
+			verdict.reason = "infrastructure failure"
+			infrastructureFailures = append(infrastructureFailures, len(decision.devices))
+		case !deviceResult.isSuccess():
+			verdict.verdict = deviceVerdictFailed
+			verdict.reason = outcomeText(outcome)
+		case p.strict && outcome != nil && outcome.Summary == outcomeFlaky:
+			verdict.verdict = deviceVerdictFailed
+			verdict.reason = "flaky tests in strict mode"
+		case sliceutil.IsStringInSlice(deviceResult.dimensionID, incompleteDimensionIDs):
+			verdict.verdict = deviceVerdictFailed
+			verdict.reason = "expected tests did not run"
+		}
+
+		decision.devices = append(decision.devices, verdict)
+	}
+
+	if len(infrastructureFailures) > p.maxInfrastructureFailures {
+		for _, idx := range infrastructureFailures {
+			decision.devices[idx].verdict = deviceVerdictFailed
+			decision.devices[idx].reason = fmt.Sprintf("infrastructure failure on %d device(s), %d tolerated", len(infrastructureFailures), p.maxInfrastructureFailures)
+		}
+	}
+
+	passed, failed := 0, 0
+	for _, device := range decision.devices {
+		switch device.verdict {
+		case deviceVerdictPassed:
+			passed++
+		case deviceVerdictFailed:
+			failed++
+		}
+	}
+
+	switch {
+	case passed+failed == 0:
+		decision.reason = "no test run passed or failed"
+	case float64(passed)/float64(passed+failed) < p.minPassRatio:
+		decision.reason = fmt.Sprintf("%d test run(s) failed", failed)
+		if p.minPassRatio < 1 {
+			decision.reason = fmt.Sprintf("%d of %d test run(s) passed, below the minimum pass ratio (%g)", passed, passed+failed, p.minPassRatio)
+		}
+	default:
+		decision.success = true
+	}
+
+	return decision
+}
+
+// hasOutcomeDetail reports whether the outcome has the given summary and detail flag, see outcomeDetails.
+func hasOutcomeDetail(outcome *toolresults.Outcome, summary, detail string) bool {
+	return outcome != nil && outcome.Summary == summary && sliceutil.IsStringInSlice(detail, outcomeDetails(outcome))
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+)
+
+func Test_parseSuccessPolicy(t *testing.T) {
+	policy, err := parseSuccessPolicy("")
+	require.NoError(t, err)
+	require.Equal(t, successPolicy{minPassRatio: 1}, policy)
+
+	policy, err = parseSuccessPolicy("neutral_incompatible_device\n max_infrastructure_failures = 2\nmin_pass_ratio=0.8\n\nstrict\n")
+	require.NoError(t, err)
+	require.Equal(t, successPolicy{neutralIncompatibleDevice: true, maxInfrastructureFailures: 2, minPassRatio: 0.8, strict: true}, policy)
+
+	for _, input := range []string{"strict=true", "max_infrastructure_failures=-1", "min_pass_ratio=0", "min_pass_ratio=1.5", "min_pass_ratio", "lenient"} {
+		_, err := parseSuccessPolicy(input)
+		require.Error(t, err, input)
+	}
+}
+
+func Test_successPolicy_evaluate(t *testing.T) {
+	incompatibleDevice := &toolresults.Outcome{Summary: "skipped", SkippedDetail: &toolresults.SkippedDetail{IncompatibleDevice: true}}
+	infrastructureFailure := &toolresults.Outcome{Summary: "inconclusive", InconclusiveDetail: &toolresults.InconclusiveDetail{InfrastructureFailure: true}}
+
+	tests := []struct {
+		name                   string
+		policy                 string
+		steps                  []*toolresults.Step
+		incompleteDimensionIDs []string
+		wantSuccess            bool
+		wantReason             string
+		wantVerdicts           []string
+	}{
+		{
+			name: "a successful attempt makes the device pass",
+			steps: []*toolresults.Step{
+				testStep("1", "iphone8", &toolresults.Outcome{Summary: "failure"}),
+				testStep("2", "iphone8", &toolresults.Outcome{Summary: "success"}),
+				testStep("3", "iphone13pro", &toolresults.Outcome{Summary: "flaky"}),
+			},
+			wantSuccess:  true,
+			wantVerdicts: []string{deviceVerdictPassed, deviceVerdictPassed},
+		},
+		{
+			name: "any failed device fails by default",
+			steps: []*toolresults.Step{
+				testStep("1", "iphone8", &toolresults.Outcome{Summary: "success"}),
+				testStep("2", "iphone13pro", incompatibleDevice),
+			},
+			wantReason:   "1 test run(s) failed",
+			wantVerdicts: []string{deviceVerdictPassed, deviceVerdictFailed},
+		},
+		{
+			name:   "incompatible device is neutral",
+			policy: "neutral_incompatible_device",
+			steps: []*toolresults.Step{
+				testStep("1", "iphone8", &toolresults.Outcome{Summary: "success"}),
+				testStep("2", "iphone13pro", incompatibleDevice),
+			},
+			wantSuccess:  true,
+			wantVerdicts: []string{deviceVerdictPassed, deviceVerdictNeutral},
+		},
+		{
+			name:   "only neutral devices",
+			policy: "neutral_incompatible_device",
+			steps: []*toolresults.Step{
+				testStep("1", "iphone13pro", incompatibleDevice),
+			},
+			wantReason:   "no test run passed or failed",
+			wantVerdicts: []string{deviceVerdictNeutral},
+		},
+		{
+			name:   "infrastructure failures within the tolerated number",
+			policy: "max_infrastructure_failures=1",
+			steps: []*toolresults.Step{
+				testStep("1", "iphone8", &toolresults.Outcome{Summary: "success"}),
+				testStep("2", "iphone13pro", infrastructureFailure),
+			},
+			wantSuccess:  true,
+			wantVerdicts: []string{deviceVerdictPassed, deviceVerdictTolerated},
+		},
+		{
+			name:   "infrastructure failures above the tolerated number",
+			policy: "max_infrastructure_failures=1",
+			steps: []*toolresults.Step{
+				testStep("1", "iphone8", infrastructureFailure),
+				testStep("2", "iphone13pro", infrastructureFailure),
+			},
+			wantReason:   "2 test run(s) failed",
+			wantVerdicts: []string{deviceVerdictFailed, deviceVerdictFailed},
+		},
+		{
+			name:   "pass ratio reached",
+			policy: "min_pass_ratio=0.5",
+			steps: []*toolresults.Step{
+				testStep("1", "iphone8", &toolresults.Outcome{Summary: "success"}),
+				testStep("2", "iphone13pro", &toolresults.Outcome{Summary: "failure"}),
+			},
+			wantSuccess:  true,
+			wantVerdicts: []string{deviceVerdictPassed, deviceVerdictFailed},
+		},
+		{
+			name:   "pass ratio not reached",
+			policy: "min_pass_ratio=0.6",
+			steps: []*toolresults.Step{
+				testStep("1", "iphone8", &toolresults.Outcome{Summary: "success"}),
+				testStep("2", "iphone13pro", &toolresults.Outcome{Summary: "failure"}),
+			},
+			wantReason:   "1 of 2 test run(s) passed, below the minimum pass ratio (0.6)",
+			wantVerdicts: []string{deviceVerdictPassed, deviceVerdictFailed},
+		},
+		{
+			name:   "flaky device fails in strict mode",
+			policy: "strict",
+			steps: []*toolresults.Step{
+				testStep("1", "iphone8", &toolresults.Outcome{Summary: "success"}),
+				testStep("2", "iphone13pro", &toolresults.Outcome{Summary: "flaky"}),
+			},
+			wantReason:   "1 test run(s) failed",
+			wantVerdicts: []string{deviceVerdictPassed, deviceVerdictFailed},
+		},
+		{
+			name: "expected tests did not run",
+			steps: []*toolresults.Step{
+				testStep("1", "iphone8", &toolresults.Outcome{Summary: "success"}),
+			},
+			incompleteDimensionIDs: []string{"iphone8.16.6.portrait.en"},
+			wantReason:             "1 test run(s) failed",
+			wantVerdicts:           []string{deviceVerdictFailed},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := parseSuccessPolicy(tt.policy)
+			require.NoError(t, err)
+
+			decision := policy.evaluate(collectDeviceResults(tt.steps), tt.incompleteDimensionIDs)
+			require.Equal(t, tt.wantSuccess, decision.success)
+			require.Equal(t, tt.wantReason, decision.reason)
+
+			var verdicts []string
+			for _, device := range decision.devices {
+				verdicts = append(verdicts, device.verdict)
+			}
+			require.Equal(t, tt.wantVerdicts, verdicts)
+		})
+	}
+}
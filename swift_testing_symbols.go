@@ -0,0 +1,294 @@
+package main
+
+import (
+	"debug/macho"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/sliceutil"
+)
+
+/*
+readSwiftTestingSuites returns the Swift Testing functions (with argument labels) by suites (Outer.Inner, empty for
+module level functions) of a Mach-O test binary, read from its symbol table.
+
+The @Test macro attaches a peer declaration to each test function, the peer's symbols are mangled with the test
+function's context and name, followed by the macro's name and the attached peer macro operator:
+$s13BullsEyeTests05ScoreC0V17scoreIsCalculated4TestfMp_... The argument labels are read from the test function's
+own symbol ($s13BullsEyeTests05ScoreC0V17scoreIsCalculated6pointsySi_tF). Stripped binaries have no such symbols,
+their Swift Testing tests are not listed.
+*/
+func readSwiftTestingSuites(file *macho.File) map[string][]string {
+	suites := map[string][]string{}
+	if file.Symtab == nil {
+		return suites
+	}
+
+	testFunctions := map[swiftDeclaration]bool{}
+	declarationToNames := map[swiftDeclaration][]string{}
+	for _, symbol := range file.Symtab.Syms {
+		name := strings.TrimPrefix(symbol.Name, "_")
+		if !strings.HasPrefix(name, "$s") {
+			continue
+		}
+
+		if declaration, ok := swiftTestingPeerDeclaration(name); ok {
+			testFunctions[declaration] = true
+		} else if declaration, functionName, ok := swiftFunctionDeclaration(name); ok && !sliceutil.IsStringInSlice(functionName, declarationToNames[declaration]) {
+			declarationToNames[declaration] = append(declarationToNames[declaration], functionName)
+		}
+	}
+
+	for declaration := range testFunctions {
+		names := declarationToNames[declaration]
+		if len(names) == 0 {
+			names = []string{declaration.name + "()"}
+		}
+		suites[declaration.suite] = append(suites[declaration.suite], names...)
+	}
+	for _, functions := range suites {
+		sort.Strings(functions)
+	}
+
+	return suites
+}
+
+// swiftDeclaration is a declaration of a Swift module: its nominal types' path (Outer.Inner) and its name.
+type swiftDeclaration struct {
+	module string
+	suite  string
+	name   string
+}
+
+// swiftTestingPeerDeclaration returns the test function's declaration if the symbol belongs to a @Test macro's peer declaration.
+func swiftTestingPeerDeclaration(symbol string) (swiftDeclaration, bool) {
+	if !strings.Contains(symbol, "fMp") {
+		return swiftDeclaration{}, false
+	}
+
+	d := swiftDemangler{text: symbol}
+	declaration, ok := d.declaration()
+	if !ok {
+		return swiftDeclaration{}, false
+	}
+	if macro, ok := d.identifier(); !ok || macro != "Test" || !d.consume("fMp") {
+		return swiftDeclaration{}, false
+	}
+	return declaration, true
+}
+
+/*
+swiftFunctionDeclaration returns the declaration and the name with argument labels (scoreIsCalculated(points:)) of a
+function returning Void. The labels are mangled after the function's name, `_` stands for an unlabeled parameter,
+but the labels are omitted if none of the parameters has a label. In that case the unlabeled parameters are counted
+from the parameter list's separators, which is approximate for parameters of function or tuple types.
+*/
+func swiftFunctionDeclaration(symbol string) (swiftDeclaration, string, bool) {
+	if !strings.HasSuffix(symbol, "F") {
+		return swiftDeclaration{}, "", false
+	}
+
+	d := swiftDemangler{text: symbol}
+	declaration, ok := d.declaration()
+	if !ok {
+		return swiftDeclaration{}, "", false
+	}
+
+	var labels []string
+	for {
+		if d.consume("_") {
+			labels = append(labels, "_")
+		} else if label, ok := d.identifier(); ok {
+			labels = append(labels, label)
+		} else {
+			break
+		}
+	}
+
+	// The function type starts with the result type, Void (y)
+	if !d.consume("y") {
+		return swiftDeclaration{}, "", false
+	}
+	if len(labels) == 0 {
+		// The omitted labels are followed by the result type (yySiF), a function without parameters has an empty parameter list (yyF)
+		if !d.consume("y") {
+			return swiftDeclaration{}, "", false
+		}
+
+		// The parameter list (Si, or a tuple: Si_SSt) up to the effects (async Ya, throws K) and the function operator
+		parameters := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(d.rest(), "F"), "K"), "Ya")
+		if parameters != "" {
+			labels = append(labels, "_")
+		}
+		if strings.HasSuffix(parameters, "t") {
+			for i := 0; i < strings.Count(parameters, "_"); i++ {
+				labels = append(labels, "_")
+			}
+		}
+	}
+
+	name := declaration.name + "("
+	for _, label := range labels {
+		name += label + ":"
+	}
+	return declaration, name + ")", true
+}
+
+const swiftMaxNumWords = 26
+
+/*
+swiftDemangler reads the context and the names of Swift symbols, following the Swift ABI's mangling grammar
+(docs/ABI/Mangling.rst in the Swift repository). It only reads the identifiers of a declaration's context, and
+fails on anything else, like substitutions or generic contexts.
+*/
+type swiftDemangler struct {
+	text string
+	pos  int
+	// words are the identifiers' words, later identifiers refer to them by index.
+	words []string
+}
+
+func (d *swiftDemangler) rest() string {
+	return d.text[d.pos:]
+}
+
+func (d *swiftDemangler) consume(s string) bool {
+	if !strings.HasPrefix(d.rest(), s) {
+		return false
+	}
+	d.pos += len(s)
+	return true
+}
+
+/*
+declaration reads the declaration's context and name: the module, the nominal types (struct V, class C, enum O)
+and the declaration's name, skipping the macro expansions' file discriminators (Ll) and a private declaration's
+discriminator (LL).
+*/
+func (d *swiftDemangler) declaration() (swiftDeclaration, bool) {
+	if !d.consume("$s") {
+		return swiftDeclaration{}, false
+	}
+
+	module, ok := d.identifier()
+	if !ok {
+		return swiftDeclaration{}, false
+	}
+
+	var types []string
+	for {
+		name, ok := d.identifier()
+		if !ok {
+			return swiftDeclaration{}, false
+		}
+
+		switch {
+		case d.consume("V"), d.consume("C"), d.consume("O"):
+			types = append(types, name)
+		case d.consume("Ll"):
+		default:
+			pos, numWords := d.pos, len(d.words)
+			if _, ok := d.identifier(); !ok || !d.consume("LL") {
+				d.pos, d.words = pos, d.words[:numWords]
+			}
+			return swiftDeclaration{module: module, suite: strings.Join(types, "."), name: name}, true
+		}
+	}
+}
+
+/*
+identifier reads an identifier: its length followed by its characters (17scoreIsCalculated), or the 0 prefix
+followed by word substitutions (a lowercase letter, an uppercase letter for the last one) and parts with their
+lengths: 05ScoreC0 -> ScoreTests, if Tests is the third word of the symbol's identifiers. Punycode identifiers (00 prefix) are not read.
+*/
+func (d *swiftDemangler) identifier() (string, bool) {
+	if d.pos >= len(d.text) || !isDigit(d.text[d.pos]) || strings.HasPrefix(d.rest(), "00") {
+		return "", false
+	}
+
+	pos, numWords := d.pos, len(d.words)
+	hasWordSubstitutions := d.consume("0")
+
+	var identifier strings.Builder
+	for {
+		for hasWordSubstitutions && d.pos < len(d.text) && isLetter(d.text[d.pos]) {
+			c := d.text[d.pos]
+			d.pos++
+
+			var index int
+			if c >= 'a' && c <= 'z' {
+				index = int(c - 'a')
+			} else {
+				index = int(c - 'A')
+				hasWordSubstitutions = false
+			}
+			if index >= len(d.words) {
+				d.pos, d.words = pos, d.words[:numWords]
+				return "", false
+			}
+			identifier.WriteString(d.words[index])
+		}
+
+		// 0 ends an identifier ending with a word substitution
+		if d.consume("0") {
+			break
+		}
+
+		start := d.pos
+		for d.pos < len(d.text) && isDigit(d.text[d.pos]) {
+			d.pos++
+		}
+		length := 0
+		for _, c := range d.text[start:d.pos] {
+			length = length*10 + int(c-'0')
+		}
+		if length == 0 || d.pos+length > len(d.text) {
+			d.pos, d.words = pos, d.words[:numWords]
+			return "", false
+		}
+
+		part := d.text[d.pos : d.pos+length]
+		d.pos += length
+		identifier.WriteString(part)
+		d.addWords(part)
+
+		if !hasWordSubstitutions {
+			break
+		}
+	}
+
+	return identifier.String(), true
+}
+
+// addWords saves the words of an identifier's part: a word starts at a non-digit and ends at an uppercase letter after a non-uppercase letter, or at _.
+func (d *swiftDemangler) addWords(part string) {
+	wordStart := -1
+	for i := 0; i <= len(part); i++ {
+		var c byte
+		if i < len(part) {
+			c = part[i]
+		}
+
+		if wordStart >= 0 && (c == '_' || c == 0 || (isUpper(c) && !isUpper(part[i-1]))) {
+			if i-wordStart >= 2 && len(d.words) < swiftMaxNumWords {
+				d.words = append(d.words, part[wordStart:i])
+			}
+			wordStart = -1
+		}
+		if wordStart < 0 && c != 0 && c != '_' && !isDigit(c) {
+			wordStart = i
+		}
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
+func isLetter(c byte) bool {
+	return isUpper(c) || (c >= 'a' && c <= 'z')
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_swiftTestingPeerDeclaration(t *testing.T) {
+	tests := []struct {
+		symbol string
+		want   swiftDeclaration
+		wantOK bool
+	}{
+		{
+			symbol: "$s13BullsEyeTests05ScoreC0V17scoreIsCalculated4TestfMp_22$test_container__functionfMu_VN",
+			want:   swiftDeclaration{module: "BullsEyeTests", suite: "ScoreTests", name: "scoreIsCalculated"},
+			wantOK: true,
+		},
+		{
+			// A private function with its discriminator, the macro's name is a word substitution (Test)
+			symbol: "$s13BullsEyeTests05ScoreC0V11privateTest33_0123456789ABCDEF0123456789ABCDEFLL0F0fMp_",
+			want:   swiftDeclaration{module: "BullsEyeTests", suite: "ScoreTests", name: "privateTest"},
+			wantOK: true,
+		},
+		{
+			// Another attached peer macro
+			symbol: "$s13BullsEyeTests05ScoreC0V17scoreIsCalculated8ObservedfMp_",
+		},
+		{
+			symbol: "$s13BullsEyeTests05ScoreC0V17scoreIsCalculated6pointsySi_tF",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.symbol, func(t *testing.T) {
+			got, ok := swiftTestingPeerDeclaration(tt.symbol)
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_swiftFunctionDeclaration(t *testing.T) {
+	tests := []struct {
+		symbol string
+		want   string
+		wantOK bool
+	}{
+		{symbol: "$s13BullsEyeTests05ScoreC0V17scoreIsCalculatedyyF", want: "scoreIsCalculated()", wantOK: true},
+		{symbol: "$s13BullsEyeTests05ScoreC0V17scoreIsCalculated6pointsySi_tF", want: "scoreIsCalculated(points:)", wantOK: true},
+		{symbol: "$s13BullsEyeTests05ScoreC0V17scoreIsCalculated_6pointsySi_SitYaKF", want: "scoreIsCalculated(_:points:)", wantOK: true},
+		// The labels are omitted if no parameter has a label
+		{symbol: "$s13BullsEyeTests05ScoreC0V9isInRangeyySiF", want: "isInRange(_:)", wantOK: true},
+		{symbol: "$s13BullsEyeTests05ScoreC0V9isInRangeyySi_SitKF", want: "isInRange(_:_:)", wantOK: true},
+		// Not returning Void
+		{symbol: "$s13BullsEyeTests05ScoreC0V5scoreSiyF"},
+		// A closure in the function
+		{symbol: "$s13BullsEyeTests05ScoreC0V17scoreIsCalculatedyyFyyXEfU_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.symbol, func(t *testing.T) {
+			_, got, ok := swiftFunctionDeclaration(tt.symbol)
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
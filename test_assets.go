@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/output"
+)
+
+/*
+Test asset file names start with the device dimension, followed by the attempt and the asset name:
+- per test run results: iphone13pro-16.6-en-portrait_test_result_0.xml
+- rerun test results: iphone8-16.6-en-portrait-rerun_1_test_result_0.xml
+- merged result: iphone13pro-16.6-en-portrait-test_results_merged.xml
+*/
+var assetFileNamePattern = regexp.MustCompile(`^([^-]+)-([^-]+)-(.+?)-(portrait|landscape)(?:-rerun_(\d+))?(?:[-_](.*))?$`)
+
+// assetDimension is the device dimension and attempt parsed from a test asset's file name.
+type assetDimension struct {
+	Model       string `json:"model"`
+	Version     string `json:"version"`
+	Locale      string `json:"locale"`
+	Orientation string `json:"orientation"`
+	// Attempt is 0 for the first run and N for the `rerun_N` assets.
+	Attempt int `json:"attempt"`
+}
+
+// dimensionID returns the same ID main uses for the test results' dimensions.
+func (d assetDimension) dimensionID() string {
+	return fmt.Sprintf("%s.%s.%s.%s", d.Model, d.Version, d.Orientation, d.Locale)
+}
+
+func parseAssetFileName(fileName string) (assetDimension, bool) {
+	match := assetFileNamePattern.FindStringSubmatch(fileName)
+	if match == nil {
+		return assetDimension{}, false
+	}
+
+	dimension := assetDimension{
+		Model:       match[1],
+		Version:     match[2],
+		Locale:      match[3],
+		Orientation: match[4],
+	}
+	if match[5] != "" {
+		attempt, err := strconv.Atoi(match[5])
+		if err != nil {
+			return assetDimension{}, false
+		}
+		dimension.Attempt = attempt
+	}
+
+	return dimension, true
+}
+
+// isHeavyAsset reports whether the asset is something else than a JUnit XML test result (video, log, xcresult, ...).
+func isHeavyAsset(fileName string) bool {
+	return filepath.Ext(fileName) != ".xml"
+}
+
+const (
+	heavyAssetsForAllDevices          = "all_devices"
+	heavyAssetsForUnsuccessfulDevices = "unsuccessful_devices"
+)
+
+// assetFilter decides which test assets to download.
+type assetFilter struct {
+	includePatterns []string
+	excludePatterns []string
+	// heavyAssetsFor is heavyAssetsForAllDevices or heavyAssetsForUnsuccessfulDevices
+	heavyAssetsFor string
+}
+
+func newAssetFilter(includePatterns, excludePatterns, heavyAssetsFor string) (assetFilter, error) {
+	filter := assetFilter{
+		includePatterns: splitPatterns(includePatterns),
+		excludePatterns: splitPatterns(excludePatterns),
+		heavyAssetsFor:  heavyAssetsFor,
+	}
+
+	for _, pattern := range append(append([]string{}, filter.includePatterns...), filter.excludePatterns...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return assetFilter{}, fmt.Errorf("invalid asset pattern (%s): %w", pattern, err)
+		}
+	}
+
+	return filter, nil
+}
+
+// shouldDownload decides based on the asset's name and the test run status of the asset's device (dimension ID -> is success).
+func (f assetFilter) shouldDownload(fileName string, dimensionToStatus map[string]bool) bool {
+	if len(f.includePatterns) > 0 && !matchesAnyPattern(fileName, f.includePatterns) {
+		return false
+	}
+	if matchesAnyPattern(fileName, f.excludePatterns) {
+		return false
+	}
+
+	if f.heavyAssetsFor == heavyAssetsForUnsuccessfulDevices && isHeavyAsset(fileName) {
+		dimension, ok := parseAssetFileName(fileName)
+		if !ok {
+			// Assets not bound to a device are kept
+			return true
+		}
+
+		isSuccess, ok := dimensionToStatus[dimension.dimensionID()]
+		if ok && isSuccess {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filter returns the assets (file name -> url) to download.
+func (f assetFilter) filter(assets map[string]string, dimensionToStatus map[string]bool) map[string]string {
+	filtered := map[string]string{}
+	for fileName, url := range assets {
+		if f.shouldDownload(fileName, dimensionToStatus) {
+			filtered[fileName] = url
+		}
+	}
+	return filtered
+}
+
+func matchesAnyPattern(fileName string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, err := filepath.Match(pattern, fileName); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func splitPatterns(list string) []string {
+	var patterns []string
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns
+}
+
+const (
+	assetKindJUnit    = "junit"
+	assetKindVideo    = "video"
+	assetKindLog      = "log"
+	assetKindXcresult = "xcresult"
+	assetKindCrash    = "crash"
+	assetKindOther    = "other"
+)
+
+func assetKind(fileName string) string {
+	lowerName := strings.ToLower(fileName)
+	switch {
+	case strings.Contains(lowerName, ".xcresult"):
+		return assetKindXcresult
+	case strings.Contains(lowerName, "crash") || strings.HasSuffix(lowerName, ".ips"):
+		return assetKindCrash
+	case strings.HasSuffix(lowerName, ".xml"):
+		return assetKindJUnit
+	case strings.HasSuffix(lowerName, ".mp4") || strings.HasSuffix(lowerName, ".mov"):
+		return assetKindVideo
+	case strings.HasSuffix(lowerName, ".log") || strings.HasSuffix(lowerName, ".txt") || strings.Contains(lowerName, "log"):
+		return assetKindLog
+	default:
+		return assetKindOther
+	}
+}
+
+// name returns the device dimension in the test assets' naming format: iphone8-16.6-en-portrait
+func (d assetDimension) name() string {
+	return fmt.Sprintf("%s-%s-%s-%s", d.Model, d.Version, d.Locale, d.Orientation)
+}
+
+// deviceTestResults collects the merged test results XML and the number of attempts of each device from the downloaded assets (file name -> local path).
+func deviceTestResults(downloadedPths map[string]string) []output.DeviceTestResult {
+	devicesByName := map[string]*output.DeviceTestResult{}
+	var names []string
+	for fileName, pth := range downloadedPths {
+		dimension, ok := parseAssetFileName(fileName)
+		if !ok || assetKind(fileName) != assetKindJUnit {
+			continue
+		}
+
+		name := dimension.name()
+		device, ok := devicesByName[name]
+		if !ok {
+			device = &output.DeviceTestResult{
+				Name:        name,
+				Model:       dimension.Model,
+				OSVersion:   dimension.Version,
+				Locale:      dimension.Locale,
+				Orientation: dimension.Orientation,
+			}
+			devicesByName[name] = device
+			names = append(names, name)
+		}
+
+		if strings.HasSuffix(fileName, "test_results_merged.xml") {
+			device.MergedTestResultXMLPath = pth
+			continue
+		}
+
+		if dimension.Attempt+1 > device.Attempts {
+			device.Attempts = dimension.Attempt + 1
+		}
+		device.TestResultXMLPaths = append(device.TestResultXMLPaths, fileName)
+	}
+	sort.Strings(names)
+
+	var devices []output.DeviceTestResult
+	for _, name := range names {
+		device := devicesByName[name]
+		if device.MergedTestResultXMLPath == "" {
+			continue
+		}
+
+		// Collected as file names for the attempt ordering, reported as paths
+		sort.Slice(device.TestResultXMLPaths, func(i, j int) bool {
+			return assetManifestEntryLess(newAssetManifestEntry(device.TestResultXMLPaths[i]), newAssetManifestEntry(device.TestResultXMLPaths[j]))
+		})
+		for i, fileName := range device.TestResultXMLPaths {
+			device.TestResultXMLPaths[i] = downloadedPths[fileName]
+		}
+
+		devices = append(devices, *device)
+	}
+
+	return devices
+}
+
+// deviceAssets collects the videos, logs, crash reports and xcresult bundles of each device (name -> assets) from the downloaded assets (file name -> local path).
+func deviceAssets(downloadedPths map[string]string) map[string][]output.DeviceAsset {
+	var fileNames []string
+	for fileName := range downloadedPths {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	nameToAssets := map[string][]output.DeviceAsset{}
+	for _, fileName := range fileNames {
+		dimension, ok := parseAssetFileName(fileName)
+		if !ok {
+			continue
+		}
+
+		kind := assetKind(fileName)
+		if kind != assetKindVideo && kind != assetKindLog && kind != assetKindCrash && kind != assetKindXcresult {
+			continue
+		}
+
+		name := dimension.name()
+		nameToAssets[name] = append(nameToAssets[name], output.DeviceAsset{
+			Name: fileName,
+			Kind: kind,
+			Path: downloadedPths[fileName],
+		})
+	}
+
+	return nameToAssets
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/output"
+)
+
+func Test_parseAssetFileName(t *testing.T) {
+	tests := []struct {
+		fileName string
+		want     assetDimension
+		wantOK   bool
+	}{
+		{
+			fileName: "iphone13pro-16.6-en-portrait_test_result_0.xml",
+			want:     assetDimension{Model: "iphone13pro", Version: "16.6", Locale: "en", Orientation: "portrait"},
+			wantOK:   true,
+		},
+		{
+			fileName: "iphone8-16.6-en_GB-landscape-rerun_2_test_result_0.xml",
+			want:     assetDimension{Model: "iphone8", Version: "16.6", Locale: "en_GB", Orientation: "landscape", Attempt: 2},
+			wantOK:   true,
+		},
+		{
+			fileName: "iphone13pro-16.6-en-portrait-test_results_merged.xml",
+			want:     assetDimension{Model: "iphone13pro", Version: "16.6", Locale: "en", Orientation: "portrait"},
+			wantOK:   true,
+		},
+		{
+			fileName: "summary.json",
+			wantOK:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fileName, func(t *testing.T) {
+			got, ok := parseAssetFileName(tt.fileName)
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_assetFilter_filter(t *testing.T) {
+	assets := map[string]string{
+		"iphone8-16.6-en-portrait-test_results_merged.xml":     "url1",
+		"iphone8-16.6-en-portrait_video.mp4":                   "url2",
+		"iphone8-16.6-en-portrait_syslog.txt":                  "url3",
+		"iphone13pro-16.6-en-portrait-test_results_merged.xml": "url4",
+		"iphone13pro-16.6-en-portrait_video.mp4":               "url5",
+	}
+	dimensionToStatus := map[string]bool{
+		"iphone8.16.6.portrait.en":     false,
+		"iphone13pro.16.6.portrait.en": true,
+	}
+
+	tests := []struct {
+		name            string
+		includePatterns string
+		excludePatterns string
+		heavyAssetsFor  string
+		want            []string
+	}{
+		{
+			name:           "No filters",
+			heavyAssetsFor: heavyAssetsForAllDevices,
+			want: []string{
+				"iphone8-16.6-en-portrait-test_results_merged.xml",
+				"iphone8-16.6-en-portrait_video.mp4",
+				"iphone8-16.6-en-portrait_syslog.txt",
+				"iphone13pro-16.6-en-portrait-test_results_merged.xml",
+				"iphone13pro-16.6-en-portrait_video.mp4",
+			},
+		},
+		{
+			name:            "Include and exclude patterns",
+			includePatterns: "*test_results_merged.xml\n*.mp4\n",
+			excludePatterns: "iphone13pro-*",
+			heavyAssetsFor:  heavyAssetsForAllDevices,
+			want: []string{
+				"iphone8-16.6-en-portrait-test_results_merged.xml",
+				"iphone8-16.6-en-portrait_video.mp4",
+			},
+		},
+		{
+			name:           "Heavy assets for unsuccessful devices",
+			heavyAssetsFor: heavyAssetsForUnsuccessfulDevices,
+			want: []string{
+				"iphone8-16.6-en-portrait-test_results_merged.xml",
+				"iphone8-16.6-en-portrait_video.mp4",
+				"iphone8-16.6-en-portrait_syslog.txt",
+				"iphone13pro-16.6-en-portrait-test_results_merged.xml",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := newAssetFilter(tt.includePatterns, tt.excludePatterns, tt.heavyAssetsFor)
+			require.NoError(t, err)
+
+			got := filter.filter(assets, dimensionToStatus)
+
+			var gotNames []string
+			for name := range got {
+				gotNames = append(gotNames, name)
+			}
+			require.ElementsMatch(t, tt.want, gotNames)
+		})
+	}
+}
+
+func Test_newAssetFilter_invalidPattern(t *testing.T) {
+	_, err := newAssetFilter("[", "", heavyAssetsForAllDevices)
+	require.Error(t, err)
+}
+
+func Test_deviceTestResults(t *testing.T) {
+	downloadedPths := map[string]string{
+		"iphone8-16.6-en-portrait_test_result_0.xml":           "dir/iphone8-16.6-en-portrait_test_result_0.xml",
+		"iphone8-16.6-en-portrait-rerun_1_test_result_0.xml":   "dir/iphone8-16.6-en-portrait-rerun_1_test_result_0.xml",
+		"iphone8-16.6-en-portrait-rerun_2_test_result_0.xml":   "dir/iphone8-16.6-en-portrait-rerun_2_test_result_0.xml",
+		"iphone8-16.6-en-portrait-test_results_merged.xml":     "dir/iphone8-16.6-en-portrait-test_results_merged.xml",
+		"iphone8-16.6-en-portrait_video.mp4":                   "dir/iphone8-16.6-en-portrait_video.mp4",
+		"iphone13pro-16.6-en-portrait_test_result_0.xml":       "dir/iphone13pro-16.6-en-portrait_test_result_0.xml",
+		"iphone13pro-16.6-en-portrait-test_results_merged.xml": "dir/iphone13pro-16.6-en-portrait-test_results_merged.xml",
+		"ipad10-16.6-en-landscape_test_result_0.xml":           "dir/ipad10-16.6-en-landscape_test_result_0.xml",
+	}
+
+	got := deviceTestResults(downloadedPths)
+
+	require.Equal(t, []output.DeviceTestResult{
+		{
+			Name:                    "iphone13pro-16.6-en-portrait",
+			Model:                   "iphone13pro",
+			OSVersion:               "16.6",
+			Locale:                  "en",
+			Orientation:             "portrait",
+			Attempts:                1,
+			MergedTestResultXMLPath: "dir/iphone13pro-16.6-en-portrait-test_results_merged.xml",
+			TestResultXMLPaths:      []string{"dir/iphone13pro-16.6-en-portrait_test_result_0.xml"},
+		},
+		{
+			Name:                    "iphone8-16.6-en-portrait",
+			Model:                   "iphone8",
+			OSVersion:               "16.6",
+			Locale:                  "en",
+			Orientation:             "portrait",
+			Attempts:                3,
+			MergedTestResultXMLPath: "dir/iphone8-16.6-en-portrait-test_results_merged.xml",
+			TestResultXMLPaths: []string{
+				"dir/iphone8-16.6-en-portrait_test_result_0.xml",
+				"dir/iphone8-16.6-en-portrait-rerun_1_test_result_0.xml",
+				"dir/iphone8-16.6-en-portrait-rerun_2_test_result_0.xml",
+			},
+		},
+	}, got)
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-plist"
+	"github.com/bitrise-io/go-utils/v2/pathutil"
+)
+
+const (
+	uiTestRunnerSuffix = "-Runner.app"
+	testRootPrefix     = "__TESTROOT__"
+	testHostPrefix     = "__TESTHOST__"
+)
+
+/*
+prepareTestBundleZip returns the path of the test bundle zip to upload.
+
+The bundle is taken from the first configured source:
+- test_products_dir: a DerivedData Build/Products directory
+- app_path (+ test_bundle_paths): an app bundle and its test bundles
+- zip_path: an already zipped test bundle (for example the output of the Xcode Build for testing Step)
+
+When the bundle is assembled by the step, it uses the same layout as zipTestBundle:
+the build folders (for example Debug-iphoneos/) and the xctestrun file(s) in the zip root.
+*/
+func prepareTestBundleZip(zipPth, productsDir, appPth, testBundlePths string) (string, error) {
+	switch {
+	case productsDir != "":
+		return createTestBundleZipFromProductsDir(productsDir)
+	case appPth != "":
+		return createTestBundleZipFromBundles(appPth, splitPaths(testBundlePths))
+	case zipPth != "":
+		if _, err := os.Stat(zipPth); err != nil {
+			return "", fmt.Errorf("test bundle zip (%s) does not exist: %w", zipPth, err)
+		}
+		return zipPth, nil
+	default:
+		return "", fmt.Errorf("one of zip_path, test_products_dir or app_path inputs is required")
+	}
+}
+
+func createTestBundleZipFromProductsDir(productsDir string) (string, error) {
+	entries, err := os.ReadDir(productsDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read products dir: %w", err)
+	}
+
+	stagingDir, err := pathutil.NewPathProvider().CreateTempDir("test_bundle_staging")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp dir for the test bundle: %w", err)
+	}
+
+	var buildDirs []string
+	hasXctestrun := false
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case filepath.Ext(name) == ".xctestrun":
+			hasXctestrun = true
+			if err := linkIntoDir(filepath.Join(productsDir, name), stagingDir); err != nil {
+				return "", err
+			}
+		case entry.IsDir() && isDeviceBuildDir(name):
+			buildDirs = append(buildDirs, name)
+		}
+	}
+
+	buildDir, err := selectDeviceBuildDir(buildDirs)
+	if err != nil {
+		return "", fmt.Errorf("products dir (%s): %w", productsDir, err)
+	}
+
+	// zipTestBundle only picks up real folders from the zip root, so the build folder is created
+	// and only its content is linked.
+	if err := linkDirContent(filepath.Join(productsDir, buildDir), filepath.Join(stagingDir, buildDir)); err != nil {
+		return "", err
+	}
+
+	if !hasXctestrun {
+		if err := generateXctestrunForBuildDir(stagingDir, buildDir); err != nil {
+			return "", err
+		}
+	}
+
+	return zipTestBundle(stagingDir, 6)
+}
+
+func createTestBundleZipFromBundles(appPth string, testBundlePths []string) (string, error) {
+	if filepath.Ext(appPth) != ".app" {
+		return "", fmt.Errorf("app path (%s) is not an .app bundle", appPth)
+	}
+	if _, err := os.Stat(appPth); err != nil {
+		return "", fmt.Errorf("app bundle (%s) does not exist: %w", appPth, err)
+	}
+
+	stagingDir, err := pathutil.NewPathProvider().CreateTempDir("test_bundle_staging")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp dir for the test bundle: %w", err)
+	}
+
+	buildDir := "Debug-iphoneos"
+	buildDirPth := filepath.Join(stagingDir, buildDir)
+	if err := os.MkdirAll(buildDirPth, 0755); err != nil {
+		return "", fmt.Errorf("failed to create build dir: %w", err)
+	}
+
+	bundlesToLink := []string{appPth}
+	for _, testBundlePth := range testBundlePths {
+		bundleToLink, err := topLevelBundleOfTestBundle(appPth, testBundlePth)
+		if err != nil {
+			return "", err
+		}
+		if bundleToLink != "" {
+			bundlesToLink = append(bundlesToLink, bundleToLink)
+		}
+	}
+
+	linked := map[string]bool{}
+	for _, bundlePth := range bundlesToLink {
+		name := filepath.Base(bundlePth)
+		if linked[name] {
+			continue
+		}
+		linked[name] = true
+
+		if err := linkIntoDir(bundlePth, buildDirPth); err != nil {
+			return "", err
+		}
+	}
+
+	if err := generateXctestrunForBuildDir(stagingDir, buildDir); err != nil {
+		return "", err
+	}
+
+	return zipTestBundle(stagingDir, 6)
+}
+
+/*
+topLevelBundleOfTestBundle returns the bundle which needs to be placed next to the app for the given test bundle:
+- UI test bundles live in the UI test runner app (BullsEyeUITests-Runner.app/PlugIns/BullsEyeUITests.xctest), so the runner app is returned
+- unit test bundles live in the app under test (BullsEye.app/PlugIns/BullsEyeTests.xctest), which is already part of the bundle, so an empty string is returned
+*/
+func topLevelBundleOfTestBundle(appPth, testBundlePth string) (string, error) {
+	if strings.HasSuffix(testBundlePth, uiTestRunnerSuffix) {
+		return testBundlePth, nil
+	}
+
+	if filepath.Ext(testBundlePth) != ".xctest" {
+		return "", fmt.Errorf("test bundle (%s) is neither an .xctest bundle nor a UI test runner app", testBundlePth)
+	}
+	if _, err := os.Stat(testBundlePth); err != nil {
+		return "", fmt.Errorf("test bundle (%s) does not exist: %w", testBundlePth, err)
+	}
+
+	plugInsDir := filepath.Dir(testBundlePth)
+	hostBundlePth := filepath.Dir(plugInsDir)
+	if filepath.Base(plugInsDir) != "PlugIns" || filepath.Ext(hostBundlePth) != ".app" {
+		return "", fmt.Errorf("test bundle (%s) is not embedded in an app's PlugIns folder", testBundlePth)
+	}
+
+	if filepath.Clean(hostBundlePth) == filepath.Clean(appPth) {
+		return "", nil
+	}
+	if strings.HasSuffix(hostBundlePth, uiTestRunnerSuffix) {
+		return hostBundlePth, nil
+	}
+
+	return "", fmt.Errorf("test bundle (%s) is hosted by %s, which is neither the app under test nor a UI test runner app", testBundlePth, filepath.Base(hostBundlePth))
+}
+
+// generateXctestrunForBuildDir writes a minimal xctestrun file for the test bundles found in the given build dir.
+func generateXctestrunForBuildDir(testRootDir, buildDir string) error {
+	xctestrun, err := createXctestrun(testRootDir, buildDir)
+	if err != nil {
+		return fmt.Errorf("failed to generate xctestrun: %w", err)
+	}
+
+	xctestrunPth := filepath.Join(testRootDir, xctestrunFileName(buildDir, xctestrun))
+	if err := writeXctestrun(xctestrunPth, xctestrun, plist.XMLFormat); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func xctestrunFileName(buildDir string, xctestrun map[string]any) string {
+	name := "Tests"
+	if plan, ok := xctestrun["TestPlan"].(map[string]any); ok {
+		if planName, ok := plan["Name"].(string); ok && planName != "" {
+			name = planName
+		}
+	}
+
+	sdk := buildDir
+	if idx := strings.LastIndex(buildDir, "-"); idx != -1 {
+		sdk = buildDir[idx+1:]
+	}
+
+	return fmt.Sprintf("%s_%s-arm64.xctestrun", name, sdk)
+}
+
+/*
+createXctestrun builds a FormatVersion 2 xctestrun with a single test configuration from the bundles' Info.plists:
+- *-Runner.app/PlugIns/*.xctest bundles become UI test targets, the app under test is the first non runner app
+- <App>.app/PlugIns/*.xctest bundles become app hosted unit test targets
+*/
+func createXctestrun(testRootDir, buildDir string) (map[string]any, error) {
+	entries, err := os.ReadDir(filepath.Join(testRootDir, buildDir))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read build dir: %w", err)
+	}
+
+	var apps, runners []string
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".app" {
+			continue
+		}
+		if strings.HasSuffix(entry.Name(), uiTestRunnerSuffix) {
+			runners = append(runners, entry.Name())
+		} else {
+			apps = append(apps, entry.Name())
+		}
+	}
+	sort.Strings(apps)
+	sort.Strings(runners)
+
+	var testTargets []any
+	var dependentProductPaths []any
+	var appUnderTest string
+	if len(apps) > 0 {
+		appUnderTest = apps[0]
+	}
+
+	for _, app := range apps {
+		appPth := filepath.Join(testRootDir, buildDir, app)
+		appRelPth := fmt.Sprintf("%s/%s/%s", testRootPrefix, buildDir, app)
+		dependentProductPaths = append(dependentProductPaths, appRelPth)
+
+		targets, err := createTestTargets(appPth, appRelPth, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range targets {
+			target["IsAppHostedTestBundle"] = true
+			testTargets = append(testTargets, target)
+		}
+	}
+
+	for _, runner := range runners {
+		if appUnderTest == "" {
+			return nil, fmt.Errorf("UI test runner (%s) found, but no app under test", runner)
+		}
+
+		runnerPth := filepath.Join(testRootDir, buildDir, runner)
+		runnerRelPth := fmt.Sprintf("%s/%s/%s", testRootPrefix, buildDir, runner)
+		dependentProductPaths = append(dependentProductPaths, runnerRelPth)
+
+		targets, err := createTestTargets(runnerPth, runnerRelPth, fmt.Sprintf("%s/%s/%s", testRootPrefix, buildDir, appUnderTest))
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range targets {
+			target["IsUITestBundle"] = true
+			target["IsXCTRunnerHostedTestBundle"] = true
+			testTargets = append(testTargets, target)
+		}
+	}
+
+	if len(testTargets) == 0 {
+		return nil, fmt.Errorf("no test bundles found in %s", buildDir)
+	}
+
+	for _, target := range testTargets {
+		target.(map[string]any)["DependentProductPaths"] = dependentProductPaths
+	}
+
+	planName := strings.TrimSuffix(appUnderTest, ".app")
+	if planName == "" {
+		planName = strings.TrimSuffix(runners[0], uiTestRunnerSuffix)
+	}
+
+	return map[string]any{
+		"TestConfigurations": []any{
+			map[string]any{
+				"Name":        "Configuration 1",
+				"TestTargets": testTargets,
+			},
+		},
+		"TestPlan": map[string]any{
+			"IsDefault": true,
+			"Name":      planName,
+		},
+		"__xctestrun_metadata__": map[string]any{
+			"FormatVersion": 2,
+		},
+	}, nil
+}
+
+// createTestTargets creates an xctestrun test target for each .xctest bundle in the host bundle's PlugIns folder.
+func createTestTargets(hostPth, hostRelPth, uiTargetAppRelPth string) ([]map[string]any, error) {
+	hostInfo, err := readBundleInfo(hostPth)
+	if err != nil {
+		return nil, err
+	}
+
+	plugIns, err := os.ReadDir(filepath.Join(hostPth, "PlugIns"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read PlugIns folder of %s: %w", filepath.Base(hostPth), err)
+	}
+
+	var targets []map[string]any
+	for _, plugIn := range plugIns {
+		if filepath.Ext(plugIn.Name()) != ".xctest" {
+			continue
+		}
+
+		testBundleInfo, err := readBundleInfo(filepath.Join(hostPth, "PlugIns", plugIn.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		name := testBundleInfo["CFBundleName"]
+		if name == "" {
+			name = strings.TrimSuffix(plugIn.Name(), ".xctest")
+		}
+
+		target := map[string]any{
+			"BlueprintName":            name,
+			"ProductModuleName":        name,
+			"TestBundlePath":           fmt.Sprintf("%s/PlugIns/%s", testHostPrefix, plugIn.Name()),
+			"TestHostPath":             hostRelPth,
+			"TestHostBundleIdentifier": hostInfo["CFBundleIdentifier"],
+		}
+		if uiTargetAppRelPth != "" {
+			target["UITargetAppPath"] = uiTargetAppRelPth
+		}
+
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+// readBundleInfo reads the string values of a bundle's (app or xctest) Info.plist.
+func readBundleInfo(bundlePth string) (map[string]string, error) {
+	infoPlistPth := filepath.Join(bundlePth, "Info.plist")
+	content, err := os.ReadFile(infoPlistPth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Info.plist of %s: %w", filepath.Base(bundlePth), err)
+	}
+
+	var info map[string]any
+	if _, err := plist.Unmarshal(content, &info); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Info.plist of %s: %w", filepath.Base(bundlePth), err)
+	}
+
+	stringValues := map[string]string{}
+	for key, value := range info {
+		if s, ok := value.(string); ok {
+			stringValues[key] = s
+		}
+	}
+
+	return stringValues, nil
+}
+
+// linkDirContent creates dstDir and symlinks each entry of srcDir into it, zip follows the links when archiving.
+func linkDirContent(srcDir, dstDir string) error {
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dstDir, err)
+	}
+
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", srcDir, err)
+	}
+
+	for _, entry := range entries {
+		if err := linkIntoDir(filepath.Join(srcDir, entry.Name()), dstDir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func linkIntoDir(pth, dir string) error {
+	absPth, err := filepath.Abs(pth)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path of %s: %w", pth, err)
+	}
+
+	if err := os.Symlink(absPth, filepath.Join(dir, filepath.Base(pth))); err != nil {
+		return fmt.Errorf("failed to link %s into the test bundle: %w", filepath.Base(pth), err)
+	}
+
+	return nil
+}
+
+// isDeviceBuildDir reports whether a Build/Products subfolder contains device builds (for example Debug-iphoneos).
+func isDeviceBuildDir(name string) bool {
+	return strings.HasSuffix(name, "-iphoneos") || strings.HasSuffix(name, "-watchos")
+}
+
+/*
+selectDeviceBuildDir picks the test bundle's build folder from the products dir's device build folders.
+iOS builds (-iphoneos) are preferred to watchOS builds (-watchos), and the remaining folder has to be unambiguous:
+for example a products dir with both Debug-iphoneos and Release-iphoneos is rejected.
+*/
+func selectDeviceBuildDir(buildDirs []string) (string, error) {
+	if len(buildDirs) == 0 {
+		return "", fmt.Errorf("no device build folder (for example Debug-iphoneos) found")
+	}
+
+	var candidates []string
+	for _, buildDir := range buildDirs {
+		if strings.HasSuffix(buildDir, "-iphoneos") {
+			candidates = append(candidates, buildDir)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = buildDirs
+	}
+
+	if len(candidates) > 1 {
+		sort.Strings(candidates)
+		return "", fmt.Errorf("multiple device build folders found (%s), keep only the one to test", strings.Join(candidates, ", "))
+	}
+
+	return candidates[0], nil
+}
+
+func splitPaths(list string) []string {
+	var pths []string
+	for _, line := range strings.Split(list, "\n") {
+		for _, pth := range strings.Split(line, "|") {
+			pth = strings.TrimSpace(pth)
+			if pth != "" {
+				pths = append(pths, pth)
+			}
+		}
+	}
+	return pths
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-plist"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_createXctestrun(t *testing.T) {
+	// Given
+	testRootDir := t.TempDir()
+	buildDir := "Debug-iphoneos"
+	writeBundleInfo(t, filepath.Join(testRootDir, buildDir, "BullsEye.app"), map[string]any{"CFBundleIdentifier": "io.bitrise.BullsEye", "CFBundleName": "BullsEye"})
+	writeBundleInfo(t, filepath.Join(testRootDir, buildDir, "BullsEye.app", "PlugIns", "BullsEyeTests.xctest"), map[string]any{"CFBundleName": "BullsEyeTests"})
+	writeBundleInfo(t, filepath.Join(testRootDir, buildDir, "BullsEyeUITests-Runner.app"), map[string]any{"CFBundleIdentifier": "io.bitrise.BullsEyeUITests.xctrunner"})
+	writeBundleInfo(t, filepath.Join(testRootDir, buildDir, "BullsEyeUITests-Runner.app", "PlugIns", "BullsEyeUITests.xctest"), map[string]any{"CFBundleName": "BullsEyeUITests"})
+
+	// When
+	xctestrun, err := createXctestrun(testRootDir, buildDir)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "BullsEye_iphoneos-arm64.xctestrun", xctestrunFileName(buildDir, xctestrun))
+
+	dependentProductPaths := []any{"__TESTROOT__/Debug-iphoneos/BullsEye.app", "__TESTROOT__/Debug-iphoneos/BullsEyeUITests-Runner.app"}
+	wantTestTargets := []any{
+		map[string]any{
+			"BlueprintName":            "BullsEyeTests",
+			"ProductModuleName":        "BullsEyeTests",
+			"TestBundlePath":           "__TESTHOST__/PlugIns/BullsEyeTests.xctest",
+			"TestHostPath":             "__TESTROOT__/Debug-iphoneos/BullsEye.app",
+			"TestHostBundleIdentifier": "io.bitrise.BullsEye",
+			"IsAppHostedTestBundle":    true,
+			"DependentProductPaths":    dependentProductPaths,
+		},
+		map[string]any{
+			"BlueprintName":               "BullsEyeUITests",
+			"ProductModuleName":           "BullsEyeUITests",
+			"TestBundlePath":              "__TESTHOST__/PlugIns/BullsEyeUITests.xctest",
+			"TestHostPath":                "__TESTROOT__/Debug-iphoneos/BullsEyeUITests-Runner.app",
+			"TestHostBundleIdentifier":    "io.bitrise.BullsEyeUITests.xctrunner",
+			"UITargetAppPath":             "__TESTROOT__/Debug-iphoneos/BullsEye.app",
+			"IsUITestBundle":              true,
+			"IsXCTRunnerHostedTestBundle": true,
+			"DependentProductPaths":       dependentProductPaths,
+		},
+	}
+	testConfiguration := xctestrun["TestConfigurations"].([]any)[0].(map[string]any)
+	require.Equal(t, wantTestTargets, testConfiguration["TestTargets"])
+
+	// The generated xctestrun is accepted by the quarantine logic
+	_, err = addSkippedTestsToXctestrun(xctestrun, map[string][]string{"BullsEyeUITests": {"BullsEyeUITests/testGameStyleSwitch"}})
+	require.NoError(t, err)
+}
+
+func Test_topLevelBundleOfTestBundle(t *testing.T) {
+	appPth := filepath.Join("Debug-iphoneos", "BullsEye.app")
+
+	tests := []struct {
+		name          string
+		testBundlePth string
+		want          string
+		wantErr       bool
+	}{
+		{
+			name:          "UI test runner app",
+			testBundlePth: filepath.Join("Debug-iphoneos", "BullsEyeUITests-Runner.app"),
+			want:          filepath.Join("Debug-iphoneos", "BullsEyeUITests-Runner.app"),
+		},
+		{
+			name:          "UI test bundle in runner app",
+			testBundlePth: filepath.Join("Debug-iphoneos", "BullsEyeUITests-Runner.app", "PlugIns", "BullsEyeUITests.xctest"),
+			want:          filepath.Join("Debug-iphoneos", "BullsEyeUITests-Runner.app"),
+		},
+		{
+			name:          "Unit test bundle in app under test",
+			testBundlePth: filepath.Join("Debug-iphoneos", "BullsEye.app", "PlugIns", "BullsEyeTests.xctest"),
+			want:          "",
+		},
+		{
+			name:          "Standalone test bundle",
+			testBundlePth: filepath.Join("Debug-iphoneos", "BullsEyeTests.xctest"),
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rootDir := t.TempDir()
+			require.NoError(t, os.MkdirAll(filepath.Join(rootDir, tt.testBundlePth), 0755))
+
+			got, err := topLevelBundleOfTestBundle(filepath.Join(rootDir, appPth), filepath.Join(rootDir, tt.testBundlePth))
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			if tt.want != "" {
+				tt.want = filepath.Join(rootDir, tt.want)
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_selectDeviceBuildDir(t *testing.T) {
+	tests := []struct {
+		name      string
+		buildDirs []string
+		want      string
+		wantErr   string
+	}{
+		{
+			name:      "Single iOS build folder",
+			buildDirs: []string{"Debug-iphoneos"},
+			want:      "Debug-iphoneos",
+		},
+		{
+			name:      "iOS build folder is preferred to watchOS build folder",
+			buildDirs: []string{"Debug-watchos", "Debug-iphoneos"},
+			want:      "Debug-iphoneos",
+		},
+		{
+			name:      "Single watchOS build folder",
+			buildDirs: []string{"Debug-watchos"},
+			want:      "Debug-watchos",
+		},
+		{
+			name:      "Multiple iOS build folders",
+			buildDirs: []string{"Release-iphoneos", "Debug-watchos", "Debug-iphoneos"},
+			wantErr:   "multiple device build folders found (Debug-iphoneos, Release-iphoneos)",
+		},
+		{
+			name:    "No build folder",
+			wantErr: "no device build folder",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectDeviceBuildDir(tt.buildDirs)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func writeBundleInfo(t *testing.T, bundlePth string, info map[string]any) {
+	require.NoError(t, os.MkdirAll(bundlePth, 0755))
+
+	content, err := plist.Marshal(info, plist.BinaryFormat)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(bundlePth, "Info.plist"), content, 0644))
+}
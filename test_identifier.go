@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/output"
+)
+
+/*
+The xctestrun's SkipTestIdentifiers and OnlyTestIdentifiers identify:
+  - XCTest tests as TestClass/testMethod, a whole class as TestClass
+  - Swift Testing tests as Suite/function(label:), nested suites as Outer/Inner, module level (global) functions without suite.
+    The cases of a parameterized test can't be identified one by one, the identifier covers all of its cases.
+*/
+const (
+	testKindXCTest = "xctest"
+	// testKindSwiftTesting tests are declared with the @Test macro.
+	testKindSwiftTesting = "swift-testing"
+	// testKindUnknown tests look like both kinds: testMethod() in a top level class or suite.
+	testKindUnknown = "unknown"
+)
+
+/*
+testKindOf tells the test's kind by its class (suite) and test case (function) name: XCTest test methods
+have no arguments, their name starts with test, and they are declared in top level classes.
+A test without class is a module level Swift Testing function only if its name tells it apart from an XCTest method.
+*/
+func testKindOf(className, testCaseName string) string {
+	name, arguments, hasArguments := strings.Cut(testCaseName, "(")
+	switch {
+	case strings.ContainsAny(className, "./"):
+		return testKindSwiftTesting
+	case !strings.HasPrefix(name, "test"):
+		return testKindSwiftTesting
+	case hasArguments && arguments != ")":
+		return testKindSwiftTesting
+	case hasArguments:
+		return testKindUnknown
+	default:
+		return testKindXCTest
+	}
+}
+
+// xctestIdentifier returns the XCTest test method's identifier: TestClass/testMethod (`()` suffix removed).
+func xctestIdentifier(className, testCaseName string) string {
+	return className + "/" + strings.TrimSuffix(testCaseName, "()")
+}
+
+// swiftTestingIdentifier returns the Swift Testing function's identifier: Outer/Inner/function(label:).
+func swiftTestingIdentifier(suite, testCaseName string) string {
+	function := output.TestFunctionName(testCaseName)
+	if suite == "" {
+		return function
+	}
+	return swiftTestingSuitePath(suite) + "/" + function
+}
+
+// swiftTestingSuitePath returns the nested suite's path: Outer.Inner -> Outer/Inner.
+func swiftTestingSuitePath(suite string) string {
+	return strings.ReplaceAll(suite, ".", "/")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_testKindOf(t *testing.T) {
+	tests := []struct {
+		className    string
+		testCaseName string
+		want         string
+	}{
+		{className: "BullsEyeTests", testCaseName: "testScore", want: testKindXCTest},
+		{className: "BullsEyeTests", testCaseName: "testScore()", want: testKindUnknown},
+		{className: "ScoreTests", testCaseName: "scoreIsCalculated()", want: testKindSwiftTesting},
+		{className: "ScoreTests", testCaseName: "testScore(points:)", want: testKindSwiftTesting},
+		{className: "GameTests.RoundTests", testCaseName: "testRound()", want: testKindSwiftTesting},
+		{className: "", testCaseName: "moduleLevel()", want: testKindSwiftTesting},
+		{className: "", testCaseName: "testModuleLevel()", want: testKindUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.className+"/"+tt.testCaseName, func(t *testing.T) {
+			require.Equal(t, tt.want, testKindOf(tt.className, tt.testCaseName))
+		})
+	}
+}
+
+func Test_swiftTestingIdentifier(t *testing.T) {
+	require.Equal(t, "ScoreTests/scoreIsCalculated(points:)", swiftTestingIdentifier("ScoreTests", "scoreIsCalculated(points:)"))
+	require.Equal(t, "ScoreTests/scoreIsCalculated(points:)", swiftTestingIdentifier("ScoreTests", "scoreIsCalculated(points:) (points: 10)"))
+	require.Equal(t, "GameTests/RoundTests/roundStarts()", swiftTestingIdentifier("GameTests.RoundTests", "roundStarts"))
+	require.Equal(t, "moduleLevel()", swiftTestingIdentifier("", "moduleLevel()"))
+}
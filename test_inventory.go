@@ -0,0 +1,592 @@
+package main
+
+import (
+	"debug/macho"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-utils/sliceutil"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/output"
+)
+
+// testInventory lists the test bundle's tests, before running them.
+type testInventory struct {
+	// tests are the XCTest test methods by test classes by test targets (BlueprintNames).
+	tests map[string]map[string][]string
+	// swiftTests are the Swift Testing functions (scoreIsCalculated(points:)) by suites (GameTests.RoundTests,
+	// empty for module level functions) by test targets.
+	swiftTests map[string]map[string][]string
+	// skippedTests are the test targets' SkipTestIdentifiers in the test bundle's xctestrun files.
+	skippedTests map[string][]string
+	// onlyTests are the test targets' OnlyTestIdentifiers in the test bundle's xctestrun files.
+	onlyTests map[string][]string
+}
+
+/*
+readTestInventory lists the test targets from the test bundle's xctestrun files, and their tests from the .xctest
+binaries: the XCTest test classes and methods from the ObjC metadata, see readTestClasses, and the Swift Testing
+suites and functions from the symbol table, see readSwiftTestingSuites.
+*/
+func readTestInventory(testBundleZipPth string) (testInventory, error) {
+	testBundlePth, err := unzipTestBundle(testBundleZipPth)
+	if err != nil {
+		return testInventory{}, err
+	}
+
+	entries, err := os.ReadDir(testBundlePth)
+	if err != nil {
+		return testInventory{}, fmt.Errorf("failed to read unzipped test bundle dir: %w", err)
+	}
+
+	inventory := testInventory{
+		tests:        map[string]map[string][]string{},
+		swiftTests:   map[string]map[string][]string{},
+		skippedTests: map[string][]string{},
+		onlyTests:    map[string][]string{},
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".xctestrun" {
+			continue
+		}
+
+		xctestrun, _, err := parseXctestrun(filepath.Join(testBundlePth, entry.Name()))
+		if err != nil {
+			return testInventory{}, err
+		}
+
+		for _, testTarget := range xctestrunTestTargets(xctestrun) {
+			name, ok := testTarget["BlueprintName"].(string)
+			if !ok {
+				continue
+			}
+
+			inventory.skippedTests[name] = appendTestIdentifiers(inventory.skippedTests[name], testTarget["SkipTestIdentifiers"])
+			inventory.onlyTests[name] = appendTestIdentifiers(inventory.onlyTests[name], testTarget["OnlyTestIdentifiers"])
+
+			binaryPth, ok := testTargetBinaryPath(testTarget, testBundlePth)
+			if !ok {
+				continue
+			}
+
+			classes, suites, err := readTestBinary(binaryPth)
+			if err != nil {
+				return testInventory{}, fmt.Errorf("failed to read tests of %s: %w", name, err)
+			}
+
+			if inventory.tests[name] == nil {
+				inventory.tests[name] = map[string][]string{}
+				inventory.swiftTests[name] = map[string][]string{}
+			}
+			for class, methods := range classes {
+				inventory.tests[name][class] = methods
+			}
+			for suite, functions := range suites {
+				inventory.swiftTests[name][suite] = functions
+			}
+		}
+	}
+
+	return inventory, nil
+}
+
+// appendTestIdentifiers appends the xctestrun's test identifiers to identifiers, without duplicates.
+func appendTestIdentifiers(identifiers []string, xctestrunIdentifiers interface{}) []string {
+	values, _ := xctestrunIdentifiers.([]interface{})
+	for _, value := range values {
+		if identifier, ok := value.(string); ok && !sliceutil.IsStringInSlice(identifier, identifiers) {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+	return identifiers
+}
+
+// targets returns the inventory's test targets in alphabetical order.
+func (i testInventory) targets() []string {
+	var targets []string
+	for target := range i.tests {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	return targets
+}
+
+// classes returns the test target's XCTest test classes in alphabetical order.
+func (i testInventory) classes(target string) []string {
+	var classes []string
+	for class := range i.tests[target] {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+// suites returns the test target's Swift Testing suites in alphabetical order, the module level functions' suite is empty.
+func (i testInventory) suites(target string) []string {
+	var suites []string
+	for suite := range i.swiftTests[target] {
+		suites = append(suites, suite)
+	}
+	sort.Strings(suites)
+	return suites
+}
+
+// testCount returns the number of XCTest test methods and Swift Testing functions in the inventory.
+func (i testInventory) testCount() int {
+	count := 0
+	for _, classes := range i.tests {
+		for _, methods := range classes {
+			count += len(methods)
+		}
+	}
+	for _, suites := range i.swiftTests {
+		for _, functions := range suites {
+			count += len(functions)
+		}
+	}
+	return count
+}
+
+/*
+expectedTests returns the inventory's tests expected to run, as TestTarget/TestClass/testMethod
+(TestTarget/Suite/function(label:) for Swift Testing tests): the tests not skipped by the xctestrun files or by
+skippedTestsByTarget, and only the selected tests if the xctestrun files select tests.
+The module level Swift Testing functions are not expected, their test results name no class to compare to.
+*/
+func (i testInventory) expectedTests(skippedTestsByTarget map[string][]string) []string {
+	var tests []string
+	for _, target := range i.targets() {
+		skippedTests := append(append([]string{}, i.skippedTests[target]...), skippedTestsByTarget[target]...)
+		onlyTests := i.onlyTests[target]
+
+		for _, class := range i.classes(target) {
+			for _, method := range i.tests[target][class] {
+				if matchesTestIdentifiers(class, method, skippedTests) {
+					continue
+				}
+				if len(onlyTests) > 0 && !matchesTestIdentifiers(class, method, onlyTests) {
+					continue
+				}
+				tests = append(tests, target+"/"+class+"/"+method)
+			}
+		}
+
+		for _, suite := range i.suites(target) {
+			if suite == "" {
+				continue
+			}
+
+			for _, function := range i.swiftTests[target][suite] {
+				if matchesSwiftTestingIdentifiers(suite, function, skippedTests) {
+					continue
+				}
+				if len(onlyTests) > 0 && !matchesSwiftTestingIdentifiers(suite, function, onlyTests) {
+					continue
+				}
+				tests = append(tests, target+"/"+suite+"/"+function)
+			}
+		}
+	}
+	return tests
+}
+
+// matchesTestIdentifiers reports whether any of the identifiers (TestClass or TestClass/testMethod) selects the test method.
+func matchesTestIdentifiers(class, method string, identifiers []string) bool {
+	for _, identifier := range identifiers {
+		identifier = strings.TrimSuffix(identifier, "()")
+		if identifier == class || identifier == class+"/"+method {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesSwiftTestingIdentifiers reports whether any of the identifiers (Outer, Outer/Inner or Outer/Inner/function(label:)) selects the Swift Testing function.
+func matchesSwiftTestingIdentifiers(suite, function string, identifiers []string) bool {
+	suitePath := swiftTestingSuitePath(suite)
+	for _, identifier := range identifiers {
+		if identifier == swiftTestingIdentifier(suite, function) || (suitePath != "" && strings.HasPrefix(suitePath+"/", identifier+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// export converts the inventory to the exported format.
+func (i testInventory) export() output.TestInventory {
+	inventory := output.TestInventory{TestCount: i.testCount()}
+	for _, target := range i.targets() {
+		exportedTarget := output.TestInventoryTarget{Name: target, SkippedTests: i.skippedTests[target]}
+		for _, class := range i.classes(target) {
+			exportedTarget.Classes = append(exportedTarget.Classes, output.TestInventoryClass{Name: class, Methods: i.tests[target][class]})
+		}
+		for _, suite := range i.suites(target) {
+			exportedTarget.SwiftTestingSuites = append(exportedTarget.SwiftTestingSuites, output.TestInventoryClass{Name: suite, Methods: i.swiftTests[target][suite]})
+		}
+		inventory.Targets = append(inventory.Targets, exportedTarget)
+	}
+	return inventory
+}
+
+// testTargetBinaryPath resolves the test target's TestBundlePath (__TESTHOST__/PlugIns/BullsEyeTests.xctest) to the bundle's executable.
+func testTargetBinaryPath(testTarget map[string]interface{}, testRoot string) (string, bool) {
+	bundlePth, ok := testTarget["TestBundlePath"].(string)
+	if !ok {
+		return "", false
+	}
+
+	hostPth, _ := testTarget["TestHostPath"].(string)
+	hostPth = strings.ReplaceAll(hostPth, "__TESTROOT__", testRoot)
+	bundlePth = strings.ReplaceAll(bundlePth, "__TESTROOT__", testRoot)
+	bundlePth = strings.ReplaceAll(bundlePth, "__TESTHOST__", hostPth)
+
+	return filepath.Join(bundlePth, strings.TrimSuffix(filepath.Base(bundlePth), ".xctest")), true
+}
+
+/*
+readTestBinary returns the XCTest test methods by test classes, and the Swift Testing functions by suites of a
+Mach-O test binary.
+*/
+func readTestBinary(binaryPth string) (map[string][]string, map[string][]string, error) {
+	file, closer, err := openMachO(binaryPth)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err := closer.Close(); err != nil {
+			log.Warnf("Failed to close %s: %s", binaryPth, err)
+		}
+	}()
+
+	image := machoImage{file: file}
+	if err := image.readImports(); err != nil {
+		return nil, nil, err
+	}
+
+	classes, err := readTestClasses(image)
+	if err != nil {
+		return nil, nil, err
+	}
+	return classes, readSwiftTestingSuites(file), nil
+}
+
+// xctestCaseClassSymbol is the symbol of the XCTestCase class, bound from the XCTest framework.
+const xctestCaseClassSymbol = "_OBJC_CLASS_$_XCTestCase"
+
+/*
+readTestClasses returns the test methods by test classes of a Mach-O test binary.
+
+The classes are read from the ObjC class list: XCTest discovers the test methods through the ObjC runtime,
+so the Swift XCTestCase subclasses are listed too. A test method is an instance method without arguments,
+whose name starts with test, a class inherits the test methods of its superclasses in the binary.
+
+Only the XCTestCase subclasses are test classes. The superclass chain is followed in the binary, and where it leaves
+the binary the bound superclass has to be XCTestCase: subclasses of a test class from another image (a shared test
+helper framework) are not listed.
+*/
+func readTestClasses(image machoImage) (map[string][]string, error) {
+	file := image.file
+	classes := map[string][]string{}
+
+	classList := file.Section("__objc_classlist")
+	if classList == nil {
+		return classes, nil
+	}
+
+	data, err := classList.Data()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ObjC class list: %w", err)
+	}
+
+	var classAddrs []uint64
+	addrToClass := map[uint64]objcClass{}
+	for offset := 0; offset+8 <= len(data); offset += 8 {
+		classAddr := untagPointer(file.ByteOrder.Uint64(data[offset:]))
+		if classAddr == 0 {
+			continue
+		}
+
+		class, err := image.objcClass(classAddr)
+		if err != nil {
+			return nil, err
+		}
+		classAddrs = append(classAddrs, classAddr)
+		addrToClass[classAddr] = class
+	}
+
+	for _, classAddr := range classAddrs {
+		var testMethods []string
+		isTestCase := false
+		// The depth limit guards against malformed cycles
+		for addr, depth := classAddr, 0; depth < 64; depth++ {
+			class, ok := addrToClass[addr]
+			if !ok {
+				break
+			}
+
+			for _, method := range class.methods {
+				if strings.HasPrefix(method, "test") && !strings.Contains(method, ":") && !sliceutil.IsStringInSlice(method, testMethods) {
+					testMethods = append(testMethods, method)
+				}
+			}
+
+			if class.superclass == 0 {
+				isTestCase = class.superclassSymbol == xctestCaseClassSymbol
+				break
+			}
+			addr = class.superclass
+		}
+		if !isTestCase || len(testMethods) == 0 {
+			continue
+		}
+
+		sort.Strings(testMethods)
+		classes[objcClassName(addrToClass[classAddr].name)] = testMethods
+	}
+
+	return classes, nil
+}
+
+// openMachO opens the Mach-O file, the arm64 slice of a universal binary.
+func openMachO(pth string) (*macho.File, io.Closer, error) {
+	if fat, err := macho.OpenFat(pth); err == nil {
+		for _, arch := range fat.Arches {
+			if arch.Cpu == macho.CpuArm64 {
+				return arch.File, fat, nil
+			}
+		}
+		if len(fat.Arches) == 0 {
+			if err := fat.Close(); err != nil {
+				log.Warnf("Failed to close %s: %s", pth, err)
+			}
+			return nil, nil, fmt.Errorf("universal binary without architectures")
+		}
+		return fat.Arches[0].File, fat, nil
+	}
+
+	file, err := macho.Open(pth)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open Mach-O file: %w", err)
+	}
+	return file, file, nil
+}
+
+// machoImage reads a 64-bit Mach-O file's sections by virtual memory addresses.
+type machoImage struct {
+	file *macho.File
+	// chainedImports are the symbols bound by chained fixups, by import ordinal.
+	chainedImports []string
+	// binds are the symbols bound by the dyld info's bind opcodes, by address.
+	binds map[uint64]string
+}
+
+func (m machoImage) read(addr, size uint64) ([]byte, error) {
+	for _, section := range m.file.Sections {
+		if addr < section.Addr || addr+size > section.Addr+section.Size {
+			continue
+		}
+
+		data := make([]byte, size)
+		if _, err := section.ReadAt(data, int64(addr-section.Addr)); err != nil {
+			return nil, fmt.Errorf("failed to read 0x%x: %w", addr, err)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("address 0x%x is not in any section", addr)
+}
+
+func (m machoImage) uint32(addr uint64) (uint32, error) {
+	data, err := m.read(addr, 4)
+	if err != nil {
+		return 0, err
+	}
+	return m.file.ByteOrder.Uint32(data), nil
+}
+
+func (m machoImage) pointer(addr uint64) (uint64, error) {
+	data, err := m.read(addr, 8)
+	if err != nil {
+		return 0, err
+	}
+	return untagPointer(m.file.ByteOrder.Uint64(data)), nil
+}
+
+func (m machoImage) cString(addr uint64) (string, error) {
+	for _, section := range m.file.Sections {
+		if addr < section.Addr || addr >= section.Addr+section.Size {
+			continue
+		}
+
+		data := make([]byte, section.Addr+section.Size-addr)
+		if _, err := section.ReadAt(data, int64(addr-section.Addr)); err != nil {
+			return "", fmt.Errorf("failed to read 0x%x: %w", addr, err)
+		}
+
+		end := strings.IndexByte(string(data), 0)
+		if end == -1 {
+			return "", fmt.Errorf("unterminated string at 0x%x", addr)
+		}
+		return string(data[:end]), nil
+	}
+	return "", fmt.Errorf("address 0x%x is not in any section", addr)
+}
+
+/*
+objcClass is an ObjC class of the binary, the superclass is 0 if it is in another image (XCTestCase),
+then superclassSymbol is the bound superclass's symbol (_OBJC_CLASS_$_XCTestCase), if the binary binds it.
+*/
+type objcClass struct {
+	name             string
+	superclass       uint64
+	superclassSymbol string
+	methods          []string
+}
+
+// objcClass returns the name, the superclass and the instance method names of the ObjC class (objc_class) at addr.
+func (m machoImage) objcClass(addr uint64) (objcClass, error) {
+	// objc_class: isa, superclass, cache, vtable, data (class_ro_t, the low bits are Swift flags)
+	superclass, err := m.pointer(addr + 8)
+	if err != nil {
+		return objcClass{}, fmt.Errorf("failed to read ObjC class: %w", err)
+	}
+	var superclassSymbol string
+	if superclass == 0 {
+		if superclassSymbol, _, err = m.boundSymbol(addr + 8); err != nil {
+			return objcClass{}, fmt.Errorf("failed to read ObjC class: %w", err)
+		}
+	}
+	roAddr, err := m.pointer(addr + 32)
+	if err != nil {
+		return objcClass{}, fmt.Errorf("failed to read ObjC class: %w", err)
+	}
+	roAddr &^= 7
+
+	// class_ro_t: flags, instanceStart, instanceSize, reserved, ivarLayout, name, baseMethods
+	nameAddr, err := m.pointer(roAddr + 24)
+	if err != nil {
+		return objcClass{}, fmt.Errorf("failed to read ObjC class name: %w", err)
+	}
+	name, err := m.cString(nameAddr)
+	if err != nil {
+		return objcClass{}, fmt.Errorf("failed to read ObjC class name: %w", err)
+	}
+
+	methodListAddr, err := m.pointer(roAddr + 32)
+	if err != nil {
+		return objcClass{}, fmt.Errorf("failed to read ObjC class (%s) methods: %w", name, err)
+	}
+	if methodListAddr == 0 {
+		return objcClass{name: name, superclass: superclass, superclassSymbol: superclassSymbol}, nil
+	}
+
+	methods, err := m.methodNames(methodListAddr)
+	if err != nil {
+		return objcClass{}, fmt.Errorf("failed to read ObjC class (%s) methods: %w", name, err)
+	}
+
+	return objcClass{name: name, superclass: superclass, superclassSymbol: superclassSymbol, methods: methods}, nil
+}
+
+// methodNames returns the selectors of the ObjC method list (method_list_t) at addr, in the pointer or in the relative format.
+func (m machoImage) methodNames(addr uint64) ([]string, error) {
+	entsizeAndFlags, err := m.uint32(addr)
+	if err != nil {
+		return nil, err
+	}
+	count, err := m.uint32(addr + 4)
+	if err != nil {
+		return nil, err
+	}
+
+	relative := entsizeAndFlags&0x80000000 != 0
+	entsize := uint64(entsizeAndFlags & 0xfffc)
+	if entsize == 0 || count > 1<<16 {
+		return nil, fmt.Errorf("invalid method list at 0x%x", addr)
+	}
+
+	var names []string
+	for i := uint64(0); i < uint64(count); i++ {
+		entryAddr := addr + 8 + i*entsize
+
+		var nameAddr uint64
+		if relative {
+			// The relative method's name is an offset to its selector reference
+			offset, err := m.uint32(entryAddr)
+			if err != nil {
+				return nil, err
+			}
+			if nameAddr, err = m.pointer(uint64(int64(entryAddr) + int64(int32(offset)))); err != nil {
+				return nil, err
+			}
+		} else if nameAddr, err = m.pointer(entryAddr); err != nil {
+			return nil, err
+		}
+
+		name, err := m.cString(nameAddr)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+/*
+untagPointer returns the address of a pointer in the binary, which is a plain address or a chained fixup rebase
+(DYLD_CHAINED_PTR_64: the target is the low 36 bits). Binds to other images are returned as 0.
+*/
+func untagPointer(pointer uint64) uint64 {
+	if pointer>>63 == 1 {
+		return 0
+	}
+	return pointer & (1<<36 - 1)
+}
+
+/*
+objcClassName returns the Swift class name of a Swift class's ObjC runtime name: _TtC13BullsEyeTests9GameTests -> GameTests,
+the nested classes are separated by a dot: _TtCC13BullsEyeTests5Outer5Inner -> Outer.Inner. Other names are returned as is.
+*/
+func objcClassName(name string) string {
+	rest, ok := strings.CutPrefix(name, "_Tt")
+	if !ok {
+		return name
+	}
+
+	// A context kind (Class, struct (V), enum (O)) for each nesting level, followed by the module and the names
+	kinds := 0
+	for kinds < len(rest) && strings.ContainsRune("CVO", rune(rest[kinds])) {
+		kinds++
+	}
+	if kinds == 0 || rest[kinds-1] != 'C' {
+		return name
+	}
+	rest = rest[kinds:]
+
+	var parts []string
+	for rest != "" {
+		digits := 0
+		for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+			digits++
+		}
+
+		length, err := strconv.Atoi(rest[:digits])
+		if err != nil || digits+length > len(rest) {
+			return name
+		}
+
+		parts = append(parts, rest[digits:digits+length])
+		rest = rest[digits+length:]
+	}
+
+	if len(parts) != kinds+1 {
+		return name
+	}
+	return strings.Join(parts[1:], ".")
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/output"
+)
+
+func Test_readTestBinary(t *testing.T) {
+	image := newTestMachOImage()
+
+	baseClass := image.objcClass("_TtC13BullsEyeTests11BaseUITests", image.bind("_OBJC_CLASS_$_XCTestCase"), image.methodList("testLaunch", "setUp"))
+	image.objcClass("_TtC13BullsEyeTests9GameTests", baseClass, image.relativeMethodList("testScore", "testStart", "helper:", "performanceScore"))
+	// Not an XCTestCase subclass
+	image.objcClass("BullsEyeHelper", image.bind("_OBJC_CLASS_$_NSObject"), image.methodList("configure", "testConnection"))
+	image.objcClass("_TtCC13BullsEyeTests5Outer5Inner", image.bind("_OBJC_CLASS_$_XCTestCase"), 0)
+	image.symbols(
+		"_$s13BullsEyeTests05ScoreC0V17scoreIsCalculated4TestfMp_22$test_container__functionfMu_VN",
+		"_$s13BullsEyeTests05ScoreC0V17scoreIsCalculated6points10multiplierySi_SitF",
+		"_$s13BullsEyeTests05ScoreC0V6helperyyF",
+		"_$s13BullsEyeTests04GameC0V05RoundC0V11roundStarts4TestfMp_22$test_container__functionfMu_VN",
+		"_$s13BullsEyeTests04GameC0V05RoundC0V11roundStartsyyYaKF",
+		"_$s13BullsEyeTests14launchSucceeds4TestfMp_22$test_container__functionfMu_VN",
+		"_$s13BullsEyeTests14launchSucceedsyyF",
+		"_OBJC_CLASS_$_XCTestCase",
+	)
+
+	binaryPth := filepath.Join(t.TempDir(), "BullsEyeTests")
+	require.NoError(t, os.WriteFile(binaryPth, image.build(), 0644))
+
+	classes, suites, err := readTestBinary(binaryPth)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"BaseUITests": {"testLaunch"},
+		// Inherits the test methods of its superclass
+		"GameTests": {"testLaunch", "testScore", "testStart"},
+	}, classes)
+	require.Equal(t, map[string][]string{
+		"ScoreTests":           {"scoreIsCalculated(points:multiplier:)"},
+		"GameTests.RoundTests": {"roundStarts()"},
+		"":                     {"launchSucceeds()"},
+	}, suites)
+}
+
+func Test_parseBindOpcodes(t *testing.T) {
+	segments := []*macho.Segment{{SegmentHeader: macho.SegmentHeader{Name: "__TEXT"}}, {SegmentHeader: macho.SegmentHeader{Name: "__DATA", Addr: 0x4000}}}
+	opcodes := []byte{0x11, 0x40}
+	opcodes = append(opcodes, "_OBJC_CLASS_$_XCTestCase"...)
+	opcodes = append(opcodes,
+		0x00, // the symbol's terminator
+		0x51, // SET_TYPE_IMM (pointer)
+		0x71, // SET_SEGMENT_AND_OFFSET_ULEB (__DATA + 0x10)
+		0x10,
+		0x90, // DO_BIND
+		0xc0, // DO_BIND_ULEB_TIMES_SKIPPING_ULEB (2 times, skipping 8 bytes)
+		0x02,
+		0x08,
+		0x00, // DONE
+	)
+
+	binds, err := parseBindOpcodes(opcodes, segments)
+	require.NoError(t, err)
+	require.Equal(t, map[uint64]string{
+		0x4010: "_OBJC_CLASS_$_XCTestCase",
+		0x4018: "_OBJC_CLASS_$_XCTestCase",
+		0x4028: "_OBJC_CLASS_$_XCTestCase",
+	}, binds)
+}
+
+func Test_testInventory_export(t *testing.T) {
+	inventory := testInventory{
+		tests: map[string]map[string][]string{
+			"BullsEyeTests":   {"GameTests": {"testScore", "testStart"}, "BaseUITests": {"testLaunch"}},
+			"BullsEyeUITests": {},
+		},
+		swiftTests: map[string]map[string][]string{
+			"BullsEyeTests": {"ScoreTests": {"scoreIsCalculated(points:)"}, "": {"launchSucceeds()"}},
+		},
+		skippedTests: map[string][]string{"BullsEyeTests": {"BaseUITests"}},
+	}
+
+	exported := inventory.export()
+	require.Equal(t, 5, exported.TestCount)
+	require.Len(t, exported.Targets, 2)
+	require.Equal(t, "BullsEyeTests", exported.Targets[0].Name)
+	require.Equal(t, []string{"BaseUITests"}, exported.Targets[0].SkippedTests)
+	require.Equal(t, "BaseUITests", exported.Targets[0].Classes[0].Name)
+	require.Equal(t, "GameTests", exported.Targets[0].Classes[1].Name)
+	require.Equal(t, []output.TestInventoryClass{
+		{Name: "", Methods: []string{"launchSucceeds()"}},
+		{Name: "ScoreTests", Methods: []string{"scoreIsCalculated(points:)"}},
+	}, exported.Targets[0].SwiftTestingSuites)
+	require.Empty(t, exported.Targets[1].Classes)
+}
+
+func Test_objcClassName(t *testing.T) {
+	require.Equal(t, "GameTests", objcClassName("_TtC13BullsEyeTests9GameTests"))
+	require.Equal(t, "Outer.Inner", objcClassName("_TtCC13BullsEyeTests5Outer5Inner"))
+	require.Equal(t, "BullsEyeObjCTests", objcClassName("BullsEyeObjCTests"))
+	require.Equal(t, "_TtC13BullsEyeTests", objcClassName("_TtC13BullsEyeTests"))
+}
+
+func Test_untagPointer(t *testing.T) {
+	require.Equal(t, uint64(0x8150), untagPointer(0x8150))
+	// DYLD_CHAINED_PTR_64 rebase with a next offset
+	require.Equal(t, uint64(0x8150), untagPointer(0x0010000000008150))
+	// bind
+	require.Equal(t, uint64(0), untagPointer(0x8000000000000003))
+}
+
+const (
+	testMachOClassListAddr = 0x1000
+	testMachODataAddr      = 0x2000
+)
+
+/*
+testMachOImage builds a minimal 64-bit Mach-O bundle with ObjC metadata, chained fixups binding symbols of other
+images and a symbol table, the file offsets match the addresses.
+*/
+type testMachOImage struct {
+	classList   []byte
+	data        []byte
+	imports     []string
+	symbolNames []string
+}
+
+func newTestMachOImage() *testMachOImage {
+	return &testMachOImage{}
+}
+
+func (i *testMachOImage) add(content []byte) uint64 {
+	for len(i.data)%8 != 0 {
+		i.data = append(i.data, 0)
+	}
+	addr := testMachODataAddr + uint64(len(i.data))
+	i.data = append(i.data, content...)
+	return addr
+}
+
+func (i *testMachOImage) cString(s string) uint64 {
+	return i.add(append([]byte(s), 0))
+}
+
+func (i *testMachOImage) pointers(pointers ...uint64) uint64 {
+	content := make([]byte, 8*len(pointers))
+	for idx, pointer := range pointers {
+		binary.LittleEndian.PutUint64(content[idx*8:], pointer)
+	}
+	return i.add(content)
+}
+
+// methodList adds a method_list_t in the pointer format: name, types, imp.
+func (i *testMachOImage) methodList(names ...string) uint64 {
+	var nameAddrs []uint64
+	for _, name := range names {
+		nameAddrs = append(nameAddrs, i.cString(name))
+	}
+
+	content := binary.LittleEndian.AppendUint32(nil, 24)
+	content = binary.LittleEndian.AppendUint32(content, uint32(len(names)))
+	for _, nameAddr := range nameAddrs {
+		content = binary.LittleEndian.AppendUint64(content, nameAddr)
+		content = binary.LittleEndian.AppendUint64(content, 0)
+		content = binary.LittleEndian.AppendUint64(content, 0)
+	}
+	return i.add(content)
+}
+
+// relativeMethodList adds a method_list_t in the relative format: offsets to the selector reference, types and imp.
+func (i *testMachOImage) relativeMethodList(names ...string) uint64 {
+	var selectorRefs []uint64
+	for _, name := range names {
+		selectorRefs = append(selectorRefs, i.pointers(i.cString(name)))
+	}
+
+	// The list is added after the padding of the last selector reference
+	listAddr := testMachODataAddr + uint64(len(i.data))
+	content := binary.LittleEndian.AppendUint32(nil, 0x80000000|12)
+	content = binary.LittleEndian.AppendUint32(content, uint32(len(names)))
+	for idx, selectorRef := range selectorRefs {
+		entryAddr := listAddr + 8 + uint64(idx)*12
+		content = binary.LittleEndian.AppendUint32(content, uint32(int32(int64(selectorRef)-int64(entryAddr))))
+		content = binary.LittleEndian.AppendUint32(content, 0)
+		content = binary.LittleEndian.AppendUint32(content, 0)
+	}
+	return i.add(content)
+}
+
+// bind adds an import, and returns a chained bind (DYLD_CHAINED_PTR_64) to it.
+func (i *testMachOImage) bind(symbol string) uint64 {
+	i.imports = append(i.imports, symbol)
+	return 1<<63 | uint64(len(i.imports)-1)
+}
+
+// symbols adds the symbols to the symbol table.
+func (i *testMachOImage) symbols(names ...string) {
+	i.symbolNames = append(i.symbolNames, names...)
+}
+
+// objcClass adds an objc_class with its class_ro_t and lists it in the class list.
+func (i *testMachOImage) objcClass(name string, superclass, methodList uint64) uint64 {
+	nameAddr := i.cString(name)
+
+	ro := make([]byte, 16)
+	ro = binary.LittleEndian.AppendUint64(ro, 0)
+	ro = binary.LittleEndian.AppendUint64(ro, nameAddr)
+	ro = binary.LittleEndian.AppendUint64(ro, methodList)
+	roAddr := i.add(ro)
+
+	// Swift classes set the low bits of the data pointer
+	classAddr := i.pointers(0, superclass, 0, 0, roAddr|1)
+	i.classList = binary.LittleEndian.AppendUint64(i.classList, classAddr)
+	return classAddr
+}
+
+func (i *testMachOImage) build() []byte {
+	const (
+		segmentSize = 72
+		sectionSize = 80
+	)
+
+	section := func(name string, addr uint64, size int) []byte {
+		content := make([]byte, 32)
+		copy(content, name)
+		copy(content[16:], "__DATA")
+		content = binary.LittleEndian.AppendUint64(content, addr)
+		content = binary.LittleEndian.AppendUint64(content, uint64(size))
+		content = binary.LittleEndian.AppendUint32(content, uint32(addr))
+		return append(content, make([]byte, sectionSize-len(content))...)
+	}
+
+	dataEnd := testMachODataAddr + uint64(len(i.data))
+
+	segment := binary.LittleEndian.AppendUint32(nil, 0x19) // LC_SEGMENT_64
+	segment = binary.LittleEndian.AppendUint32(segment, segmentSize+2*sectionSize)
+	segment = append(segment, append([]byte("__DATA"), make([]byte, 10)...)...)
+	segment = binary.LittleEndian.AppendUint64(segment, testMachOClassListAddr)
+	segment = binary.LittleEndian.AppendUint64(segment, dataEnd-testMachOClassListAddr)
+	segment = binary.LittleEndian.AppendUint64(segment, testMachOClassListAddr)
+	segment = binary.LittleEndian.AppendUint64(segment, dataEnd-testMachOClassListAddr)
+	segment = binary.LittleEndian.AppendUint32(segment, 3)
+	segment = binary.LittleEndian.AppendUint32(segment, 3)
+	segment = binary.LittleEndian.AppendUint32(segment, 2)
+	segment = binary.LittleEndian.AppendUint32(segment, 0)
+	segment = append(segment, section("__objc_classlist", testMachOClassListAddr, len(i.classList))...)
+	segment = append(segment, section("__objc_data", testMachODataAddr, len(i.data))...)
+
+	// __LINKEDIT: the chained fixups (header, DYLD_CHAINED_IMPORT entries, symbol pool), the symbol table and its strings
+	linkEditAddr := (dataEnd + 7) &^ 7
+	var symbolPool []byte
+	fixups := binary.LittleEndian.AppendUint32(nil, 0)
+	for _, value := range []uint32{28, 28, 28 + 4*uint32(len(i.imports)), uint32(len(i.imports)), 1, 0} {
+		fixups = binary.LittleEndian.AppendUint32(fixups, value)
+	}
+	for _, name := range i.imports {
+		fixups = binary.LittleEndian.AppendUint32(fixups, 1|uint32(len(symbolPool))<<9)
+		symbolPool = append(append(symbolPool, name...), 0)
+	}
+	fixups = append(fixups, symbolPool...)
+	for len(fixups)%8 != 0 {
+		fixups = append(fixups, 0)
+	}
+
+	symbolTableAddr := linkEditAddr + uint64(len(fixups))
+	var symbolTable []byte
+	stringTable := []byte{0}
+	for _, name := range i.symbolNames {
+		symbolTable = binary.LittleEndian.AppendUint32(symbolTable, uint32(len(stringTable)))
+		symbolTable = append(symbolTable, 0x0f, 2, 0, 0) // N_SECT | N_EXT, __objc_data
+		symbolTable = binary.LittleEndian.AppendUint64(symbolTable, testMachODataAddr)
+		stringTable = append(append(stringTable, name...), 0)
+	}
+	stringTableAddr := symbolTableAddr + uint64(len(symbolTable))
+	linkEditEnd := stringTableAddr + uint64(len(stringTable))
+
+	linkEdit := binary.LittleEndian.AppendUint32(nil, 0x19) // LC_SEGMENT_64
+	linkEdit = binary.LittleEndian.AppendUint32(linkEdit, segmentSize)
+	linkEdit = append(linkEdit, append([]byte("__LINKEDIT"), make([]byte, 6)...)...)
+	for _, value := range []uint64{linkEditAddr, linkEditEnd - linkEditAddr, linkEditAddr, linkEditEnd - linkEditAddr} {
+		linkEdit = binary.LittleEndian.AppendUint64(linkEdit, value)
+	}
+	linkEdit = binary.LittleEndian.AppendUint32(linkEdit, 1)
+	linkEdit = binary.LittleEndian.AppendUint32(linkEdit, 1)
+	linkEdit = binary.LittleEndian.AppendUint32(linkEdit, 0)
+	linkEdit = binary.LittleEndian.AppendUint32(linkEdit, 0)
+
+	chainedFixups := binary.LittleEndian.AppendUint32(nil, 0x80000034) // LC_DYLD_CHAINED_FIXUPS
+	for _, value := range []uint32{16, uint32(linkEditAddr), uint32(len(fixups))} {
+		chainedFixups = binary.LittleEndian.AppendUint32(chainedFixups, value)
+	}
+
+	symtab := binary.LittleEndian.AppendUint32(nil, 0x2) // LC_SYMTAB
+	for _, value := range []uint32{24, uint32(symbolTableAddr), uint32(len(i.symbolNames)), uint32(stringTableAddr), uint32(len(stringTable))} {
+		symtab = binary.LittleEndian.AppendUint32(symtab, value)
+	}
+
+	loads := append(append(append(segment, linkEdit...), chainedFixups...), symtab...)
+
+	header := binary.LittleEndian.AppendUint32(nil, 0xfeedfacf)   // MH_MAGIC_64
+	header = binary.LittleEndian.AppendUint32(header, 0x0100000c) // CPU_TYPE_ARM64
+	header = binary.LittleEndian.AppendUint32(header, 0)
+	header = binary.LittleEndian.AppendUint32(header, 0x8) // MH_BUNDLE
+	header = binary.LittleEndian.AppendUint32(header, 4)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(loads)))
+	header = binary.LittleEndian.AppendUint32(header, 0)
+	header = binary.LittleEndian.AppendUint32(header, 0)
+
+	file := make([]byte, linkEditEnd)
+	copy(file, append(header, loads...))
+	copy(file[testMachOClassListAddr:], i.classList)
+	copy(file[testMachODataAddr:], i.data)
+	copy(file[linkEditAddr:], fixups)
+	copy(file[symbolTableAddr:], symbolTable)
+	copy(file[stringTableAddr:], stringTable)
+	return file
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/testing/v1"
+)
+
+// testMatrix is a test bundle submitted to run on a set of devices.
+type testMatrix struct {
+	zipPath string
+	devices []*testing.IosDevice
+}
+
+// testMatrixModel returns the test matrix to start.
+func (m testMatrix) testMatrixModel(testTimeout float64, flakyTestAttempts int) *testing.TestMatrix {
+	testModel := &testing.TestMatrix{}
+	testModel.EnvironmentMatrix = &testing.EnvironmentMatrix{IosDeviceList: &testing.IosDeviceList{}}
+	testModel.EnvironmentMatrix.IosDeviceList.IosDevices = m.devices
+	testModel.FlakyTestAttempts = int64(flakyTestAttempts)
+
+	testModel.TestSpecification = &testing.TestSpecification{
+		TestTimeout: fmt.Sprintf("%fs", testTimeout),
+	}
+
+	testModel.TestSpecification.IosXcTest = &testing.IosXcTest{}
+
+	return testModel
+}
+
+// parseTestDevices parses the test_devices input: a device per line in the model,version,locale,orientation format.
+func parseTestDevices(testDevices string) ([]*testing.IosDevice, error) {
+	var devices []*testing.IosDevice
+
+	scanner := bufio.NewScanner(strings.NewReader(testDevices))
+	for scanner.Scan() {
+		device := scanner.Text()
+		device = strings.TrimSpace(device)
+		if device == "" {
+			continue
+		}
+
+		deviceParams := strings.Split(device, ",")
+		if len(deviceParams) != 4 {
+			return nil, fmt.Errorf("invalid test device configuration: %s", device)
+		}
+
+		devices = append(devices, &testing.IosDevice{
+			IosModelId:   deviceParams[0],
+			IosVersionId: deviceParams[1],
+			Locale:       deviceParams[2],
+			Orientation:  deviceParams[3],
+		})
+	}
+
+	return devices, nil
+}
+
+// iosDeviceAssetName returns the device in the test assets' naming format: iphone8-16.6-en-portrait
+func iosDeviceAssetName(device *testing.IosDevice) string {
+	return assetDimension{
+		Model:       device.IosModelId,
+		Version:     device.IosVersionId,
+		Locale:      device.Locale,
+		Orientation: device.Orientation,
+	}.name()
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	testingapi "google.golang.org/api/testing/v1"
+)
+
+func Test_parseTestDevices(t *testing.T) {
+	devices, err := parseTestDevices("iphone8,16.6,en,portrait\n\n  iphone13pro,16.6,en_GB,landscape  \n")
+	require.NoError(t, err)
+	require.Equal(t, []*testingapi.IosDevice{
+		{IosModelId: "iphone8", IosVersionId: "16.6", Locale: "en", Orientation: "portrait"},
+		{IosModelId: "iphone13pro", IosVersionId: "16.6", Locale: "en_GB", Orientation: "landscape"},
+	}, devices)
+
+	_, err = parseTestDevices("iphone8,16.6,en")
+	require.EqualError(t, err, "invalid test device configuration: iphone8,16.6,en")
+}
@@ -1,74 +1,447 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-plist"
 	"github.com/bitrise-io/go-steputils/v2/testquarantine"
+	"github.com/bitrise-io/go-utils/sliceutil"
 	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/env"
 	"github.com/bitrise-io/go-utils/v2/pathutil"
+	"google.golang.org/api/testing/v1"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/output"
 )
 
+/*
+quarantineEntry is a quarantined test, class or test target in the xctestrun file's SkipTestIdentifiers format.
+
+The test target, class and method can be glob patterns (LoginTests/test*), the class and method are empty if the entry
+quarantines a whole test class or test target. The class is a Swift Testing suite and the method is a Swift Testing
+function if the test's kind is testKindSwiftTesting.
+*/
+type quarantineEntry struct {
+	test        testquarantine.QuarantinedTest
+	constraints quarantineConstraints
+	testTarget  string
+	testClass   string
+	testMethod  string
+	testKind    string
+	// skipTestIdentifiers are the SkipTestIdentifiers by TestTargets, nil until the entry is expanded against the test inventory.
+	skipTestIdentifiers map[string][]string
+}
+
+// needsTestInventory tells if any of the entries can only be expanded to SkipTestIdentifiers against the test inventory.
+func needsTestInventory(entries []quarantineEntry) bool {
+	for _, entry := range entries {
+		if entry.needsTestInventory() {
+			return true
+		}
+	}
+	return false
+}
+
+// needsTestInventory tells if the entry can only be expanded to SkipTestIdentifiers against the test inventory.
+func (e quarantineEntry) needsTestInventory() bool {
+	return e.skipTestIdentifiers == nil
+}
+
+// pattern returns the entry in the Target/Class/method format.
+func (e quarantineEntry) pattern() string {
+	pattern := e.testTarget
+	for _, part := range []string{e.testClass, e.testMethod} {
+		if part != "" {
+			pattern += "/" + part
+		}
+	}
+	return pattern
+}
+
+// ignoredQuarantineEntry is a quarantined test which is not skipped, its status tells why.
+type ignoredQuarantineEntry struct {
+	test testquarantine.QuarantinedTest
+	// status is output.QuarantineEntryDropped or output.QuarantineEntryExpired.
+	status string
+	reason string
+}
+
+// quarantineConstraints are the optional fields of a quarantined tests input entry, scoping the entry.
+type quarantineConstraints struct {
+	// DeviceModels are model IDs (iphone8), the entry applies to every device model if empty.
+	DeviceModels []string `json:"deviceModels"`
+	// OSVersions are OS versions (16.6) or major versions (16), the entry applies to every OS version if empty.
+	OSVersions []string `json:"osVersions"`
+	// ExpiresAt is a date (2025-12-31) or an RFC 3339 time, the entry is ignored after it.
+	ExpiresAt string `json:"expiresAt"`
+}
+
+func (c quarantineConstraints) appliesTo(device *testing.IosDevice) bool {
+	if len(c.DeviceModels) > 0 && !sliceutil.IsStringInSlice(device.IosModelId, c.DeviceModels) {
+		return false
+	}
+
+	if len(c.OSVersions) == 0 {
+		return true
+	}
+	for _, osVersion := range c.OSVersions {
+		if device.IosVersionId == osVersion || strings.HasPrefix(device.IosVersionId, osVersion+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c quarantineConstraints) appliesToAny(devices []*testing.IosDevice) bool {
+	for _, device := range devices {
+		if c.appliesTo(device) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c quarantineConstraints) appliesToAll(devices []*testing.IosDevice) bool {
+	for _, device := range devices {
+		if !c.appliesTo(device) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c quarantineConstraints) expiry() (time.Time, bool, error) {
+	if c.ExpiresAt == "" {
+		return time.Time{}, false, nil
+	}
+
+	if expiry, err := time.Parse(time.RFC3339, c.ExpiresAt); err == nil {
+		return expiry, true, nil
+	}
+
+	date, err := time.Parse(time.DateOnly, c.ExpiresAt)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid expiresAt (%s), should be a date (2006-01-02) or an RFC 3339 time", c.ExpiresAt)
+	}
+	// A date expires at its end
+	return date.AddDate(0, 0, 1), true, nil
+}
+
 /*
 parseQuarantinedTests converts the Bitrise quarantined tests JSON input ($BITRISE_QUARANTINED_TESTS_JSON)
-to xctestrun file's SkipTestIdentifiers format: TestClass/TestMethod (`()` suffix removed) mapped by TestTargets.
+to xctestrun file's SkipTestIdentifiers format: TestClass/TestMethod (`()` suffix removed) for XCTest tests,
+Suite/function(label:) for Swift Testing tests, see testKindOf.
+
+An entry without test case name quarantines the whole class, an entry without class and test case name quarantines
+the whole test target, an entry without class name quarantines a module level Swift Testing function.
+Entries quarantining a test target, using glob patterns or of unknown kind need to be expanded against the
+test inventory. Entries without test suite (test target), with a test case which is not clearly a Swift Testing
+function (scoreIsCalculated(), testScore(points:)) but without class name, or with an invalid glob pattern or expiry
+are dropped, entries expired at now are ignored, so the tests run normally.
 */
-func parseQuarantinedTests(quarantinedTestsInput string) (map[string][]string, error) {
+func parseQuarantinedTests(quarantinedTestsInput string, now time.Time) ([]quarantineEntry, []ignoredQuarantineEntry, error) {
 	quarantinedTests, err := testquarantine.ParseQuarantinedTests(quarantinedTestsInput)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse quarantined tests input: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse quarantined tests input: %w", err)
 	}
 
-	skippedTestsByTarget := map[string][]string{}
-	for _, qt := range quarantinedTests {
-		if len(qt.TestSuiteName) == 0 || qt.TestSuiteName[0] == "" || qt.ClassName == "" || qt.TestCaseName == "" {
+	constraints := make([]quarantineConstraints, len(quarantinedTests))
+	if len(quarantinedTests) > 0 {
+		if err := json.Unmarshal([]byte(quarantinedTestsInput), &constraints); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse quarantined tests input constraints: %w", err)
+		}
+	}
+
+	var entries []quarantineEntry
+	var ignored []ignoredQuarantineEntry
+	for i, qt := range quarantinedTests {
+		if len(qt.TestSuiteName) == 0 || qt.TestSuiteName[0] == "" {
+			ignored = append(ignored, ignoredQuarantineEntry{test: qt, status: output.QuarantineEntryDropped, reason: "empty test suite name"})
+			continue
+		}
+		if qt.ClassName == "" && qt.TestCaseName != "" && testKindOf(qt.ClassName, qt.TestCaseName) != testKindSwiftTesting {
+			ignored = append(ignored, ignoredQuarantineEntry{test: qt, status: output.QuarantineEntryDropped, reason: "empty class name"})
+			continue
+		}
+
+		expiry, expires, err := constraints[i].expiry()
+		if err != nil {
+			ignored = append(ignored, ignoredQuarantineEntry{test: qt, status: output.QuarantineEntryDropped, reason: err.Error()})
+			continue
+		}
+		if expires && !now.Before(expiry) {
+			ignored = append(ignored, ignoredQuarantineEntry{test: qt, status: output.QuarantineEntryExpired, reason: fmt.Sprintf("expired at %s", constraints[i].ExpiresAt)})
+			continue
+		}
+
+		entry := quarantineEntry{
+			test:        qt,
+			constraints: constraints[i],
+			testTarget:  qt.TestSuiteName[0],
+			testClass:   qt.ClassName,
+			testMethod:  strings.TrimSuffix(qt.TestCaseName, "()"),
+		}
+		if qt.TestCaseName != "" {
+			entry.testKind = testKindOf(qt.ClassName, qt.TestCaseName)
+		}
+		if entry.testKind == testKindSwiftTesting {
+			entry.testMethod = qt.TestCaseName
+		}
+
+		if err := validateGlobPatterns(entry.testTarget, entry.testClass, entry.testMethod); err != nil {
+			ignored = append(ignored, ignoredQuarantineEntry{test: qt, status: output.QuarantineEntryDropped, reason: err.Error()})
+			continue
+		}
+
+		if !containsGlobPattern(entry.testTarget, entry.testClass, entry.testMethod) {
+			switch {
+			case entry.testKind == testKindXCTest:
+				entry.skipTestIdentifiers = map[string][]string{entry.testTarget: {xctestIdentifier(entry.testClass, entry.testMethod)}}
+			case entry.testKind == testKindSwiftTesting:
+				entry.skipTestIdentifiers = map[string][]string{entry.testTarget: {swiftTestingIdentifier(entry.testClass, entry.testMethod)}}
+			case entry.testKind == "" && entry.testClass != "":
+				entry.skipTestIdentifiers = map[string][]string{entry.testTarget: {swiftTestingSuitePath(entry.testClass)}}
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, ignored, nil
+}
+
+func containsGlobPattern(parts ...string) bool {
+	for _, part := range parts {
+		if strings.ContainsAny(part, "*?[") {
+			return true
+		}
+	}
+	return false
+}
+
+func validateGlobPatterns(parts ...string) error {
+	for _, part := range parts {
+		if _, err := path.Match(part, ""); err != nil {
+			return fmt.Errorf("invalid glob pattern (%s)", part)
+		}
+	}
+	return nil
+}
+
+/*
+expandQuarantineEntries sets the SkipTestIdentifiers of the entries needing the test inventory: a test target
+is expanded to its test classes and Swift Testing suites, glob patterns to the matching test targets, classes,
+suites, methods and functions.
+
+A test of unknown kind is an XCTest test if its class is in the inventory, or if the inventory doesn't list its
+test target, otherwise it is a Swift Testing test.
+*/
+func expandQuarantineEntries(entries []quarantineEntry, inventory testInventory) []quarantineEntry {
+	var expanded []quarantineEntry
+	for _, entry := range entries {
+		if !entry.needsTestInventory() {
+			expanded = append(expanded, entry)
 			continue
 		}
 
-		testTarget := qt.TestSuiteName[0]
-		testClass := qt.ClassName
-		testMethod := strings.TrimSuffix(qt.TestCaseName, "()")
+		if entry.testKind == testKindUnknown && !containsGlobPattern(entry.testTarget, entry.testClass, entry.testMethod) {
+			entry.testKind = testKindXCTest
+			skipTestIdentifier := xctestIdentifier(entry.testClass, entry.testMethod)
+			if _, isXCTestClass := inventory.tests[entry.testTarget][entry.testClass]; !isXCTestClass && inventory.tests[entry.testTarget] != nil {
+				entry.testKind = testKindSwiftTesting
+				skipTestIdentifier = swiftTestingIdentifier(entry.testClass, entry.testMethod)
+			}
+
+			entry.skipTestIdentifiers = map[string][]string{entry.testTarget: {skipTestIdentifier}}
+			expanded = append(expanded, entry)
+			continue
+		}
+
+		entry.skipTestIdentifiers = map[string][]string{}
+		for _, target := range inventory.targets() {
+			if ok, _ := path.Match(entry.testTarget, target); !ok {
+				continue
+			}
+
+			for _, class := range inventory.classes(target) {
+				if entry.testClass == "" {
+					entry.skipTestIdentifiers[target] = append(entry.skipTestIdentifiers[target], class)
+					continue
+				}
+				if ok, _ := path.Match(entry.testClass, class); !ok {
+					continue
+				}
+
+				if entry.testMethod == "" {
+					entry.skipTestIdentifiers[target] = append(entry.skipTestIdentifiers[target], class)
+					continue
+				}
+				for _, method := range inventory.tests[target][class] {
+					if ok, _ := path.Match(entry.testMethod, method); ok {
+						entry.skipTestIdentifiers[target] = append(entry.skipTestIdentifiers[target], class+"/"+method)
+					}
+				}
+			}
+
+			for _, suite := range inventory.suites(target) {
+				entry.skipTestIdentifiers[target] = append(entry.skipTestIdentifiers[target], expandSwiftTestingSuite(entry, suite, inventory.swiftTests[target][suite])...)
+			}
+		}
 
-		skippedTests := skippedTestsByTarget[testTarget]
-		skippedTests = append(skippedTests, fmt.Sprintf("%s/%s", testClass, testMethod))
-		skippedTestsByTarget[testTarget] = skippedTests
+		expanded = append(expanded, entry)
 	}
+	return expanded
+}
 
-	return skippedTestsByTarget, nil
+// expandSwiftTestingSuite returns the SkipTestIdentifiers of the entry's tests in the Swift Testing suite, the module level functions' suite is empty.
+func expandSwiftTestingSuite(entry quarantineEntry, suite string, functions []string) []string {
+	var skipTestIdentifiers []string
+	switch {
+	case entry.testClass == "" && suite == "":
+		for _, function := range functions {
+			skipTestIdentifiers = append(skipTestIdentifiers, swiftTestingIdentifier(suite, function))
+		}
+	case entry.testClass == "":
+		skipTestIdentifiers = append(skipTestIdentifiers, swiftTestingSuitePath(suite))
+	case suite == "":
+	default:
+		if ok, _ := path.Match(entry.testClass, suite); !ok {
+			break
+		}
+		if entry.testMethod == "" {
+			skipTestIdentifiers = append(skipTestIdentifiers, swiftTestingSuitePath(suite))
+			break
+		}
+		for _, function := range functions {
+			if ok, _ := path.Match(entry.testMethod, function); ok {
+				skipTestIdentifiers = append(skipTestIdentifiers, swiftTestingIdentifier(suite, function))
+			}
+		}
+	}
+	return skipTestIdentifiers
 }
 
-func addQuarantinedTestsToTestBundle(testBundleZipPth string, skippedTestByTarget map[string][]string) (string, error) {
+// skippedTestsByTarget maps the entries' SkipTestIdentifiers by TestTargets.
+func skippedTestsByTarget(entries []quarantineEntry) map[string][]string {
+	skippedTestsByTarget := map[string][]string{}
+	for _, entry := range entries {
+		for target, skipTestIdentifiers := range entry.skipTestIdentifiers {
+			skippedTestsByTarget[target] = append(skippedTestsByTarget[target], skipTestIdentifiers...)
+		}
+	}
+	return skippedTestsByTarget
+}
+
+/*
+applyingQuarantineEntries returns the entries applying to every device.
+
+The xctestrun's SkipTestIdentifiers apply to every device of the test matrix and a build runs a single test matrix,
+so an entry applying to only some of the devices can't be applied without skipping the test on the other devices too.
+*/
+func applyingQuarantineEntries(entries []quarantineEntry, devices []*testing.IosDevice) []quarantineEntry {
+	var applying []quarantineEntry
+	for _, entry := range entries {
+		if entry.constraints.appliesToAll(devices) {
+			applying = append(applying, entry)
+		}
+	}
+	return applying
+}
+
+/*
+createQuarantineReport tells which quarantine entries were applied: an entry is applied if any of its test targets
+matches a test target's BlueprintName in any of the xctestrun files and it applies to every device, otherwise it is stale.
+*/
+func createQuarantineReport(entries []quarantineEntry, ignored []ignoredQuarantineEntry, blueprintNames []string, devices []*testing.IosDevice) output.QuarantineReport {
+	var report output.QuarantineReport
+	for _, entry := range entries {
+		targetFound := false
+		for target := range entry.skipTestIdentifiers {
+			if sliceutil.IsStringInSlice(target, blueprintNames) {
+				targetFound = true
+				break
+			}
+		}
+
+		reportEntry := output.QuarantineReportEntry{QuarantinedTest: entry.test}
+		switch {
+		case !entry.constraints.appliesToAny(devices):
+			reportEntry.Status = output.QuarantineEntryStale
+			reportEntry.Reason = "no test device matches the entry's device models and OS versions"
+			report.Stale++
+		case !entry.constraints.appliesToAll(devices):
+			reportEntry.Status = output.QuarantineEntryStale
+			reportEntry.Reason = "the entry applies to only some of the test devices, but the test devices run in a single test matrix"
+			report.Stale++
+		case len(entry.skipTestIdentifiers) == 0:
+			reportEntry.Status = output.QuarantineEntryStale
+			reportEntry.Reason = fmt.Sprintf("no test in the test bundle matches %s", entry.pattern())
+			report.Stale++
+		case !targetFound:
+			reportEntry.Status = output.QuarantineEntryStale
+			reportEntry.Reason = fmt.Sprintf("test target %s not found in the xctestrun files", entry.testTarget)
+			report.Stale++
+		default:
+			reportEntry.Status = output.QuarantineEntryApplied
+			report.Applied++
+		}
+		report.Entries = append(report.Entries, reportEntry)
+	}
+
+	for _, i := range ignored {
+		report.Entries = append(report.Entries, output.QuarantineReportEntry{
+			QuarantinedTest: i.test,
+			Status:          i.status,
+			Reason:          i.reason,
+		})
+		if i.status == output.QuarantineEntryExpired {
+			report.Expired++
+		} else {
+			report.Dropped++
+		}
+	}
+
+	return report
+}
+
+// addQuarantinedTestsToTestBundle returns the updated test bundle zip and the BlueprintNames of the xctestrun files' test targets.
+func addQuarantinedTestsToTestBundle(testBundleZipPth string, skippedTestByTarget map[string][]string) (string, []string, error) {
 	tmpTestBundlePth, err := unzipTestBundle(testBundleZipPth)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	entries, err := os.ReadDir(tmpTestBundlePth)
 	if err != nil {
-		return "", fmt.Errorf("failed to read unzipped test bundle dir: %w", err)
+		return "", nil, fmt.Errorf("failed to read unzipped test bundle dir: %w", err)
 	}
 
+	var blueprintNames []string
 	for _, entry := range entries {
 		if entry.IsDir() || filepath.Ext(entry.Name()) != ".xctestrun" {
 			continue
 		}
 
 		xctestrunPth := filepath.Join(tmpTestBundlePth, entry.Name())
-		if err := addQuarantinedTestsToXctestrun(xctestrunPth, skippedTestByTarget); err != nil {
-			return "", fmt.Errorf("failed to add quarantined tests to xctestrun file (%s): %w", xctestrunPth, err)
+		names, err := addQuarantinedTestsToXctestrun(xctestrunPth, skippedTestByTarget)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to add quarantined tests to xctestrun file (%s): %w", xctestrunPth, err)
 		}
+		blueprintNames = append(blueprintNames, names...)
 	}
 
 	updatedTestBundleZipPath, err := zipTestBundle(tmpTestBundlePth, 6)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return updatedTestBundleZipPath, nil
+	return updatedTestBundleZipPath, blueprintNames, nil
 }
 
 func unzipTestBundle(testBundleZipPth string) (string, error) {
@@ -162,22 +535,50 @@ func writeXctestrun(xctestrunPth string, xctestrun map[string]any, format int) e
 	return nil
 }
 
-func addQuarantinedTestsToXctestrun(xctestrunPth string, skippedTestByTarget map[string][]string) error {
+// addQuarantinedTestsToXctestrun returns the BlueprintNames of the xctestrun file's test targets.
+func addQuarantinedTestsToXctestrun(xctestrunPth string, skippedTestByTarget map[string][]string) ([]string, error) {
 	xctestrun, plistFormat, err := parseXctestrun(xctestrunPth)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	updatedXctestrun, err := addSkippedTestsToXctestrun(xctestrun, skippedTestByTarget)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := writeXctestrun(xctestrunPth, updatedXctestrun, plistFormat); err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return xctestrunBlueprintNames(updatedXctestrun), nil
+}
+
+// xctestrunBlueprintNames returns the BlueprintNames of the test targets in the xctestrun's test configurations.
+func xctestrunBlueprintNames(xctestrun map[string]any) []string {
+	var names []string
+	for _, testTarget := range xctestrunTestTargets(xctestrun) {
+		if name, ok := testTarget["BlueprintName"].(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// xctestrunTestTargets returns the test targets in the xctestrun's test configurations.
+func xctestrunTestTargets(xctestrun map[string]any) []map[string]interface{} {
+	var testTargets []map[string]interface{}
+	testConfigurations, _ := xctestrun["TestConfigurations"].([]interface{})
+	for _, testConfigurationRaw := range testConfigurations {
+		testConfiguration, _ := testConfigurationRaw.(map[string]interface{})
+		testTargetsRaw, _ := testConfiguration["TestTargets"].([]interface{})
+		for _, testTargetRaw := range testTargetsRaw {
+			if testTarget, ok := testTargetRaw.(map[string]interface{}); ok {
+				testTargets = append(testTargets, testTarget)
+			}
+		}
+	}
+	return testTargets
 }
 
 func addSkippedTestsToXctestrun(xctestrun map[string]any, skippedTestByTarget map[string][]string) (map[string]any, error) {
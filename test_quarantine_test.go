@@ -1,10 +1,18 @@
 package main
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/bitrise-io/go-steputils/v2/testquarantine"
+	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/stretchr/testify/require"
+	testingapi "google.golang.org/api/testing/v1"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/mocks"
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/output"
 )
 
 func Test_addQuarantinedTestsToTestBundle(t *testing.T) {
@@ -126,3 +134,242 @@ func getSkippedTests(xctestrun map[string]any, target string, t *testing.T) []st
 
 	return nil
 }
+
+func Test_parseQuarantinedTests(t *testing.T) {
+	input := `[
+  {"testCaseName": "testRandomlyFail()", "testSuiteName": ["BullsEyeFailingTests"], "className": "BullsEyeRandomlyFailingTests"},
+  {"testCaseName": "testGameStyleSwitch()", "testSuiteName": [], "className": "BullsEyeUITests2"},
+  {"testCaseName": "testNoClass()", "testSuiteName": ["BullsEyeTests"], "className": ""},
+  {"testCaseName": "testOldOS()", "testSuiteName": ["BullsEyeTests"], "className": "BullsEyeTests", "osVersions": ["15"], "expiresAt": "2025-06-30"},
+  {"testCaseName": "testExpired()", "testSuiteName": ["BullsEyeTests"], "className": "BullsEyeTests", "expiresAt": "2025-06-01"},
+  {"testCaseName": "testInvalidExpiry()", "testSuiteName": ["BullsEyeTests"], "className": "BullsEyeTests", "expiresAt": "next week"},
+  {"testCaseName": "", "testSuiteName": ["BullsEyeTests"], "className": "BullsEyeSlowTests"},
+  {"testCaseName": "", "testSuiteName": ["BullsEyeUITests"], "className": ""},
+  {"testCaseName": "test*()", "testSuiteName": ["BullsEyeTests"], "className": "Login*"},
+  {"testCaseName": "test[()", "testSuiteName": ["BullsEyeTests"], "className": "LoginTests"}
+]`
+	now := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	entries, ignored, err := parseQuarantinedTests(input, now)
+	require.NoError(t, err)
+
+	require.Len(t, entries, 5)
+	require.True(t, entries[0].needsTestInventory())
+	require.Equal(t, testKindUnknown, entries[0].testKind)
+	require.Equal(t, quarantineConstraints{OSVersions: []string{"15"}, ExpiresAt: "2025-06-30"}, entries[1].constraints)
+	require.Equal(t, map[string][]string{"BullsEyeTests": {"BullsEyeSlowTests"}}, entries[2].skipTestIdentifiers)
+	require.True(t, entries[3].needsTestInventory())
+	require.Equal(t, "BullsEyeUITests", entries[3].pattern())
+	require.True(t, entries[4].needsTestInventory())
+	require.Equal(t, "BullsEyeTests/Login*/test*", entries[4].pattern())
+
+	entries = expandQuarantineEntries(entries, testInventory{})
+	require.Equal(t, map[string][]string{
+		"BullsEyeFailingTests": {"BullsEyeRandomlyFailingTests/testRandomlyFail"},
+		"BullsEyeTests":        {"BullsEyeTests/testOldOS", "BullsEyeSlowTests"},
+	}, skippedTestsByTarget(entries))
+
+	require.Len(t, ignored, 5)
+	require.Equal(t, output.QuarantineEntryDropped, ignored[0].status)
+	require.Equal(t, "empty test suite name", ignored[0].reason)
+	require.Equal(t, "empty class name", ignored[1].reason)
+	require.Equal(t, output.QuarantineEntryExpired, ignored[2].status)
+	require.Equal(t, "expired at 2025-06-01", ignored[2].reason)
+	require.Equal(t, output.QuarantineEntryDropped, ignored[3].status)
+	require.Equal(t, "invalid glob pattern (test[)", ignored[4].reason)
+}
+
+func Test_parseQuarantinedTests_swiftTesting(t *testing.T) {
+	input := `[
+  {"testCaseName": "scoreIsCalculated(points:multiplier:)", "testSuiteName": ["BullsEyeTests"], "className": "ScoreTests"},
+  {"testCaseName": "roundStarts()", "testSuiteName": ["BullsEyeTests"], "className": "GameTests.RoundTests"},
+  {"testCaseName": "testSliderMoves()", "testSuiteName": ["BullsEyeTests"], "className": "SliderTests"},
+  {"testCaseName": "testGameStyleSwitch()", "testSuiteName": ["BullsEyeUITests"], "className": "BullsEyeUITests"},
+  {"testCaseName": "testMissingTarget()", "testSuiteName": ["BullsEyeWatchTests"], "className": "WatchTests"},
+  {"testCaseName": "", "testSuiteName": ["BullsEyeTests"], "className": "GameTests.RoundTests"},
+  {"testCaseName": "launchSucceeds()", "testSuiteName": ["BullsEyeTests"], "className": ""},
+  {"testCaseName": "testLaunch(count:)", "testSuiteName": ["BullsEyeTests"], "className": ""}
+]`
+	inventory := testInventory{tests: map[string]map[string][]string{
+		"BullsEyeTests":   {"BullsEyeTests": {"testScore"}},
+		"BullsEyeUITests": {"BullsEyeUITests": {"testGameStyleSwitch"}},
+	}}
+
+	entries, ignored, err := parseQuarantinedTests(input, time.Now())
+	require.NoError(t, err)
+	require.Empty(t, ignored)
+
+	entries = expandQuarantineEntries(entries, inventory)
+
+	var identifiers []string
+	for _, entry := range entries {
+		identifiers = append(identifiers, entry.skipTestIdentifiers[entry.testTarget]...)
+	}
+	require.Equal(t, []string{
+		"ScoreTests/scoreIsCalculated(points:multiplier:)",
+		"GameTests/RoundTests/roundStarts()",
+		// Not an XCTest class in the inventory
+		"SliderTests/testSliderMoves()",
+		"BullsEyeUITests/testGameStyleSwitch",
+		// The test target is not in the inventory
+		"WatchTests/testMissingTarget",
+		"GameTests/RoundTests",
+		"launchSucceeds()",
+		"testLaunch(count:)",
+	}, identifiers)
+}
+
+func Test_parseQuarantinedTests_quarantineSuggestions(t *testing.T) {
+	dir := t.TempDir()
+	report := `<testsuite name="" tests="2">
+  <testcase name="testFlaky" classname="BullsEyeTests.GameTests" flaky="true"><failure>failed</failure></testcase>
+  <testcase name="scoreIsCalculated(points:) (points: 10)" classname="BullsEyeTests.ScoreTests" flaky="true"><failure>failed</failure></testcase>
+</testsuite>`
+	var devices []output.DeviceTestResult
+	for _, name := range []string{"iphone8-15.7-en-portrait", "iphone13pro-16.6-en-portrait"} {
+		pth := filepath.Join(dir, name+".xml")
+		require.NoError(t, os.WriteFile(pth, []byte(report), 0644))
+		devices = append(devices, output.DeviceTestResult{Name: name, MergedTestResultXMLPath: pth})
+	}
+
+	suggestionsPth := filepath.Join(dir, "quarantine_suggestions.json")
+	outputExporter := mocks.NewOutputExporter(t)
+	outputExporter.On("ExportOutput", "VDTESTING_QUARANTINE_SUGGESTIONS_PATH", suggestionsPth).Return(nil)
+	require.NoError(t, output.NewExporter(outputExporter, log.NewLogger()).ExportQuarantineSuggestions(devices, nil, dir))
+
+	suggestions, err := os.ReadFile(suggestionsPth)
+	require.NoError(t, err)
+
+	entries, ignored, err := parseQuarantinedTests(string(suggestions), time.Now())
+	require.NoError(t, err)
+	require.Empty(t, ignored)
+
+	entries = expandQuarantineEntries(entries, testInventory{tests: map[string]map[string][]string{"BullsEyeTests": {"GameTests": {"testFlaky"}}}})
+	require.Equal(t, map[string][]string{
+		"BullsEyeTests": {"GameTests/testFlaky", "ScoreTests/scoreIsCalculated(points:)"},
+	}, skippedTestsByTarget(entries))
+}
+
+func Test_expandQuarantineEntries(t *testing.T) {
+	inventory := testInventory{tests: map[string]map[string][]string{
+		"BullsEyeTests": {
+			"LoginTests":      {"testLogin", "testLogout", "performanceLogin"},
+			"LoginRetryTests": {"testRetry"},
+			"GameTests":       {"testStart"},
+		},
+		"BullsEyeUITests": {
+			"BullsEyeUITests":  {"testGameStyleSwitch"},
+			"BullsEyeUITests2": {"testSlider"},
+		},
+	}}
+
+	entries := expandQuarantineEntries([]quarantineEntry{
+		{testTarget: "BullsEyeUITests"},
+		{testTarget: "BullsEyeTests", testClass: "Login*", testMethod: "test*"},
+		{testTarget: "*", testClass: "*Tests2"},
+		{testTarget: "BullsEyeTests", testClass: "Missing*"},
+		{testTarget: "BullsEyeTests", testClass: "GameTests", testMethod: "testStart", skipTestIdentifiers: map[string][]string{"BullsEyeTests": {"GameTests/testStart"}}},
+	}, inventory)
+
+	require.Equal(t, map[string][]string{"BullsEyeUITests": {"BullsEyeUITests", "BullsEyeUITests2"}}, entries[0].skipTestIdentifiers)
+	require.Equal(t, map[string][]string{"BullsEyeTests": {"LoginRetryTests/testRetry", "LoginTests/testLogin", "LoginTests/testLogout"}}, entries[1].skipTestIdentifiers)
+	require.Equal(t, map[string][]string{"BullsEyeUITests": {"BullsEyeUITests2"}}, entries[2].skipTestIdentifiers)
+	require.Empty(t, entries[3].skipTestIdentifiers)
+	require.False(t, entries[3].needsTestInventory())
+	require.Equal(t, map[string][]string{"BullsEyeTests": {"GameTests/testStart"}}, entries[4].skipTestIdentifiers)
+}
+
+func Test_expandQuarantineEntries_swiftTesting(t *testing.T) {
+	inventory := testInventory{
+		tests: map[string]map[string][]string{"BullsEyeTests": {"LoginTests": {"testLogin"}}},
+		swiftTests: map[string]map[string][]string{"BullsEyeTests": {
+			"ScoreTests":           {"scoreIsCalculated(points:)", "scoreResets()"},
+			"GameTests.RoundTests": {"roundStarts()"},
+			"":                     {"launchSucceeds()"},
+		}},
+	}
+
+	entries := expandQuarantineEntries([]quarantineEntry{
+		{testTarget: "BullsEyeTests"},
+		{testTarget: "BullsEyeTests", testClass: "ScoreTests", testMethod: "score*(points:)", testKind: testKindSwiftTesting},
+		{testTarget: "BullsEyeTests", testClass: "Game*"},
+	}, inventory)
+
+	require.Equal(t, map[string][]string{"BullsEyeTests": {"LoginTests", "launchSucceeds()", "GameTests/RoundTests", "ScoreTests"}}, entries[0].skipTestIdentifiers)
+	require.Equal(t, map[string][]string{"BullsEyeTests": {"ScoreTests/scoreIsCalculated(points:)"}}, entries[1].skipTestIdentifiers)
+	require.Equal(t, map[string][]string{"BullsEyeTests": {"GameTests/RoundTests"}}, entries[2].skipTestIdentifiers)
+}
+
+func Test_quarantineConstraints_appliesTo(t *testing.T) {
+	iphone8 := &testingapi.IosDevice{IosModelId: "iphone8", IosVersionId: "15.7"}
+	iphone13 := &testingapi.IosDevice{IosModelId: "iphone13pro", IosVersionId: "16.6"}
+
+	require.True(t, quarantineConstraints{}.appliesTo(iphone8))
+	require.True(t, quarantineConstraints{OSVersions: []string{"15"}}.appliesTo(iphone8))
+	require.False(t, quarantineConstraints{OSVersions: []string{"15"}}.appliesTo(iphone13))
+	require.False(t, quarantineConstraints{OSVersions: []string{"16.6.1"}}.appliesTo(iphone13))
+	require.True(t, quarantineConstraints{DeviceModels: []string{"iphone13pro"}, OSVersions: []string{"16.6"}}.appliesTo(iphone13))
+	require.False(t, quarantineConstraints{DeviceModels: []string{"iphone13pro"}}.appliesTo(iphone8))
+}
+
+func Test_applyingQuarantineEntries(t *testing.T) {
+	iphone8 := &testingapi.IosDevice{IosModelId: "iphone8", IosVersionId: "15.7"}
+	iphone13 := &testingapi.IosDevice{IosModelId: "iphone13pro", IosVersionId: "16.6"}
+
+	everywhere := quarantineEntry{skipTestIdentifiers: map[string][]string{"Tests": {"Tests/testEverywhere"}}}
+	oldOS := quarantineEntry{skipTestIdentifiers: map[string][]string{"Tests": {"Tests/testOldOS"}}, constraints: quarantineConstraints{OSVersions: []string{"15"}}}
+	ipad := quarantineEntry{skipTestIdentifiers: map[string][]string{"Tests": {"Tests/testIpad"}}, constraints: quarantineConstraints{DeviceModels: []string{"ipad10"}}}
+
+	entries := applyingQuarantineEntries([]quarantineEntry{everywhere, oldOS, ipad}, []*testingapi.IosDevice{iphone13, iphone8})
+	require.Equal(t, []quarantineEntry{everywhere}, entries)
+
+	entries = applyingQuarantineEntries([]quarantineEntry{everywhere, oldOS, ipad}, []*testingapi.IosDevice{iphone8})
+	require.Equal(t, []quarantineEntry{everywhere, oldOS}, entries)
+}
+
+func Test_createQuarantineReport(t *testing.T) {
+	xctestrun, _, err := parseXctestrun(filepath.Join("testdata", "BullsEye_RandomlyFailingTests_iphoneos18.2-arm64.xctestrun"))
+	require.NoError(t, err)
+	blueprintNames := xctestrunBlueprintNames(xctestrun)
+	require.Equal(t, []string{"BullsEyeUITests", "BullsEyeFailingTests", "BullsEyeTests", "BullsEyeSlowTests"}, blueprintNames)
+
+	applied := testquarantine.QuarantinedTest{TestCaseName: "testRandomlyFail()", TestSuiteName: []string{"BullsEyeFailingTests"}, ClassName: "BullsEyeRandomlyFailingTests"}
+	stale := testquarantine.QuarantinedTest{TestCaseName: "testRemoved()", TestSuiteName: []string{"RemovedTests"}, ClassName: "RemovedTests"}
+	noDevice := testquarantine.QuarantinedTest{TestCaseName: "testOldOS()", TestSuiteName: []string{"BullsEyeTests"}, ClassName: "BullsEyeTests"}
+	someDevices := testquarantine.QuarantinedTest{TestCaseName: "testIphone8()", TestSuiteName: []string{"BullsEyeTests"}, ClassName: "BullsEyeTests"}
+	droppedTest := testquarantine.QuarantinedTest{TestCaseName: "testNoClass()", TestSuiteName: []string{"BullsEyeTests"}}
+	expiredTest := testquarantine.QuarantinedTest{TestCaseName: "testExpired()", TestSuiteName: []string{"BullsEyeTests"}, ClassName: "BullsEyeTests"}
+	noMatch := testquarantine.QuarantinedTest{TestCaseName: "test*()", TestSuiteName: []string{"BullsEyeTests"}, ClassName: "Missing*"}
+
+	report := createQuarantineReport(
+		[]quarantineEntry{
+			{test: applied, testTarget: "BullsEyeFailingTests", skipTestIdentifiers: map[string][]string{"BullsEyeFailingTests": {"BullsEyeRandomlyFailingTests/testRandomlyFail"}}},
+			{test: stale, testTarget: "RemovedTests", skipTestIdentifiers: map[string][]string{"RemovedTests": {"RemovedTests/testRemoved"}}},
+			{test: noDevice, testTarget: "BullsEyeTests", skipTestIdentifiers: map[string][]string{"BullsEyeTests": {"BullsEyeTests/testOldOS"}}, constraints: quarantineConstraints{OSVersions: []string{"14"}}},
+			{test: someDevices, testTarget: "BullsEyeTests", skipTestIdentifiers: map[string][]string{"BullsEyeTests": {"BullsEyeTests/testIphone8"}}, constraints: quarantineConstraints{DeviceModels: []string{"iphone8"}}},
+			{test: noMatch, testTarget: "BullsEyeTests", testClass: "Missing*", testMethod: "test*", skipTestIdentifiers: map[string][]string{}},
+		},
+		[]ignoredQuarantineEntry{
+			{test: droppedTest, status: output.QuarantineEntryDropped, reason: "empty class name"},
+			{test: expiredTest, status: output.QuarantineEntryExpired, reason: "expired at 2025-06-01"},
+		},
+		blueprintNames,
+		[]*testingapi.IosDevice{{IosModelId: "iphone13pro", IosVersionId: "16.6"}, {IosModelId: "iphone8", IosVersionId: "15.7"}},
+	)
+
+	require.Equal(t, output.QuarantineReport{
+		Applied: 1,
+		Stale:   4,
+		Dropped: 1,
+		Expired: 1,
+		Entries: []output.QuarantineReportEntry{
+			{QuarantinedTest: applied, Status: output.QuarantineEntryApplied},
+			{QuarantinedTest: stale, Status: output.QuarantineEntryStale, Reason: "test target RemovedTests not found in the xctestrun files"},
+			{QuarantinedTest: noDevice, Status: output.QuarantineEntryStale, Reason: "no test device matches the entry's device models and OS versions"},
+			{QuarantinedTest: someDevices, Status: output.QuarantineEntryStale, Reason: "the entry applies to only some of the test devices, but the test devices run in a single test matrix"},
+			{QuarantinedTest: noMatch, Status: output.QuarantineEntryStale, Reason: "no test in the test bundle matches BullsEyeTests/Missing*/test*"},
+			{QuarantinedTest: droppedTest, Status: output.QuarantineEntryDropped, Reason: "empty class name"},
+			{QuarantinedTest: expiredTest, Status: output.QuarantineEntryExpired, Reason: "expired at 2025-06-01"},
+		},
+	}, report)
+}
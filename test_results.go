@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bitrise-io/go-utils/colorstring"
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/output"
+)
+
+const (
+	outcomeSuccess      = "success"
+	outcomeFailure      = "failure"
+	outcomeInconclusive = "inconclusive"
+	outcomeSkipped      = "skipped"
+	// outcomeFlaky is the outcome of a test run which passed after reattempting its failed tests.
+	outcomeFlaky = "flaky"
+)
+
+// deviceResult groups the test matrix steps (test runs) of a device dimension.
+type deviceResult struct {
+	dimensionID string
+	dimensions  map[string]string
+	steps       []*toolresults.Step
+}
+
+// collectDeviceResults groups the steps by device dimension, in the order of the dimensions' first step.
+func collectDeviceResults(steps []*toolresults.Step) []deviceResult {
+	var results []deviceResult
+	dimensionIDToIndex := map[string]int{}
+	for _, step := range steps {
+		dimensions := createDimensions(*step)
+		dimensionID := fmt.Sprintf("%s.%s.%s.%s", dimensions["Model"], dimensions["Version"], dimensions["Orientation"], dimensions["Locale"])
+
+		idx, ok := dimensionIDToIndex[dimensionID]
+		if !ok {
+			idx = len(results)
+			dimensionIDToIndex[dimensionID] = idx
+			results = append(results, deviceResult{dimensionID: dimensionID, dimensions: dimensions})
+		}
+		results[idx].steps = append(results[idx].steps, step)
+	}
+	return results
+}
+
+// assetName returns the device dimension in the test assets' naming format: iphone8-16.6-en-portrait
+func (r deviceResult) assetName() string {
+	return dimensionsAssetName(r.dimensions)
+}
+
+// stepAssetName returns the step's device dimension in the test assets' naming format.
+func stepAssetName(step *toolresults.Step) string {
+	return dimensionsAssetName(createDimensions(*step))
+}
+
+func dimensionsAssetName(dimensions map[string]string) string {
+	return assetDimension{
+		Model:       dimensions["Model"],
+		Version:     dimensions["Version"],
+		Locale:      dimensions["Locale"],
+		Orientation: dimensions["Orientation"],
+	}.name()
+}
+
+// isSuccess reports whether at least one step (test run) of the device was successful.
+func (r deviceResult) isSuccess() bool {
+	for _, step := range r.steps {
+		if isSuccessfulOutcome(stepOutcomeSummary(step)) {
+			return true
+		}
+	}
+	return false
+}
+
+// outcome returns the outcome of the device's successful step, or of its last step if none succeeded.
+func (r deviceResult) outcome() *toolresults.Outcome {
+	var outcome *toolresults.Outcome
+	for _, step := range r.steps {
+		outcome = step.Outcome
+		if isSuccessfulOutcome(stepOutcomeSummary(step)) {
+			break
+		}
+	}
+	return outcome
+}
+
+func stepOutcomeSummary(step *toolresults.Step) string {
+	if step.Outcome == nil {
+		return ""
+	}
+	return step.Outcome.Summary
+}
+
+func isSuccessfulOutcome(summary string) bool {
+	return summary != outcomeFailure && summary != outcomeInconclusive && summary != outcomeSkipped
+}
+
+// outcomeDetails returns the names of the set flags in the outcome's failure, inconclusive or skipped detail.
+func outcomeDetails(outcome *toolresults.Outcome) []string {
+	if outcome == nil {
+		return nil
+	}
+
+	var details []string
+	addIf := func(set bool, name string) {
+		if set {
+			details = append(details, name)
+		}
+	}
+
+	switch outcome.Summary {
+	case outcomeFailure:
+		if detail := outcome.FailureDetail; detail != nil {
+			addIf(detail.Crashed, "Crashed")
+			addIf(detail.NotInstalled, "NotInstalled")
+			addIf(detail.OtherNativeCrash, "OtherNativeCrash")
+			addIf(detail.TimedOut, "TimedOut")
+			addIf(detail.UnableToCrawl, "UnableToCrawl")
+		}
+	case outcomeInconclusive:
+		if detail := outcome.InconclusiveDetail; detail != nil {
+			addIf(detail.AbortedByUser, "AbortedByUser")
+			addIf(detail.InfrastructureFailure, "InfrastructureFailure")
+		}
+	case outcomeSkipped:
+		if detail := outcome.SkippedDetail; detail != nil {
+			addIf(detail.IncompatibleAppVersion, "IncompatibleAppVersion")
+			addIf(detail.IncompatibleArchitecture, "IncompatibleArchitecture")
+			addIf(detail.IncompatibleDevice, "IncompatibleDevice")
+		}
+	}
+
+	return details
+}
+
+// outcomeText returns the outcome summary followed by its details, for example: failure(Crashed)(TimedOut)
+func outcomeText(outcome *toolresults.Outcome) string {
+	if outcome == nil {
+		return ""
+	}
+
+	text := outcome.Summary
+	for _, detail := range outcomeDetails(outcome) {
+		text += "(" + detail + ")"
+	}
+	return text
+}
+
+// formatOutcome returns the colored outcomeText.
+func formatOutcome(outcome *toolresults.Outcome) string {
+	if outcome == nil {
+		return ""
+	}
+
+	formatted := outcomeText(outcome)
+	switch outcome.Summary {
+	case outcomeSuccess:
+		return colorstring.Green(formatted)
+	case outcomeFailure:
+		return colorstring.Red(formatted)
+	case outcomeInconclusive:
+		return colorstring.Yellow(formatted)
+	case outcomeSkipped:
+		return colorstring.Blue(formatted)
+	default:
+		return formatted
+	}
+}
+
+// printTestResults prints the outcome of each step (test run) as a table.
+func printTestResults(steps []*toolresults.Step, w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "Model\tOS version\tOrientation\tLocale\tOutcome\t"); err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		dimensions := createDimensions(*step)
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t\n", dimensions["Model"], dimensions["Version"], dimensions["Orientation"], dimensions["Locale"], formatOutcome(step.Outcome)); err != nil {
+			return err
+		}
+	}
+
+	return tw.Flush()
+}
+
+/*
+createRunResults summarizes the devices' test runs.
+
+The state durations of a device's steps are summed up: the queue time is the time spent pending, the run time is
+the time spent in progress. The attempt count is taken from the downloaded test assets (file name -> local path)
+when available (reruns of flaky tests happen within a step), otherwise it is the device's step count.
+*/
+func createRunResults(deviceResults []deviceResult, stepIDToStepStates map[string]stepStates, currentTime time.Time, downloadedPths map[string]string) output.RunResults {
+	nameToDeviceTestResult := map[string]output.DeviceTestResult{}
+	for _, deviceTestResult := range deviceTestResults(downloadedPths) {
+		nameToDeviceTestResult[deviceTestResult.Name] = deviceTestResult
+	}
+	nameToAssets := deviceAssets(downloadedPths)
+
+	results := output.RunResults{Success: true}
+	for _, deviceResult := range deviceResults {
+		outcome := deviceResult.outcome()
+		summary := ""
+		if outcome != nil {
+			summary = outcome.Summary
+		}
+
+		stateDurations := map[string]float64{}
+		var stateTransitions []output.StateTransition
+		for attempt, step := range deviceResult.steps {
+			states, ok := stepIDToStepStates[step.StepId]
+			if !ok {
+				continue
+			}
+			for state, duration := range states.durations(currentTime) {
+				stateDurations[state] += duration.Round(time.Second).Seconds()
+			}
+			for i, transition := range states.transitions {
+				stateTransitions = append(stateTransitions, output.StateTransition{
+					TestRun:  attempt + 1,
+					State:    transition.state,
+					Duration: states.transitionDuration(i, currentTime).Round(time.Second).Seconds(),
+				})
+			}
+		}
+
+		deviceRunResult := output.DeviceRunResult{
+			Dimension:        deviceResult.dimensionID,
+			Model:            deviceResult.dimensions["Model"],
+			OSVersion:        deviceResult.dimensions["Version"],
+			Locale:           deviceResult.dimensions["Locale"],
+			Orientation:      deviceResult.dimensions["Orientation"],
+			Outcome:          summary,
+			OutcomeDetails:   outcomeDetails(outcome),
+			Attempts:         len(deviceResult.steps),
+			StateDurations:   stateDurations,
+			QueueTime:        stateDurations[stepStatePending],
+			RunTime:          stateDurations[stepStateInProgress],
+			StateTransitions: stateTransitions,
+		}
+
+		name := assetDimension{
+			Model:       deviceRunResult.Model,
+			Version:     deviceRunResult.OSVersion,
+			Locale:      deviceRunResult.Locale,
+			Orientation: deviceRunResult.Orientation,
+		}.name()
+		if deviceTestResult, ok := nameToDeviceTestResult[name]; ok {
+			if deviceTestResult.Attempts > deviceRunResult.Attempts {
+				deviceRunResult.Attempts = deviceTestResult.Attempts
+			}
+			deviceRunResult.MergedTestResultXMLPath = deviceTestResult.MergedTestResultXMLPath
+		}
+		deviceRunResult.Assets = nameToAssets[name]
+
+		if !deviceResult.isSuccess() {
+			results.Success = false
+		}
+		results.Devices = append(results.Devices, deviceRunResult)
+	}
+
+	sort.SliceStable(results.Devices, func(i, j int) bool {
+		return results.Devices[i].Dimension < results.Devices[j].Dimension
+	})
+
+	return results
+}
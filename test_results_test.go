@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	toolresults "google.golang.org/api/toolresults/v1beta3"
+
+	"github.com/bitrise-steplib/steps-virtual-device-testing-for-ios/output"
+)
+
+func testStep(stepID, model string, outcome *toolresults.Outcome) *toolresults.Step {
+	return &toolresults.Step{
+		StepId: stepID,
+		Name:   "iOS Tests",
+		DimensionValue: []*toolresults.StepDimensionValueEntry{
+			{Key: "Model", Value: model},
+			{Key: "Version", Value: "16.6"},
+			{Key: "Orientation", Value: "portrait"},
+			{Key: "Locale", Value: "en"},
+		},
+		Outcome: outcome,
+	}
+}
+
+func Test_collectDeviceResults(t *testing.T) {
+	steps := []*toolresults.Step{
+		testStep("1", "iphone8", &toolresults.Outcome{Summary: "failure"}),
+		testStep("2", "iphone13pro", &toolresults.Outcome{Summary: "success"}),
+		testStep("3", "iphone8", &toolresults.Outcome{Summary: "success"}),
+		testStep("4", "ipad10", &toolresults.Outcome{Summary: "inconclusive"}),
+	}
+
+	results := collectDeviceResults(steps)
+
+	require.Len(t, results, 3)
+	require.Equal(t, "iphone8.16.6.portrait.en", results[0].dimensionID)
+	require.Len(t, results[0].steps, 2)
+	require.True(t, results[0].isSuccess())
+	require.Equal(t, "success", results[0].outcome().Summary)
+	require.True(t, results[1].isSuccess())
+	require.False(t, results[2].isSuccess())
+}
+
+func Test_formatOutcome(t *testing.T) {
+	outcome := &toolresults.Outcome{
+		Summary:       "failure",
+		FailureDetail: &toolresults.FailureDetail{Crashed: true, TimedOut: true},
+	}
+
+	require.Equal(t, []string{"Crashed", "TimedOut"}, outcomeDetails(outcome))
+	require.Contains(t, formatOutcome(outcome), "failure(Crashed)(TimedOut)")
+	require.Equal(t, "", formatOutcome(nil))
+}
+
+func Test_createRunResults(t *testing.T) {
+	steps := []*toolresults.Step{
+		testStep("1", "iphone8", &toolresults.Outcome{
+			Summary:            "inconclusive",
+			InconclusiveDetail: &toolresults.InconclusiveDetail{InfrastructureFailure: true},
+		}),
+		testStep("2", "iphone13pro", &toolresults.Outcome{Summary: "success"}),
+	}
+	stepIDToStepStates := map[string]stepStates{
+		"1": {
+			name: "iOS Tests",
+			transitions: []stateTransition{
+				{state: "pending", startTime: testRefTime()},
+				{state: "inProgress", startTime: testRefTime().Add(60 * time.Second)},
+				{state: "complete", startTime: testRefTime().Add(90 * time.Second)},
+			},
+		},
+	}
+	downloadedPths := map[string]string{
+		"iphone13pro-16.6-en-portrait_test_result_0.xml":         "dir/iphone13pro-16.6-en-portrait_test_result_0.xml",
+		"iphone13pro-16.6-en-portrait-rerun_2_test_result_0.xml": "dir/iphone13pro-16.6-en-portrait-rerun_2_test_result_0.xml",
+		"iphone13pro-16.6-en-portrait-test_results_merged.xml":   "dir/iphone13pro-16.6-en-portrait-test_results_merged.xml",
+		"iphone13pro-16.6-en-portrait_video.mp4":                 "dir/iphone13pro-16.6-en-portrait_video.mp4",
+	}
+
+	results := createRunResults(collectDeviceResults(steps), stepIDToStepStates, testRefTime().Add(90*time.Second), downloadedPths)
+
+	require.Equal(t, output.RunResults{
+		Success: false,
+		Devices: []output.DeviceRunResult{
+			{
+				Dimension:      "iphone13pro.16.6.portrait.en",
+				Model:          "iphone13pro",
+				OSVersion:      "16.6",
+				Locale:         "en",
+				Orientation:    "portrait",
+				Outcome:        "success",
+				Attempts:       3,
+				StateDurations: map[string]float64{},
+				Assets: []output.DeviceAsset{
+					{Name: "iphone13pro-16.6-en-portrait_video.mp4", Kind: "video", Path: "dir/iphone13pro-16.6-en-portrait_video.mp4"},
+				},
+				MergedTestResultXMLPath: "dir/iphone13pro-16.6-en-portrait-test_results_merged.xml",
+			},
+			{
+				Dimension:      "iphone8.16.6.portrait.en",
+				Model:          "iphone8",
+				OSVersion:      "16.6",
+				Locale:         "en",
+				Orientation:    "portrait",
+				Outcome:        "inconclusive",
+				OutcomeDetails: []string{"InfrastructureFailure"},
+				Attempts:       1,
+				StateDurations: map[string]float64{"pending": 60, "inProgress": 30, "complete": 0},
+				QueueTime:      60,
+				RunTime:        30,
+				StateTransitions: []output.StateTransition{
+					{TestRun: 1, State: "pending", Duration: 60},
+					{TestRun: 1, State: "inProgress", Duration: 30},
+					{TestRun: 1, State: "complete", Duration: 0},
+				},
+			},
+		},
+	}, results)
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+const (
+	// GCS requires chunk sizes to be a multiple of 256 KiB, except for the last chunk.
+	uploadChunkSize  = 32 * 256 * 1024
+	uploadMaxRetries = 5
+	uploadRetryWait  = 5 * time.Second
+	// uploadRequestTimeout limits the resumable session's requests, the single request upload is not limited.
+	uploadRequestTimeout   = 5 * time.Minute
+	uploadProgressInterval = 10 * time.Second
+	// uploadErrorBodyLimit limits the error response body read for the error details.
+	uploadErrorBodyLimit = 4 * 1024
+
+	statusResumeIncomplete = 308
+)
+
+var errResumableUploadNotSupported = errors.New("resumable upload is not supported by the upload url")
+
+type fileChecksums struct {
+	crc32c string
+	md5    string
+}
+
+// gcsHashHeader returns the checksums in the x-goog-hash header format.
+func (c fileChecksums) gcsHashHeader() string {
+	return fmt.Sprintf("crc32c=%s,md5=%s", c.crc32c, c.md5)
+}
+
+// verify compares the checksums to the ones the server calculated (x-goog-hash response header), if the server returned any.
+func (c fileChecksums) verify(hashHeader string) error {
+	for _, hash := range strings.Split(hashHeader, ",") {
+		algorithm, value, found := strings.Cut(strings.TrimSpace(hash), "=")
+		if !found {
+			continue
+		}
+
+		var expected string
+		switch algorithm {
+		case "crc32c":
+			expected = c.crc32c
+		case "md5":
+			expected = c.md5
+		default:
+			continue
+		}
+
+		if value != expected {
+			return fmt.Errorf("%s checksum mismatch: uploaded file has %s, stored object has %s", algorithm, expected, value)
+		}
+	}
+
+	return nil
+}
+
+type resumableUploader struct {
+	client           *http.Client
+	requestTimeout   time.Duration
+	chunkSize        int64
+	maxRetries       int
+	retryWait        time.Duration
+	progressInterval time.Duration
+}
+
+func newResumableUploader() resumableUploader {
+	return resumableUploader{
+		client:           &http.Client{},
+		requestTimeout:   uploadRequestTimeout,
+		chunkSize:        uploadChunkSize,
+		maxRetries:       uploadMaxRetries,
+		retryWait:        uploadRetryWait,
+		progressInterval: uploadProgressInterval,
+	}
+}
+
+// uploadStatus is the state of a resumable upload session.
+type uploadStatus struct {
+	// offset is the number of bytes persisted by the server.
+	offset    int64
+	completed bool
+	// hashHeader is the x-goog-hash header of the completed upload.
+	hashHeader string
+}
+
+// doWithTimeout sends the session request with the request timeout, the response body is closed.
+func (u resumableUploader) doWithTimeout(req *http.Request) (*http.Response, error) {
+	resp, _, err := u.doWithTimeoutReadingBody(req)
+	return resp, err
+}
+
+// doWithTimeoutReadingBody is doWithTimeout, which also returns the beginning of the response body.
+func (u resumableUploader) doWithTimeoutReadingBody(req *http.Request) (*http.Response, string, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), u.requestTimeout)
+	defer cancel()
+
+	resp, err := u.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, "", err
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, uploadErrorBodyLimit))
+	if err != nil {
+		log.Printf(" [!] Failed to read response body: %s", err)
+	}
+	closeResponse(resp)
+
+	return resp, string(body), nil
+}
+
+/*
+uploadFile uploads the archive in GCS resumable upload session style:
+- a session is started by a POST request with the `x-goog-resumable: start` header
+- the file is uploaded in chunks by PUT requests with `Content-Range` headers
+- after a failed chunk, the persisted offset is queried and the upload continues from there
+- the CRC32C and MD5 checksums are sent with the last chunk and compared to the stored object's checksums
+
+Upload URLs which do not support resumable sessions fall back to a single PUT request.
+*/
+func uploadFile(uploadURL string, archiveFilePath string) error {
+	return newResumableUploader().upload(uploadURL, archiveFilePath)
+}
+
+func (u resumableUploader) upload(uploadURL string, archiveFilePath string) error {
+	archFile, err := os.Open(archiveFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file for upload (%s): %s", archiveFilePath, err)
+	}
+	defer func() {
+		if err := archFile.Close(); err != nil {
+			log.Printf(" (!) Failed to close archive file (%s): %s", archiveFilePath, err)
+		}
+	}()
+
+	fileInfo, err := archFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get File Stats of the Archive file (%s): %s", archiveFilePath, err)
+	}
+	fileSize := fileInfo.Size()
+
+	checksums, err := calculateChecksums(archFile)
+	if err != nil {
+		return fmt.Errorf("failed to calculate checksums of the Archive file (%s): %s", archiveFilePath, err)
+	}
+
+	sessionURL, err := u.startSession(uploadURL)
+	if errors.Is(err, errResumableUploadNotSupported) {
+		log.Printf("Resumable upload is not available, uploading in a single request")
+		return u.uploadInSingleRequest(uploadURL, archFile, fileSize, checksums)
+	} else if err != nil {
+		return err
+	}
+
+	return u.uploadChunks(sessionURL, archFile, fileSize, checksums)
+}
+
+func (u resumableUploader) startSession(uploadURL string) (string, error) {
+	var lastErr error
+	for attempt := 0; attempt <= u.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(u.retryWait)
+		}
+
+		req, err := http.NewRequest("POST", uploadURL, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to create upload session request: %s", err)
+		}
+		req.Header.Set("x-goog-resumable", "start")
+
+		resp, body, err := u.doWithTimeoutReadingBody(req)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to start upload session: %s", err)
+			continue
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusOK:
+			sessionURL := resp.Header.Get("Location")
+			if sessionURL == "" {
+				return "", errResumableUploadNotSupported
+			}
+			return sessionURL, nil
+		case isResumableUploadRejected(resp.StatusCode, body):
+			return "", errResumableUploadNotSupported
+		case isRetryableStatus(resp.StatusCode):
+			lastErr = fmt.Errorf("failed to start upload session, response code was: %d", resp.StatusCode)
+		default:
+			return "", fmt.Errorf("failed to start upload session, response code was: %d, response: %s", resp.StatusCode, body)
+		}
+	}
+
+	return "", lastErr
+}
+
+/*
+isResumableUploadRejected tells if the session start response means the upload url doesn't support resumable sessions:
+- 405 and 501: the server doesn't accept the session start request
+- 403 with the SignatureDoesNotMatch error code: the url is signed for PUT requests only
+
+Other errors (like an expired or not authorized url) are returned, the single request upload would fail with them too.
+*/
+func isResumableUploadRejected(statusCode int, body string) bool {
+	switch statusCode {
+	case http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return true
+	case http.StatusForbidden:
+		return strings.Contains(body, "<Code>SignatureDoesNotMatch</Code>")
+	default:
+		return false
+	}
+}
+
+func (u resumableUploader) uploadChunks(sessionURL string, file io.ReaderAt, fileSize int64, checksums fileChecksums) error {
+	progress := newUploadProgress(fileSize, u.progressInterval)
+
+	var offset int64
+	retries := 0
+	for {
+		chunkSize := u.chunkSize
+		if offset+chunkSize > fileSize {
+			chunkSize = fileSize - offset
+		}
+		isLastChunk := offset+chunkSize == fileSize
+
+		resp, err := u.uploadChunk(sessionURL, file, offset, chunkSize, fileSize, isLastChunk, checksums)
+		if err == nil {
+			switch {
+			case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated:
+				return completeUpload(progress, checksums, resp.Header.Get("x-goog-hash"))
+			case resp.StatusCode == statusResumeIncomplete:
+				persistedOffset, rangeErr := persistedOffsetFromRangeHeader(resp.Header.Get("Range"))
+				if rangeErr != nil {
+					return rangeErr
+				}
+
+				// A response without progress counts as a failed attempt, so a stalled session can't loop forever
+				if persistedOffset > offset {
+					offset = persistedOffset
+					retries = 0
+					progress.update(offset)
+					continue
+				}
+				err = fmt.Errorf("no bytes persisted after offset %d", persistedOffset)
+			case !isRetryableStatus(resp.StatusCode):
+				return fmt.Errorf("failed to upload file, response code was: %d", resp.StatusCode)
+			default:
+				err = fmt.Errorf("response code was: %d", resp.StatusCode)
+			}
+		}
+
+		retries++
+		if retries > u.maxRetries {
+			return fmt.Errorf("failed to upload file after %d retries: %s", u.maxRetries, err)
+		}
+
+		log.Warnf("Failed to upload chunk at offset %d: %s, retrying in %s (%d/%d)", offset, err, u.retryWait, retries, u.maxRetries)
+		time.Sleep(u.retryWait)
+
+		status, err := u.queryUploadStatus(sessionURL, fileSize)
+		if err != nil {
+			log.Warnf("Failed to query upload status: %s", err)
+			continue
+		}
+		if status.completed {
+			return completeUpload(progress, checksums, status.hashHeader)
+		}
+
+		offset = status.offset
+		progress.update(offset)
+	}
+}
+
+// completeUpload reports the finished upload and compares the checksums to the stored object's checksums.
+func completeUpload(progress *uploadProgress, checksums fileChecksums, hashHeader string) error {
+	progress.done()
+	if err := checksums.verify(hashHeader); err != nil {
+		return fmt.Errorf("uploaded file is corrupted: %s", err)
+	}
+	return nil
+}
+
+func (u resumableUploader) uploadChunk(sessionURL string, file io.ReaderAt, offset, chunkSize, fileSize int64, isLastChunk bool, checksums fileChecksums) (*http.Response, error) {
+	req, err := http.NewRequest("PUT", sessionURL, io.NewSectionReader(file, offset, chunkSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload request: %s", err)
+	}
+
+	req.ContentLength = chunkSize
+	if chunkSize > 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+chunkSize-1, fileSize))
+	} else {
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", fileSize))
+	}
+	if isLastChunk {
+		req.Header.Set("x-goog-hash", checksums.gcsHashHeader())
+	}
+
+	return u.doWithTimeout(req)
+}
+
+// queryUploadStatus asks the server how many bytes of the session are stored.
+func (u resumableUploader) queryUploadStatus(sessionURL string, fileSize int64) (uploadStatus, error) {
+	req, err := http.NewRequest("PUT", sessionURL, nil)
+	if err != nil {
+		return uploadStatus{}, fmt.Errorf("failed to create upload status request: %s", err)
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", fileSize))
+
+	resp, err := u.doWithTimeout(req)
+	if err != nil {
+		return uploadStatus{}, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return uploadStatus{offset: fileSize, completed: true, hashHeader: resp.Header.Get("x-goog-hash")}, nil
+	case statusResumeIncomplete:
+		offset, err := persistedOffsetFromRangeHeader(resp.Header.Get("Range"))
+		return uploadStatus{offset: offset}, err
+	default:
+		return uploadStatus{}, fmt.Errorf("response code was: %d", resp.StatusCode)
+	}
+}
+
+/*
+uploadInSingleRequest uploads the whole file in a PUT request, without a request timeout: large files may take long.
+The MD5 checksum is sent in the Content-MD5 header, so the server rejects a corrupted upload.
+A failed request can't be resumed, the retry uploads the whole file again.
+*/
+func (u resumableUploader) uploadInSingleRequest(uploadURL string, file io.ReaderAt, fileSize int64, checksums fileChecksums) error {
+	var lastErr error
+	for attempt := 0; attempt <= u.maxRetries; attempt++ {
+		if attempt > 0 {
+			log.Warnf("Failed to upload file: %s, retrying in %s (%d/%d)", lastErr, u.retryWait, attempt, u.maxRetries)
+			time.Sleep(u.retryWait)
+		}
+
+		progress := newUploadProgress(fileSize, u.progressInterval)
+		body := &uploadProgressReader{reader: io.NewSectionReader(file, 0, fileSize), progress: progress}
+		req, err := http.NewRequest("PUT", uploadURL, body)
+		if err != nil {
+			return fmt.Errorf("failed to create upload request: %s", err)
+		}
+
+		req.Header.Add("Content-Length", strconv.FormatInt(fileSize, 10))
+		req.Header.Set("Content-MD5", checksums.md5)
+		req.ContentLength = fileSize
+
+		resp, err := u.client.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to upload: %s", err)
+			continue
+		}
+		closeResponse(resp)
+
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+			return completeUpload(progress, checksums, resp.Header.Get("x-goog-hash"))
+		}
+
+		lastErr = fmt.Errorf("failed to upload file, response code was: %d", resp.StatusCode)
+		if !isRetryableStatus(resp.StatusCode) {
+			return lastErr
+		}
+	}
+
+	return lastErr
+}
+
+// persistedOffsetFromRangeHeader converts the `Range: bytes=0-1023` header to the next offset to upload (1024).
+// A missing header means no bytes are persisted yet.
+func persistedOffsetFromRangeHeader(rangeHeader string) (int64, error) {
+	if rangeHeader == "" {
+		return 0, nil
+	}
+
+	_, lastByte, found := strings.Cut(strings.TrimPrefix(rangeHeader, "bytes="), "-")
+	if !found {
+		return 0, fmt.Errorf("invalid Range header: %s", rangeHeader)
+	}
+
+	lastByteIdx, err := strconv.ParseInt(lastByte, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Range header: %s", rangeHeader)
+	}
+
+	return lastByteIdx + 1, nil
+}
+
+func calculateChecksums(file io.ReadSeeker) (fileChecksums, error) {
+	crc32cHash := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	md5Hash := md5.New()
+
+	if _, err := io.Copy(io.MultiWriter(crc32cHash, md5Hash), file); err != nil {
+		return fileChecksums{}, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fileChecksums{}, err
+	}
+
+	return fileChecksums{
+		crc32c: base64.StdEncoding.EncodeToString(crc32cHash.Sum(nil)),
+		md5:    base64.StdEncoding.EncodeToString(md5Hash.Sum(nil)),
+	}, nil
+}
+
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+func closeResponse(resp *http.Response) {
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		log.Printf(" [!] Failed to read response body: %s", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		log.Printf(" [!] Failed to close response body: %s", err)
+	}
+}
+
+type uploadProgress struct {
+	total     int64
+	interval  time.Duration
+	startTime time.Time
+	lastPrint time.Time
+}
+
+func newUploadProgress(total int64, interval time.Duration) *uploadProgress {
+	now := time.Now()
+	return &uploadProgress{
+		total:     total,
+		interval:  interval,
+		startTime: now,
+		lastPrint: now,
+	}
+}
+
+func (p *uploadProgress) update(uploaded int64) {
+	if time.Since(p.lastPrint) < p.interval {
+		return
+	}
+	p.lastPrint = time.Now()
+	log.Printf("- %s", p.message(uploaded, p.lastPrint))
+}
+
+func (p *uploadProgress) done() {
+	log.Printf("- %s", p.message(p.total, time.Now()))
+}
+
+func (p *uploadProgress) message(uploaded int64, now time.Time) string {
+	percent := 100.0
+	if p.total > 0 {
+		percent = float64(uploaded) / float64(p.total) * 100
+	}
+
+	var throughput int64
+	if elapsed := now.Sub(p.startTime).Seconds(); elapsed > 0 {
+		throughput = int64(float64(uploaded) / elapsed)
+	}
+
+	return fmt.Sprintf("uploaded %s / %s (%.0f%%), %s/s", formatBytes(uploaded), formatBytes(p.total), percent, formatBytes(throughput))
+}
+
+// uploadProgressReader reports the progress of the request body read by the http client.
+type uploadProgressReader struct {
+	reader   io.Reader
+	progress *uploadProgress
+	read     int64
+}
+
+func (r *uploadProgressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	r.progress.update(r.read)
+	return n, err
+}
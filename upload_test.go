@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeResumableUploadServer implements the parts of the GCS resumable upload protocol the uploader uses.
+type fakeResumableUploadServer struct {
+	mu                  sync.Mutex
+	content             []byte
+	failedChunkRequests int
+	failChunkAtOffset   int64
+	supportsResumable   bool
+	corruptHash         bool
+	// stalled sessions answer every chunk with 308 without persisting it.
+	stalled bool
+	// failCompletingChunk persists the last chunk, but answers it with 503.
+	failCompletingChunk bool
+	// singleRequestStatus is the success status of the single request upload, 200 by default.
+	singleRequestStatus int
+	// sessionStartStatus and sessionStartErrorCode reject the session start if resumable upload is not supported,
+	// 403 SignatureDoesNotMatch (an url signed for PUT requests) by default.
+	sessionStartStatus    int
+	sessionStartErrorCode string
+}
+
+func (s *fakeResumableUploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+
+	switch {
+	case r.Method == "POST" && r.Header.Get("x-goog-resumable") == "start":
+		if !s.supportsResumable {
+			status, errorCode := http.StatusForbidden, "SignatureDoesNotMatch"
+			if s.sessionStartStatus != 0 {
+				status, errorCode = s.sessionStartStatus, s.sessionStartErrorCode
+			}
+			w.WriteHeader(status)
+			_, _ = fmt.Fprintf(w, "<?xml version='1.0' encoding='UTF-8'?><Error><Code>%s</Code></Error>", errorCode)
+			return
+		}
+		w.Header().Set("Location", "http://"+r.Host+"/session")
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PUT" && r.URL.Path == "/session":
+		contentRange := strings.TrimPrefix(r.Header.Get("Content-Range"), "bytes ")
+		byteRange, totalStr, _ := strings.Cut(contentRange, "/")
+		total, _ := strconv.ParseInt(totalStr, 10, 64)
+
+		if byteRange != "*" && s.stalled {
+			w.WriteHeader(statusResumeIncomplete)
+			return
+		}
+
+		if byteRange != "*" {
+			startStr, _, _ := strings.Cut(byteRange, "-")
+			start, _ := strconv.ParseInt(startStr, 10, 64)
+
+			if start == s.failChunkAtOffset && s.failedChunkRequests == 0 {
+				// Only the first half of the chunk is persisted before the failure
+				s.content = append(s.content[:start], body[:len(body)/2]...)
+				s.failedChunkRequests++
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			s.content = append(s.content[:start], body...)
+
+			if int64(len(s.content)) == total && s.failCompletingChunk {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		if int64(len(s.content)) == total {
+			s.writeHashHeader(w, r)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if len(s.content) > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(s.content)-1))
+		}
+		w.WriteHeader(statusResumeIncomplete)
+	case r.Method == "PUT":
+		checksums, _ := calculateChecksums(bytes.NewReader(body))
+		if r.Header.Get("Content-MD5") != checksums.md5 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		s.content = body
+		s.writeHashHeader(w, r)
+		if s.singleRequestStatus != 0 {
+			w.WriteHeader(s.singleRequestStatus)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (s *fakeResumableUploadServer) writeHashHeader(w http.ResponseWriter, r *http.Request) {
+	checksums, _ := calculateChecksums(bytes.NewReader(s.content))
+	if s.corruptHash {
+		checksums.md5 = "corrupted"
+	}
+	w.Header().Set("x-goog-hash", checksums.gcsHashHeader())
+}
+
+func Test_resumableUploader_upload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+
+	tests := []struct {
+		name    string
+		server  *fakeResumableUploadServer
+		wantErr string
+	}{
+		{
+			name:   "Chunked upload",
+			server: &fakeResumableUploadServer{supportsResumable: true, failChunkAtOffset: -1},
+		},
+		{
+			name:   "Chunked upload resumes after a failed chunk",
+			server: &fakeResumableUploadServer{supportsResumable: true, failChunkAtOffset: 4096},
+		},
+		{
+			name:   "Falls back to single request upload",
+			server: &fakeResumableUploadServer{supportsResumable: false, failChunkAtOffset: -1},
+		},
+		{
+			name:   "Falls back to single request upload if the session start is not allowed",
+			server: &fakeResumableUploadServer{supportsResumable: false, failChunkAtOffset: -1, sessionStartStatus: http.StatusMethodNotAllowed},
+		},
+		{
+			name:    "Session start authorization error",
+			server:  &fakeResumableUploadServer{supportsResumable: false, failChunkAtOffset: -1, sessionStartStatus: http.StatusForbidden, sessionStartErrorCode: "AccessDenied"},
+			wantErr: "failed to start upload session, response code was: 403",
+		},
+		{
+			name:   "Single request upload accepts 201",
+			server: &fakeResumableUploadServer{supportsResumable: false, failChunkAtOffset: -1, singleRequestStatus: http.StatusCreated},
+		},
+		{
+			name:    "Checksum mismatch",
+			server:  &fakeResumableUploadServer{supportsResumable: true, failChunkAtOffset: -1, corruptHash: true},
+			wantErr: "md5 checksum mismatch",
+		},
+		{
+			name:   "Upload completed while retrying the last chunk",
+			server: &fakeResumableUploadServer{supportsResumable: true, failChunkAtOffset: -1, failCompletingChunk: true},
+		},
+		{
+			name:    "Checksum mismatch of the upload completed while retrying the last chunk",
+			server:  &fakeResumableUploadServer{supportsResumable: true, failChunkAtOffset: -1, failCompletingChunk: true, corruptHash: true},
+			wantErr: "md5 checksum mismatch",
+		},
+		{
+			name:    "Session without progress",
+			server:  &fakeResumableUploadServer{supportsResumable: true, failChunkAtOffset: -1, stalled: true},
+			wantErr: "failed to upload file after 2 retries: no bytes persisted after offset 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.server)
+			defer server.Close()
+
+			pth := filepath.Join(t.TempDir(), "testbundle.zip")
+			require.NoError(t, os.WriteFile(pth, content, 0644))
+
+			uploader := resumableUploader{
+				client:           server.Client(),
+				requestTimeout:   time.Minute,
+				chunkSize:        2048,
+				maxRetries:       2,
+				retryWait:        time.Millisecond,
+				progressInterval: time.Hour,
+			}
+
+			err := uploader.upload(server.URL+"/upload", pth)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, content, tt.server.content)
+		})
+	}
+}
+
+func Test_persistedOffsetFromRangeHeader(t *testing.T) {
+	offset, err := persistedOffsetFromRangeHeader("bytes=0-1023")
+	require.NoError(t, err)
+	require.Equal(t, int64(1024), offset)
+
+	offset, err = persistedOffsetFromRangeHeader("")
+	require.NoError(t, err)
+	require.Equal(t, int64(0), offset)
+
+	_, err = persistedOffsetFromRangeHeader("bytes=invalid")
+	require.Error(t, err)
+}